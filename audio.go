@@ -0,0 +1,363 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"github.com/golang/protobuf/proto"
+	"io"
+	"math"
+)
+
+const (
+	MIME_TYPE_WAVEFORM = "application/x-waveform"
+
+	PREVIEW_WAVEFORM_LENGTH = 128 // Maximum number of levels
+	PREVIEW_WAVEFORM_HEIGHT = 255 // Loudest level
+	PREVIEW_WAVEFORM_RANGE  = 60  // Decibels between the loudest level and silence
+)
+
+var ErrNoAudioFrames = errors.New("No MPEG audio frames")
+
+var (
+	mpegBitrates = [2][3][16]uint32{
+		// MPEG-1
+		{
+			{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+			{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+		},
+		// MPEG-2 and MPEG-2.5
+		{
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		},
+	}
+	mpegSampleRates = [4][3]uint32{
+		{11025, 12000, 8000},  // MPEG-2.5
+		{},                    // Reserved
+		{22050, 24000, 16000}, // MPEG-2
+		{44100, 48000, 32000}, // MPEG-1
+	}
+)
+
+// mpegFrame is the header of an MPEG audio frame.
+type mpegFrame struct {
+	version    int // 0 = MPEG-2.5, 2 = MPEG-2, 3 = MPEG-1
+	layer      int // 1, 2 or 3
+	protected  bool
+	bitrate    uint32 // Bits per second
+	sampleRate uint32
+	padding    bool
+	mono       bool
+}
+
+// parseMPEGFrame parses the given 4 byte frame header, returning false if it is not valid or uses the unsupported free format bitrate.
+func parseMPEGFrame(header []byte) (*mpegFrame, bool) {
+	if !sniffMPEGAudioFrame(header) || len(header) < 4 {
+		return nil, false
+	}
+	f := &mpegFrame{
+		version:   int(header[1]>>3) & 3,
+		layer:     4 - int(header[1]>>1)&3,
+		protected: header[1]&1 == 0,
+		padding:   header[2]&2 != 0,
+		mono:      header[3]>>6 == 3,
+	}
+	b := header[2] >> 4
+	s := (header[2] >> 2) & 3
+	if b == 0 || b == 15 || s == 3 {
+		return nil, false
+	}
+	table := 0
+	if f.version != 3 {
+		table = 1
+	}
+	f.bitrate = mpegBitrates[table][f.layer-1][b] * 1000
+	f.sampleRate = mpegSampleRates[f.version][s]
+	return f, true
+}
+
+// samples returns the number of samples per channel in the frame.
+func (f *mpegFrame) samples() uint32 {
+	switch {
+	case f.layer == 1:
+		return 384
+	case f.layer == 3 && f.version != 3:
+		return 576
+	default:
+		return 1152
+	}
+}
+
+// length returns the number of bytes in the frame, including the header.
+func (f *mpegFrame) length() int {
+	padding := uint32(0)
+	if f.padding {
+		padding = 1
+	}
+	if f.layer == 1 {
+		return int((12*f.bitrate/f.sampleRate + padding) * 4)
+	}
+	return int(f.samples()/8*f.bitrate/f.sampleRate + padding)
+}
+
+// sideInfo returns the offset and length of the Layer III side information in the frame.
+func (f *mpegFrame) sideInfo() (int, int) {
+	offset := 4
+	if f.protected {
+		offset += 2
+	}
+	switch {
+	case f.version == 3 && f.mono:
+		return offset, 17
+	case f.version == 3:
+		return offset, 32
+	case f.mono:
+		return offset, 9
+	default:
+		return offset, 17
+	}
+}
+
+// gains returns the global gain of each granule and channel in the given Layer III frame, or -1 for those which are silent.
+func (f *mpegFrame) gains(frame []byte) []int {
+	offset, length := f.sideInfo()
+	if len(frame) < offset+length {
+		return nil
+	}
+	r := &bitReader{data: frame[offset : offset+length]}
+	channels, granules := 2, 2
+	if f.mono {
+		channels = 1
+	}
+	if f.version == 3 {
+		// Main data begin, private bits, scale factor selection
+		r.skip(9)
+		r.skip(7 - 2*channels)
+		r.skip(4 * channels)
+	} else {
+		granules = 1
+		r.skip(8)
+		r.skip(channels)
+	}
+	var gains []int
+	for g := 0; g < granules; g++ {
+		for c := 0; c < channels; c++ {
+			part23 := r.read(12)
+			r.skip(9) // Big values
+			gain := int(r.read(8))
+			if part23 == 0 {
+				// No Huffman coded samples
+				gain = -1
+			}
+			gains = append(gains, gain)
+			if f.version == 3 {
+				r.skip(59 - 29)
+			} else {
+				r.skip(63 - 29)
+			}
+		}
+	}
+	return gains
+}
+
+// isInfo returns true if the given Layer III frame holds a Xing or Info header rather than audio.
+func (f *mpegFrame) isInfo(frame []byte) bool {
+	offset, length := f.sideInfo()
+	offset += length
+	if len(frame) < offset+4 {
+		return false
+	}
+	tag := frame[offset : offset+4]
+	return bytes.Equal(tag, []byte("Xing")) || bytes.Equal(tag, []byte("Info"))
+}
+
+// GenerateAudioPreview reads the frames of the given MPEG audio content and creates a Waveform with the duration, average bitrate, channels and gain envelope.
+// The samples are not decoded, so the Waveform's Peaks are not sample peaks but a gain envelope; the level of each interval is the loudest global gain of its Layer III granules, which sets the scale of the decoded samples.
+// The envelope follows the loudness of the audio but not individual transients, and frames of other layers count towards the duration and bitrate, but not the envelope.
+func GenerateAudioPreview(meta *Meta, content io.Reader) (*Preview, error) {
+	reader := bufio.NewReader(content)
+	if err := skipID3(reader); err != nil {
+		return nil, err
+	}
+	var (
+		first   *mpegFrame
+		samples uint64
+		bits    float64
+		gains   []int
+		frame   []byte
+		stereo  bool
+	)
+	for {
+		header, err := reader.Peek(4)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		f, ok := parseMPEGFrame(header)
+		if ok && first != nil && (f.version != first.version || f.layer != first.layer || f.sampleRate != first.sampleRate) {
+			ok = false
+		}
+		if !ok {
+			// Resynchronize on the next byte
+			if _, err := reader.Discard(1); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		length := f.length()
+		if cap(frame) < length {
+			frame = make([]byte, length)
+		}
+		frame = frame[:length]
+		if _, err := io.ReadFull(reader, frame); err == io.ErrUnexpectedEOF {
+			// Truncated final frame
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if f.layer == 3 && first == nil && f.isInfo(frame) {
+			continue
+		}
+		if first == nil {
+			first = f
+		}
+		if !f.mono {
+			stereo = true
+		}
+		samples += uint64(f.samples())
+		bits += float64(f.bitrate) * float64(f.samples()) / float64(f.sampleRate)
+		if f.layer == 3 {
+			gain := -1
+			for _, g := range f.gains(frame) {
+				if g > gain {
+					gain = g
+				}
+			}
+			gains = append(gains, gain)
+		}
+	}
+	if first == nil {
+		return nil, ErrNoAudioFrames
+	}
+	channels := uint32(1)
+	if stereo {
+		channels = 2
+	}
+	seconds := float64(samples) / float64(first.sampleRate)
+	waveform := &Waveform{
+		Duration:   uint64(math.Round(seconds * 1000)),
+		Bitrate:    uint32(math.Round(bits / seconds)),
+		Channels:   channels,
+		SampleRate: first.sampleRate,
+		Peaks:      gainEnvelope(gains, PREVIEW_WAVEFORM_LENGTH),
+	}
+	data, err := proto.Marshal(waveform)
+	if err != nil {
+		return nil, err
+	}
+	return &Preview{
+		Type:   MIME_TYPE_WAVEFORM,
+		Data:   data,
+		Width:  uint32(len(waveform.Peaks)),
+		Height: PREVIEW_WAVEFORM_HEIGHT,
+	}, nil
+}
+
+// gainEnvelope divides the given global gains into at most the given number of intervals and scales the loudest of each interval to a level.
+// Each step of global gain is 1.5dB, and levels more than PREVIEW_WAVEFORM_RANGE below the loudest are silent.
+func gainEnvelope(gains []int, length int) []byte {
+	if len(gains) == 0 {
+		return nil
+	}
+	if len(gains) < length {
+		length = len(gains)
+	}
+	maximums := make([]int, length)
+	loudest := -1
+	for i := range maximums {
+		m := -1
+		for _, g := range gains[i*len(gains)/length : (i+1)*len(gains)/length] {
+			if g > m {
+				m = g
+			}
+		}
+		maximums[i] = m
+		if m > loudest {
+			loudest = m
+		}
+	}
+	levels := make([]byte, length)
+	for i, m := range maximums {
+		if m < 0 {
+			continue
+		}
+		db := 1.5 * float64(m-loudest)
+		level := PREVIEW_WAVEFORM_HEIGHT * (1 + db/PREVIEW_WAVEFORM_RANGE)
+		if level > 0 {
+			levels[i] = byte(math.Round(level))
+		}
+	}
+	return levels
+}
+
+// skipID3 discards an ID3v2 tag from the start of the given reader, if present.
+func skipID3(reader *bufio.Reader) error {
+	header, err := reader.Peek(10)
+	if err != nil || !bytes.Equal(header[:3], []byte("ID3")) {
+		// No tag
+		return nil
+	}
+	// Tag size is a 28 bit synchsafe integer
+	size := int(header[6]&0x7F)<<21 | int(header[7]&0x7F)<<14 | int(header[8]&0x7F)<<7 | int(header[9]&0x7F)
+	size += 10
+	if header[5]&0x10 != 0 {
+		// Footer present
+		size += 10
+	}
+	if _, err := reader.Discard(size); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// bitReader reads big endian bit fields, returning zero past the end of the data.
+type bitReader struct {
+	data     []byte
+	position int
+}
+
+func (r *bitReader) read(bits int) uint32 {
+	var value uint32
+	for i := 0; i < bits; i++ {
+		value <<= 1
+		if index := r.position / 8; index < len(r.data) {
+			value |= uint32(r.data[index]>>(7-uint(r.position%8))) & 1
+		}
+		r.position++
+	}
+	return value
+}
+
+func (r *bitReader) skip(bits int) {
+	r.position += bits
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego_test
+
+import (
+	"aletheiaware.com/spacego"
+	"aletheiaware.com/testinggo"
+	"bytes"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+// testFrame returns an MPEG-1 Layer III mono frame at 128kbps and 44.1kHz with the given global gain in both granules, or silent if negative.
+func testFrame(gain int) []byte {
+	frame := make([]byte, 417)
+	copy(frame, []byte{0xFF, 0xFB, 0x90, 0xC0})
+	side := frame[4:21]
+	set := func(position, bits int, value uint32) {
+		for i := 0; i < bits; i++ {
+			if value>>uint(bits-1-i)&1 == 1 {
+				side[(position+i)/8] |= 0x80 >> uint((position+i)%8)
+			}
+		}
+	}
+	if gain >= 0 {
+		for _, granule := range []int{18, 77} {
+			set(granule, 12, 100)            // Part 2 & 3 length
+			set(granule+21, 8, uint32(gain)) // Global gain
+		}
+	}
+	return frame
+}
+
+func TestGenerateAudioPreview(t *testing.T) {
+	var content bytes.Buffer
+	// ID3v2 tag with 5 bytes of data
+	content.Write([]byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 5, 1, 2, 3, 4, 5})
+	// Xing header frame
+	info := testFrame(-1)
+	copy(info[21:], "Xing")
+	content.Write(info)
+	content.Write(testFrame(210))
+	// Junk between frames
+	content.Write([]byte{0x00, 0xFF, 0x00})
+	content.Write(testFrame(200))
+	content.Write(testFrame(-1))
+	content.Write(testFrame(170))
+	// Truncated frame
+	content.Write(testFrame(210)[:100])
+
+	preview, err := spacego.GeneratePreview(&spacego.Meta{
+		Name: "podcast.mp3",
+		Type: spacego.MIME_TYPE_AUDIO_MPEG,
+	}, &content)
+	testinggo.AssertNoError(t, err)
+	assert.Equal(t, spacego.MIME_TYPE_WAVEFORM, preview.Type)
+	assert.Equal(t, uint32(4), preview.Width)
+	assert.Equal(t, uint32(spacego.PREVIEW_WAVEFORM_HEIGHT), preview.Height)
+
+	waveform := &spacego.Waveform{}
+	testinggo.AssertNoError(t, proto.Unmarshal(preview.Data, waveform))
+	assert.Equal(t, uint64(104), waveform.Duration)
+	assert.Equal(t, uint32(128000), waveform.Bitrate)
+	assert.Equal(t, uint32(1), waveform.Channels)
+	assert.Equal(t, uint32(44100), waveform.SampleRate)
+	// A gain of 200 is 15dB quieter than 210, and 170 is 60dB quieter
+	assert.Equal(t, []byte{255, 191, 0, 0}, waveform.Peaks)
+}
+
+func TestGenerateAudioPreview_NoFrames(t *testing.T) {
+	_, err := spacego.GeneratePreview(&spacego.Meta{
+		Name: "empty.mp3",
+		Type: spacego.MIME_TYPE_AUDIO_MPEG,
+	}, strings.NewReader("not audio"))
+	assert.Equal(t, spacego.ErrNoAudioFrames, err)
+}
@@ -0,0 +1,173 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego
+
+import (
+	"aletheiaware.com/bcgo"
+	"aletheiaware.com/cryptogo"
+	"aletheiaware.com/financego"
+	"bytes"
+	"log"
+)
+
+// AuditResult is the outcome of comparing a channel held locally with the copy held by a registrar.
+type AuditResult struct {
+	Host       string
+	Channel    string
+	LocalHead  []byte
+	RemoteHead []byte
+	Missing    [][]byte // Hashes of local blocks the host does not hold
+	Divergent  [][]byte // Hashes of local blocks for which the host returned a different block
+	Forked     bool     // True if the head of the host is not a block of the local chain
+	Repaired   bool     // True if the local head was pushed to the host to restore missing blocks
+	Error      error    // Set if the local channel could not be read or a repair failed
+}
+
+// Ok returns true if the host holds every local block unaltered and its head is part of the local chain.
+func (r *AuditResult) Ok() bool {
+	return len(r.Missing) == 0 && len(r.Divergent) == 0 && !r.Forked && r.Error == nil
+}
+
+// AuditBlocks queries the host for each of the given block hashes of the given channel, newest first, and reports those it is missing or holds a different block for.
+func AuditBlocks(host, channel string, hashes [][]byte, network bcgo.Network) *AuditResult {
+	result := &AuditResult{
+		Host:    host,
+		Channel: channel,
+	}
+	if len(hashes) > 0 {
+		result.LocalHead = hashes[0]
+	}
+	if reference, err := network.Head(channel); err == nil && reference != nil {
+		result.RemoteHead = reference.BlockHash
+	}
+	known := false
+	for _, hash := range hashes {
+		if bytes.Equal(hash, result.RemoteHead) {
+			known = true
+		}
+		block, err := network.Block(&bcgo.Reference{
+			ChannelName: channel,
+			BlockHash:   hash,
+		})
+		if err != nil || block == nil {
+			result.Missing = append(result.Missing, hash)
+			continue
+		}
+		h, err := cryptogo.HashProtobuf(block)
+		if err != nil || !bytes.Equal(h, hash) {
+			result.Divergent = append(result.Divergent, hash)
+		}
+	}
+	result.Forked = result.RemoteHead != nil && !known
+	return result
+}
+
+// AuditChannels returns the channels of the node's alias which registrars are paid to store; its Meta and Rule channels, and the Delta, Preview, and Tag channels of every file, including those in the trash.
+// Channels are loaded from the cache only, so they reflect what is held locally.
+func AuditChannels(node bcgo.Node) ([]bcgo.Channel, error) {
+	alias := node.Account().Alias()
+	metas, h, err := openMetaHierarchy(node)
+	if err != nil {
+		return nil, err
+	}
+	channels := []bcgo.Channel{
+		metas,
+		node.OpenChannel(RuleChannelName(alias), func() bcgo.Channel {
+			return OpenRuleChannel(alias)
+		}),
+	}
+	for _, id := range h.all() {
+		metaId := id
+		channels = append(channels,
+			node.OpenChannel(DeltaChannelName(metaId), func() bcgo.Channel {
+				return OpenDeltaChannel(metaId)
+			}),
+			node.OpenChannel(PreviewChannelName(metaId), func() bcgo.Channel {
+				return OpenPreviewChannel(metaId)
+			}),
+			node.OpenChannel(TagChannelName(metaId), func() bcgo.Channel {
+				return OpenTagChannel(metaId)
+			}),
+		)
+	}
+	for _, c := range channels[1:] {
+		if err := c.Load(node.Cache(), nil); err != nil {
+			log.Println(err)
+		}
+	}
+	return channels, nil
+}
+
+// Audit compares every block of the channels given by AuditChannels with the copies held by each registrar the node is registered with, using the network returned by the given function for the registrar's domain.
+// The given callback is triggered with the result for each channel and registrar; if a channel cannot be read locally its results hold the error and the remaining channels are still audited.
+// If repair is true the local head is pushed to any registrar missing blocks, so it can fetch them; divergent and forked channels are reported but not changed.
+func Audit(node bcgo.Node, network func(host string) bcgo.Network, repair bool, callback func(*AuditResult) error) error {
+	var hosts []string
+	if err := AllRegistrarsForNode(node, func(registrar *Registrar, registration *financego.Registration, subscription *financego.Subscription) error {
+		hosts = append(hosts, registrar.Merchant.Domain)
+		return nil
+	}); err != nil {
+		return err
+	}
+	channels, err := AuditChannels(node)
+	if err != nil {
+		return err
+	}
+	cache := node.Cache()
+	for _, channel := range channels {
+		head := channel.Head()
+		if head == nil {
+			continue
+		}
+		var hashes [][]byte
+		if err := bcgo.Iterate(channel.Name(), head, nil, cache, nil, func(hash []byte, block *bcgo.Block) error {
+			hashes = append(hashes, hash)
+			return nil
+		}); err != nil {
+			for _, host := range hosts {
+				if err := callback(&AuditResult{
+					Host:      host,
+					Channel:   channel.Name(),
+					LocalHead: head,
+					Error:     err,
+				}); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		for _, host := range hosts {
+			n := network(host)
+			result := AuditBlocks(host, channel.Name(), hashes, n)
+			if repair && len(result.Missing) > 0 {
+				block, err := cache.Block(head)
+				if err == nil {
+					err = n.Broadcast(channel, cache, head, block)
+				}
+				if err != nil {
+					result.Error = err
+				} else {
+					result.Repaired = true
+				}
+			}
+			if err := callback(result); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego_test
+
+import (
+	"aletheiaware.com/bcgo"
+	"aletheiaware.com/cryptogo"
+	"aletheiaware.com/financego"
+	"aletheiaware.com/spacego"
+	"aletheiaware.com/testinggo"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type blockNetwork struct {
+	head   []byte
+	blocks map[string]*bcgo.Block
+}
+
+func (n *blockNetwork) Head(channel string) (*bcgo.Reference, error) {
+	if n.head == nil {
+		return nil, errors.New("No head")
+	}
+	return &bcgo.Reference{ChannelName: channel, BlockHash: n.head}, nil
+}
+
+func (n *blockNetwork) Block(reference *bcgo.Reference) (*bcgo.Block, error) {
+	b, ok := n.blocks[string(reference.BlockHash)]
+	if !ok {
+		return nil, errors.New("No block")
+	}
+	return b, nil
+}
+
+func (n *blockNetwork) Broadcast(channel bcgo.Channel, cache bcgo.Cache, hash []byte, block *bcgo.Block) error {
+	return nil
+}
+
+func TestAuditBlocks(t *testing.T) {
+	b1 := &bcgo.Block{ChannelName: "Test", Length: 1}
+	h1, err := cryptogo.HashProtobuf(b1)
+	testinggo.AssertNoError(t, err)
+	b2 := &bcgo.Block{ChannelName: "Test", Length: 2, Previous: h1}
+	h2, err := cryptogo.HashProtobuf(b2)
+	testinggo.AssertNoError(t, err)
+	b3 := &bcgo.Block{ChannelName: "Test", Length: 2, Previous: h1, Miner: "Other"}
+	h3, err := cryptogo.HashProtobuf(b3)
+	testinggo.AssertNoError(t, err)
+	hashes := [][]byte{h2, h1}
+	for name, tt := range map[string]struct {
+		network   *blockNetwork
+		missing   [][]byte
+		divergent [][]byte
+		forked    bool
+	}{
+		"complete": {
+			network: &blockNetwork{head: h2, blocks: map[string]*bcgo.Block{string(h1): b1, string(h2): b2}},
+		},
+		"behind": {
+			network: &blockNetwork{head: h1, blocks: map[string]*bcgo.Block{string(h1): b1}},
+			missing: [][]byte{h2},
+		},
+		"empty": {
+			network: &blockNetwork{},
+			missing: [][]byte{h2, h1},
+		},
+		"divergent": {
+			network:   &blockNetwork{head: h2, blocks: map[string]*bcgo.Block{string(h1): b1, string(h2): b3}},
+			divergent: [][]byte{h2},
+		},
+		"forked": {
+			network: &blockNetwork{head: h3, blocks: map[string]*bcgo.Block{string(h1): b1, string(h3): b3}},
+			missing: [][]byte{h2},
+			forked:  true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			result := spacego.AuditBlocks("host", "Test", hashes, tt.network)
+			assert.Equal(t, "host", result.Host)
+			assert.Equal(t, h2, result.LocalHead)
+			assert.Equal(t, tt.missing, result.Missing)
+			assert.Equal(t, tt.divergent, result.Divergent)
+			assert.Equal(t, tt.forked, result.Forked)
+			assert.Equal(t, tt.missing == nil && tt.divergent == nil && !tt.forked, result.Ok())
+		})
+	}
+}
+
+func TestAudit(t *testing.T) {
+	cache := newFakeCache()
+	node := newFakeNode("alice", cache)
+	merchant := newFakeNode("nyc", cache)
+	registrar := newFakeRegistrar("nyc", "space-nyc.example.com", nil)
+	writeRegistrar(t, node, registrar.Registrar)
+	_, err := spacego.Onboard(node, nil, registrar.Registrar, "cus_alice", &financego.Subscription{SubscriptionId: "sub_1"}, func(string) ([]bcgo.Identity, error) {
+		return []bcgo.Identity{merchant.Account()}, nil
+	})
+	testinggo.AssertNoError(t, err)
+	notes := writeTextFile(t, node, "notes.txt")
+	appendText(t, node, notes, 0, "Hello")
+	appendText(t, node, notes, 5, " World")
+	todo := writeTextFile(t, node, "todo.txt")
+	appendText(t, node, todo, 0, "Shopping")
+
+	// Replicate every channel
+	channels, err := spacego.AuditChannels(node)
+	testinggo.AssertNoError(t, err)
+	for _, c := range channels {
+		if head := c.Head(); head != nil {
+			block, err := cache.Block(head)
+			testinggo.AssertNoError(t, err)
+			testinggo.AssertNoError(t, registrar.Broadcast(c, cache, head, block))
+		}
+	}
+	network := func(host string) bcgo.Network {
+		assert.Equal(t, "space-nyc.example.com", host)
+		return registrar
+	}
+	audit := func(repair bool) map[string]*spacego.AuditResult {
+		results := make(map[string]*spacego.AuditResult)
+		testinggo.AssertNoError(t, spacego.Audit(node, network, repair, func(result *spacego.AuditResult) error {
+			results[result.Channel] = result
+			return nil
+		}))
+		return results
+	}
+	// The fake node marks entries with their block hash after hashing the block, so blocks appear divergent and only missing blocks are checked
+	for _, result := range audit(false) {
+		assert.Empty(t, result.Missing, result.Channel)
+		assert.Nil(t, result.Error, result.Channel)
+	}
+
+	// The registrar loses a block
+	lost := node.OpenChannel(spacego.DeltaChannelName(todo), nil).Head()
+	registrar.Drop(lost)
+	// A block of another channel is lost locally, so the channel cannot be read
+	first, err := cache.Block(node.OpenChannel(spacego.DeltaChannelName(notes), nil).Head())
+	testinggo.AssertNoError(t, err)
+	testinggo.AssertNoError(t, cache.RemoveBlock(first.Previous))
+
+	results := audit(true)
+	result := results[spacego.DeltaChannelName(todo)]
+	assert.Equal(t, [][]byte{lost}, result.Missing)
+	assert.True(t, result.Repaired)
+	assert.Nil(t, result.Error)
+	// The lost block is pushed to the registrar again
+	block, err := registrar.Block(&bcgo.Reference{ChannelName: spacego.DeltaChannelName(todo), BlockHash: lost})
+	testinggo.AssertNoError(t, err)
+	assert.NotNil(t, block)
+	// The channel which cannot be read is reported, and the others are still audited
+	assert.Error(t, results[spacego.DeltaChannelName(notes)].Error)
+	assert.Empty(t, results[spacego.MetaChannelName("alice")].Missing)
+	assert.Empty(t, audit(false)[spacego.DeltaChannelName(todo)].Missing)
+}
@@ -0,0 +1,323 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego
+
+import (
+	"aletheiaware.com/bcgo"
+	"aletheiaware.com/financego"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"log"
+)
+
+type ErrPeriodNotEnded struct {
+	Period Period
+}
+
+func (e ErrPeriodNotEnded) Error() string {
+	return fmt.Sprintf("Period has not ended: %s", e.Period)
+}
+
+// PaymentProcessor bills customers through a payment provider, such as Stripe.
+// Each call is given an idempotency key, and must return the same ID when called again with the same key so a retried billing run does not bill twice.
+type PaymentProcessor interface {
+	// Invoice bills the customer of the given subscription the given amount, and returns the ID of the invoice.
+	Invoice(key string, subscription *financego.Subscription, currency string, amount int64, description string) (string, error)
+	// Charge takes payment of the invoice with the given ID, and returns the ID of the payment.
+	Charge(key string, subscription *financego.Subscription, invoiceId string, currency string, amount int64) (string, error)
+}
+
+// UsageInPeriod returns true if the given usage record measures the given period.
+// Usage records are stamped, in seconds, with the end of the period they measure.
+func UsageInPeriod(record *financego.UsageRecord, period Period) bool {
+	t := uint64(record.Timestamp) * 1e9
+	return t > period.Start && t <= period.End
+}
+
+// BillingQuantity returns the quantity of the given service used in the given period by the given usage records, according to the mode of the service.
+// A fixed amount service has a quantity of one.
+func BillingQuantity(service *financego.Service, records []*financego.UsageRecord, period Period) int64 {
+	if service.Mode == financego.Mode_FIXED_AMOUNT {
+		return 1
+	}
+	var quantity int64
+	var latest int64
+	for _, r := range records {
+		if !UsageInPeriod(r, period) {
+			continue
+		}
+		switch service.Mode {
+		case financego.Mode_METERED_SUM_USAGE:
+			quantity += r.Quantity
+		case financego.Mode_METERED_MAX_USAGE:
+			if r.Quantity > quantity {
+				quantity = r.Quantity
+			}
+		default:
+			// Last usage
+			if r.Timestamp >= latest {
+				latest = r.Timestamp
+				quantity = r.Quantity
+			}
+		}
+	}
+	return quantity
+}
+
+// BillingAmount returns the price of the given quantity of the given service, charging the group price for each group, or part of a group, of the group size.
+func BillingAmount(service *financego.Service, quantity int64) int64 {
+	if service.Mode == financego.Mode_FIXED_AMOUNT {
+		return service.GroupPrice
+	}
+	if service.GroupSize <= 0 {
+		return quantity * service.GroupPrice
+	}
+	groups := (quantity + service.GroupSize - 1) / service.GroupSize
+	return groups * service.GroupPrice
+}
+
+// BillingDescription returns the description of the bill for the given period.
+// Bill gives it to the processor and carries it in each Charge, so the charge can be matched to the period it bills, see BillsPeriod.
+func BillingDescription(period Period) string {
+	return fmt.Sprintf("%s %s", SPACE, period)
+}
+
+// BillsPeriod returns true if the given Charge bills the given period.
+func BillsPeriod(charge *financego.Charge, period Period) bool {
+	return charge.Description == BillingDescription(period)
+}
+
+// ChargedInvoice returns the hash of the Invoice record referenced by the given Charge entry, or nil if there is none.
+// An Invoice has no description, so it is matched to the period it bills through the Charge which references it.
+func ChargedInvoice(entry *bcgo.BlockEntry) []byte {
+	for _, r := range entry.Record.Reference {
+		if r.ChannelName == SPACE_INVOICE {
+			return r.RecordHash
+		}
+	}
+	return nil
+}
+
+// BillingKey returns the idempotency key of the bill of the given subscription for the given period.
+func BillingKey(subscription *financego.Subscription, period Period) string {
+	return fmt.Sprintf("%s-%s-%s-%s", subscription.MerchantAlias, subscription.CustomerAlias, subscription.SubscriptionId, period)
+}
+
+// AllInvoices triggers the given callback for each invoice the node can read, newest first.
+func AllInvoices(node bcgo.Node, callback financego.InvoiceCallback) error {
+	invoices := node.OpenChannel(SPACE_INVOICE, func() bcgo.Channel {
+		return OpenInvoiceChannel()
+	})
+	if err := invoices.Refresh(node.Cache(), node.Network()); err != nil {
+		log.Println(err)
+	}
+	return bcgo.Read(invoices.Name(), invoices.Head(), nil, node.Cache(), node.Network(), node.Account(), nil, func(entry *bcgo.BlockEntry, key, data []byte) error {
+		// Unmarshal as Invoice
+		i := &financego.Invoice{}
+		if err := proto.Unmarshal(data, i); err != nil {
+			return err
+		}
+		return callback(entry, i)
+	})
+}
+
+// AllCharges triggers the given callback for each charge the node can read, newest first.
+func AllCharges(node bcgo.Node, callback financego.ChargeCallback) error {
+	charges := node.OpenChannel(SPACE_CHARGE, func() bcgo.Channel {
+		return OpenChargeChannel()
+	})
+	if err := charges.Refresh(node.Cache(), node.Network()); err != nil {
+		log.Println(err)
+	}
+	return bcgo.Read(charges.Name(), charges.Head(), nil, node.Cache(), node.Network(), node.Account(), nil, func(entry *bcgo.BlockEntry, key, data []byte) error {
+		// Unmarshal as Charge
+		c := &financego.Charge{}
+		if err := proto.Unmarshal(data, c); err != nil {
+			return err
+		}
+		return callback(entry, c)
+	})
+}
+
+// Bill prices the usage of each customer subscribed to the node's alias, as a registrar, in the given period using the service of the registrar, invoices and charges them through the given processor, and writes the Invoice and Charge records to their channels.
+// Each record is readable by the node's account and the identities returned by the given function for the customer, if any.
+// Each Charge carries the description of the period, see BillsPeriod, and references the Invoice it pays, see ChargedInvoice.
+// Only a period which has ended can be billed. Subscriptions with nothing to pay are skipped, as are Invoice and Charge records already written for the IDs returned by the processor, so a retried run only writes what is missing. The records written are returned.
+func Bill(node bcgo.Node, listener bcgo.MiningListener, processor PaymentProcessor, period Period, identities func(customer string) ([]bcgo.Identity, error)) ([]*financego.Invoice, []*financego.Charge, error) {
+	if bcgo.Timestamp() < period.End {
+		return nil, nil, ErrPeriodNotEnded{Period: period}
+	}
+	merchant := node.Account().Alias()
+	registrars := node.OpenChannel(SPACE_REGISTRAR, func() bcgo.Channel {
+		return OpenRegistrarChannel()
+	})
+	if err := registrars.Refresh(node.Cache(), node.Network()); err != nil {
+		log.Println(err)
+	}
+	registrar, err := RegistrarForAlias(registrars, node.Cache(), node.Network(), merchant)
+	if err != nil {
+		return nil, nil, err
+	}
+	if registrar == nil || registrar.Service == nil {
+		return nil, nil, ErrInvalidRegistrar{Alias: merchant}
+	}
+	service := registrar.Service
+
+	var subscriptions []*financego.Subscription
+	if err := AllSubscriptions(node, func(entry *bcgo.BlockEntry, s *financego.Subscription) error {
+		if s.MerchantAlias == merchant {
+			subscriptions = append(subscriptions, s)
+		}
+		return nil
+	}); err != nil {
+		return nil, nil, err
+	}
+	usage := make(map[string][]*financego.UsageRecord)
+	if err := AllUsageRecords(node, func(entry *bcgo.BlockEntry, r *financego.UsageRecord) error {
+		if r.MerchantAlias == merchant {
+			usage[r.SubscriptionId] = append(usage[r.SubscriptionId], r)
+		}
+		return nil
+	}); err != nil {
+		return nil, nil, err
+	}
+	// Invoice ID to the reference of the Invoice record
+	invoiced := make(map[string]*bcgo.Reference)
+	if err := AllInvoices(node, func(entry *bcgo.BlockEntry, i *financego.Invoice) error {
+		invoiced[i.InvoiceId] = &bcgo.Reference{
+			Timestamp:   entry.Record.Timestamp,
+			ChannelName: SPACE_INVOICE,
+			BlockHash:   entry.BlockHash,
+			RecordHash:  entry.RecordHash,
+		}
+		return nil
+	}); err != nil {
+		return nil, nil, err
+	}
+	charged := make(map[string]bool)
+	if err := AllCharges(node, func(entry *bcgo.BlockEntry, c *financego.Charge) error {
+		charged[c.PaymentId] = true
+		return nil
+	}); err != nil {
+		return nil, nil, err
+	}
+
+	invoiceChannel := node.OpenChannel(SPACE_INVOICE, func() bcgo.Channel {
+		return OpenInvoiceChannel()
+	})
+	chargeChannel := node.OpenChannel(SPACE_CHARGE, func() bcgo.Channel {
+		return OpenChargeChannel()
+	})
+	var invoices []*financego.Invoice
+	var charges []*financego.Charge
+	writeRecord := func(channel bcgo.Channel, customer string, references []*bcgo.Reference, message proto.Message) (*bcgo.Reference, error) {
+		data, err := proto.Marshal(message)
+		if err != nil {
+			return nil, err
+		}
+		access := []bcgo.Identity{node.Account()}
+		if identities != nil {
+			ids, err := identities(customer)
+			if err != nil {
+				return nil, err
+			}
+			access = append(access, ids...)
+		}
+		return node.Write(bcgo.Timestamp(), channel, access, references, data)
+	}
+	mine := func() error {
+		if len(invoices) > 0 {
+			if _, _, err := node.Mine(invoiceChannel, Threshold(invoiceChannel.Name()), listener); err != nil {
+				return err
+			}
+		}
+		if len(charges) > 0 {
+			if _, _, err := node.Mine(chargeChannel, Threshold(chargeChannel.Name()), listener); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	bill := func(s *financego.Subscription) error {
+		amount := BillingAmount(service, BillingQuantity(service, usage[s.SubscriptionId], period))
+		if amount <= 0 {
+			return nil
+		}
+		key := BillingKey(s, period)
+		description := BillingDescription(period)
+		invoiceId, err := processor.Invoice(key, s, service.Currency, amount, description)
+		if err != nil {
+			return err
+		}
+		reference, ok := invoiced[invoiceId]
+		if !ok {
+			invoice := &financego.Invoice{
+				MerchantAlias:  merchant,
+				CustomerAlias:  s.CustomerAlias,
+				Processor:      s.Processor,
+				CustomerId:     s.CustomerId,
+				InvoiceId:      invoiceId,
+				SubscriptionId: s.SubscriptionId,
+				Amount:         amount,
+			}
+			reference, err = writeRecord(invoiceChannel, s.CustomerAlias, nil, invoice)
+			if err != nil {
+				return err
+			}
+			invoiced[invoiceId] = reference
+			invoices = append(invoices, invoice)
+		}
+		// Charged separately, as a previous run may have written the invoice but not the charge
+		paymentId, err := processor.Charge(key, s, invoiceId, service.Currency, amount)
+		if err != nil {
+			return err
+		}
+		if charged[paymentId] {
+			return nil
+		}
+		charge := &financego.Charge{
+			MerchantAlias: merchant,
+			CustomerAlias: s.CustomerAlias,
+			Processor:     s.Processor,
+			PaymentId:     paymentId,
+			ProductId:     s.ProductId,
+			PlanId:        s.PlanId,
+			Country:       service.Country,
+			Currency:      service.Currency,
+			Amount:        amount,
+			Description:   description,
+		}
+		if _, err := writeRecord(chargeChannel, s.CustomerAlias, []*bcgo.Reference{reference}, charge); err != nil {
+			return err
+		}
+		charged[paymentId] = true
+		charges = append(charges, charge)
+		return nil
+	}
+
+	for _, s := range subscriptions {
+		if err = bill(s); err != nil {
+			break
+		}
+	}
+	// Mine whatever was written, even after an error, so a retry does not bill again
+	if e := mine(); err == nil {
+		err = e
+	}
+	return invoices, charges, err
+}
@@ -0,0 +1,243 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego_test
+
+import (
+	"aletheiaware.com/bcgo"
+	"aletheiaware.com/financego"
+	"aletheiaware.com/spacego"
+	"aletheiaware.com/testinggo"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePaymentProcessor is an in-memory PaymentProcessor which records invoices and charges and can be made to fail.
+type fakePaymentProcessor struct {
+	lock      sync.Mutex
+	Invoices  map[string]*fakePayment // Idempotency key to invoice
+	Charges   map[string]*fakePayment // Idempotency key to charge
+	Err       error                   // Returned by every call if set
+	ChargeErr error                   // Returned by Charge if set
+}
+
+// fakePayment is an invoice or charge made by a fakePaymentProcessor.
+type fakePayment struct {
+	Id         string
+	CustomerId string
+	Currency   string
+	Amount     int64
+}
+
+func newFakePaymentProcessor() *fakePaymentProcessor {
+	return &fakePaymentProcessor{
+		Invoices: make(map[string]*fakePayment),
+		Charges:  make(map[string]*fakePayment),
+	}
+}
+
+func (p *fakePaymentProcessor) Invoice(key string, subscription *financego.Subscription, currency string, amount int64, description string) (string, error) {
+	return p.pay(p.Invoices, "in", key, subscription, currency, amount, nil)
+}
+
+func (p *fakePaymentProcessor) Charge(key string, subscription *financego.Subscription, invoiceId string, currency string, amount int64) (string, error) {
+	return p.pay(p.Charges, "ch", key, subscription, currency, amount, p.ChargeErr)
+}
+
+func (p *fakePaymentProcessor) pay(payments map[string]*fakePayment, prefix, key string, subscription *financego.Subscription, currency string, amount int64, err error) (string, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.Err != nil {
+		return "", p.Err
+	}
+	if err != nil {
+		return "", err
+	}
+	if payment, ok := payments[key]; ok {
+		return payment.Id, nil
+	}
+	payment := &fakePayment{
+		Id:         fmt.Sprintf("%s_%d", prefix, len(payments)+1),
+		CustomerId: subscription.CustomerId,
+		Currency:   currency,
+		Amount:     amount,
+	}
+	payments[key] = payment
+	return payment.Id, nil
+}
+
+func TestBillingAmount(t *testing.T) {
+	period := spacego.MonthlyPeriod(time.Date(2021, time.March, 2, 0, 0, 0, 0, time.UTC))
+	end := time.Date(2021, time.April, 1, 0, 0, 0, 0, time.UTC).Unix()
+	records := []*financego.UsageRecord{
+		{Timestamp: end - 200, Quantity: 3000000},
+		{Timestamp: end, Quantity: 1500000},
+		{Timestamp: end - 100, Quantity: 500000},
+		// Previous and next periods
+		{Timestamp: end - 31*24*60*60, Quantity: 9000000},
+		{Timestamp: end + 1, Quantity: 9000000},
+	}
+	for name, tt := range map[string]struct {
+		mode     financego.Mode
+		quantity int64
+		amount   int64
+	}{
+		"fixed": {
+			mode:     financego.Mode_FIXED_AMOUNT,
+			quantity: 1,
+			amount:   25,
+		},
+		"last": {
+			mode:     financego.Mode_METERED_LAST_USAGE,
+			quantity: 1500000,
+			amount:   50,
+		},
+		"sum": {
+			mode:     financego.Mode_METERED_SUM_USAGE,
+			quantity: 5000000,
+			amount:   125,
+		},
+		"max": {
+			mode:     financego.Mode_METERED_MAX_USAGE,
+			quantity: 3000000,
+			amount:   75,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			service := &financego.Service{
+				GroupPrice: 25,
+				GroupSize:  1000000,
+				Mode:       tt.mode,
+			}
+			quantity := spacego.BillingQuantity(service, records, period)
+			assert.Equal(t, tt.quantity, quantity)
+			assert.Equal(t, tt.amount, spacego.BillingAmount(service, quantity))
+		})
+	}
+}
+
+func TestFakePaymentProcessor(t *testing.T) {
+	p := newFakePaymentProcessor()
+	s := &financego.Subscription{CustomerId: "cus_alice"}
+	invoiceId, err := p.Invoice("a", s, "usd", 50, "S P A C E 2021-03")
+	testinggo.AssertNoError(t, err)
+	assert.Equal(t, "in_1", invoiceId)
+
+	// Retries are idempotent
+	retryId, err := p.Invoice("a", s, "usd", 50, "S P A C E 2021-03")
+	testinggo.AssertNoError(t, err)
+	assert.Equal(t, invoiceId, retryId)
+	assert.Equal(t, 1, len(p.Invoices))
+
+	paymentId, err := p.Charge("a", s, invoiceId, "usd", 50)
+	testinggo.AssertNoError(t, err)
+	assert.Equal(t, "ch_1", paymentId)
+	assert.Equal(t, &fakePayment{Id: "ch_1", CustomerId: "cus_alice", Currency: "usd", Amount: 50}, p.Charges["a"])
+
+	p.Err = errors.New("Card declined")
+	_, err = p.Charge("b", s, invoiceId, "usd", 50)
+	assert.Equal(t, p.Err, err)
+}
+
+// testSubscriber onboards a customer node with a merchant node sharing the given cache, writes a file, and meters the customer's usage for a period which ends after the file is written.
+func testSubscriber(t *testing.T, cache *fakeCache) (*fakeNode, *fakeNode, spacego.Period) {
+	t.Helper()
+	customer := newFakeNode("alice", cache)
+	merchant := newFakeNode("nyc", cache)
+	registrar := testRegistrar("nyc", "space-nyc.example.com", "US", 20)
+	registrar.Service.Currency = "usd"
+	registrar.Service.Mode = financego.Mode_METERED_LAST_USAGE
+	writeRegistrar(t, merchant, registrar)
+	_, err := spacego.Onboard(customer, nil, registrar, "cus_alice", &financego.Subscription{SubscriptionId: "sub_1"}, func(string) ([]bcgo.Identity, error) {
+		return []bcgo.Identity{merchant.Account()}, nil
+	})
+	testinggo.AssertNoError(t, err)
+	appendText(t, customer, writeTextFile(t, customer, "notes.txt"), 0, "Hello World")
+	// Only a period which has ended can be billed
+	period := spacego.Period{
+		Start: spacego.MonthlyPeriod(time.Now()).Start,
+		End:   bcgo.Timestamp(),
+	}
+	_, err = spacego.MeterUsage(merchant, nil, period, customerIdentities(customer))
+	testinggo.AssertNoError(t, err)
+	return customer, merchant, period
+}
+
+func customerIdentities(customer *fakeNode) func(string) ([]bcgo.Identity, error) {
+	return func(string) ([]bcgo.Identity, error) {
+		return []bcgo.Identity{customer.Account()}, nil
+	}
+}
+
+func TestBill(t *testing.T) {
+	customer, merchant, period := testSubscriber(t, newFakeCache())
+	processor := newFakePaymentProcessor()
+	processor.ChargeErr = errors.New("Card declined")
+
+	// The current period cannot be billed
+	current := spacego.MonthlyPeriod(time.Now())
+	_, _, err := spacego.Bill(merchant, nil, processor, current, customerIdentities(customer))
+	assert.Equal(t, spacego.ErrPeriodNotEnded{Period: current}, err)
+	assert.Empty(t, processor.Invoices)
+
+	// The invoice is written even though the charge failed
+	invoices, charges, err := spacego.Bill(merchant, nil, processor, period, customerIdentities(customer))
+	assert.Equal(t, processor.ChargeErr, err)
+	assert.Equal(t, 1, len(invoices))
+	assert.Equal(t, "in_1", invoices[0].InvoiceId)
+	assert.Equal(t, int64(20), invoices[0].Amount)
+	assert.Empty(t, charges)
+
+	// Retrying charges without invoicing again
+	processor.ChargeErr = nil
+	invoices, charges, err = spacego.Bill(merchant, nil, processor, period, customerIdentities(customer))
+	testinggo.AssertNoError(t, err)
+	assert.Empty(t, invoices)
+	assert.Equal(t, 1, len(charges))
+	assert.Equal(t, "ch_1", charges[0].PaymentId)
+	assert.Equal(t, int64(20), charges[0].Amount)
+
+	// Retrying again writes nothing
+	invoices, charges, err = spacego.Bill(merchant, nil, processor, period, customerIdentities(customer))
+	testinggo.AssertNoError(t, err)
+	assert.Empty(t, invoices)
+	assert.Empty(t, charges)
+	assert.Equal(t, 1, len(processor.Invoices))
+	assert.Equal(t, 1, len(processor.Charges))
+
+	// The customer can read the records, and the charge bills the period and references the invoice
+	var ids []string
+	var invoice []byte
+	testinggo.AssertNoError(t, spacego.AllInvoices(customer, func(entry *bcgo.BlockEntry, i *financego.Invoice) error {
+		assert.True(t, entry.Record.Timestamp >= period.End)
+		invoice = entry.RecordHash
+		ids = append(ids, i.InvoiceId)
+		return nil
+	}))
+	testinggo.AssertNoError(t, spacego.AllCharges(customer, func(entry *bcgo.BlockEntry, c *financego.Charge) error {
+		assert.True(t, entry.Record.Timestamp >= period.End)
+		assert.True(t, spacego.BillsPeriod(c, period))
+		assert.False(t, spacego.BillsPeriod(c, current.Next()))
+		assert.Equal(t, invoice, spacego.ChargedInvoice(entry))
+		ids = append(ids, c.PaymentId)
+		return nil
+	}))
+	assert.Equal(t, []string{"in_1", "ch_1"}, ids)
+}
@@ -0,0 +1,487 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego
+
+import (
+	"aletheiaware.com/bcgo"
+	"bytes"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+const PATH_SEPARATOR = "/"
+
+type ErrInvalidName struct {
+	Name string
+}
+
+func (e ErrInvalidName) Error() string {
+	return fmt.Sprintf("Invalid name: '%s'", e.Name)
+}
+
+type ErrNoSuchPath struct {
+	Path string
+}
+
+func (e ErrNoSuchPath) Error() string {
+	return fmt.Sprintf("No such path: %s", e.Path)
+}
+
+type ErrNoSuchMeta struct {
+	MetaId string
+}
+
+func (e ErrNoSuchMeta) Error() string {
+	return fmt.Sprintf("No such meta: %s", e.MetaId)
+}
+
+type ErrNotDirectory struct {
+	MetaId string
+}
+
+func (e ErrNotDirectory) Error() string {
+	return fmt.Sprintf("Not a directory: %s", e.MetaId)
+}
+
+type ErrNameExists struct {
+	Parent string
+	Name   string
+}
+
+func (e ErrNameExists) Error() string {
+	return fmt.Sprintf("Name already exists: '%s'", e.Name)
+}
+
+type ErrCyclicHierarchy struct {
+	MetaId string
+}
+
+func (e ErrCyclicHierarchy) Error() string {
+	return fmt.Sprintf("Cyclic hierarchy: %s", e.MetaId)
+}
+
+// ValidateName returns an error if the given name cannot be used as a path element.
+func ValidateName(name string) error {
+	switch {
+	case name == "",
+		name == ".",
+		name == "..",
+		strings.Contains(name, PATH_SEPARATOR):
+		return ErrInvalidName{Name: name}
+	}
+	return nil
+}
+
+// Hierarchy is a snapshot of the directories and files in a Meta channel.
+// A Meta record that references an earlier record in the same channel is a new version of that file, and the file keeps the metaId of its first version.
+// Hierarchy is not safe for concurrent use.
+type Hierarchy struct {
+	channel  string
+	files    map[string]*hierarchyFile
+	children map[string]map[string]bool // Parent metaId to the metaIds whose latest version is in it
+}
+
+type hierarchyFile struct {
+	reference *bcgo.Reference
+	versions  []*MetaVersion // Oldest first
+}
+
+func (f *hierarchyFile) latest() *MetaVersion {
+	return f.versions[len(f.versions)-1]
+}
+
+func (f *hierarchyFile) add(version *MetaVersion) {
+	index := len(f.versions)
+	for i, v := range f.versions {
+		if bytes.Equal(v.RecordHash, version.RecordHash) {
+			return
+		}
+		if v.Timestamp >= version.Timestamp && i < index {
+			// Versions are read newest first so on a tie the existing version is kept as the newer
+			index = i
+		}
+	}
+	f.versions = append(f.versions, nil)
+	copy(f.versions[index+1:], f.versions[index:])
+	f.versions[index] = version
+}
+
+func NewHierarchy(channel string) *Hierarchy {
+	return &Hierarchy{
+		channel:  channel,
+		files:    make(map[string]*hierarchyFile),
+		children: make(map[string]map[string]bool),
+	}
+}
+
+// ReadHierarchy reads every Meta in the given channel, including those in the trash, into a Hierarchy.
+func ReadHierarchy(metas bcgo.Channel, cache bcgo.Cache, network bcgo.Network, account bcgo.Account) (*Hierarchy, error) {
+	h := NewHierarchy(metas.Name())
+	if err := readMeta(metas, cache, network, account, nil, func(entry *bcgo.BlockEntry, meta *Meta) error {
+		h.Add(entry, meta)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Add records the Meta held in the given entry as a version of the file it belongs to.
+func (h *Hierarchy) Add(entry *bcgo.BlockEntry, meta *Meta) {
+	reference := MetaOrigin(h.channel, entry)
+	h.add(reference, bytes.Equal(reference.RecordHash, entry.RecordHash), &MetaVersion{
+		Timestamp:  entry.Record.Timestamp,
+		RecordHash: entry.RecordHash,
+		Meta:       meta,
+	})
+}
+
+func (h *Hierarchy) add(reference *bcgo.Reference, first bool, version *MetaVersion) {
+	metaId := MetaId(reference.RecordHash)
+	f, ok := h.files[metaId]
+	if ok {
+		// The new version may replace the latest, and with it the parent
+		delete(h.children[f.latest().Meta.Parent], metaId)
+	} else {
+		f = &hierarchyFile{}
+		h.files[metaId] = f
+	}
+	if !ok || first {
+		// Prefer the reference to the first version built from its own entry
+		f.reference = reference
+	}
+	f.add(version)
+	parent := f.latest().Meta.Parent
+	children, ok := h.children[parent]
+	if !ok {
+		children = make(map[string]bool)
+		h.children[parent] = children
+	}
+	children[metaId] = true
+}
+
+// Meta returns the latest version of the Meta with the given metaId, or nil if it does not exist.
+func (h *Hierarchy) Meta(metaId string) *Meta {
+	if f, ok := h.files[metaId]; ok {
+		return f.latest().Meta
+	}
+	return nil
+}
+
+// History returns every version of the Meta with the given metaId, oldest first.
+func (h *Hierarchy) History(metaId string) []*MetaVersion {
+	if f, ok := h.files[metaId]; ok {
+		return append([]*MetaVersion{}, f.versions...)
+	}
+	return nil
+}
+
+// Reference returns a reference to the first version of the Meta with the given metaId, or nil if it does not exist.
+func (h *Hierarchy) Reference(metaId string) *bcgo.Reference {
+	if f, ok := h.files[metaId]; ok {
+		return f.reference
+	}
+	return nil
+}
+
+// IsDirectory returns true if the given metaId is the root ("") or a directory.
+func (h *Hierarchy) IsDirectory(metaId string) bool {
+	if metaId == "" {
+		return true
+	}
+	meta := h.Meta(metaId)
+	return meta != nil && meta.Type == MIME_TYPE_DIRECTORY
+}
+
+// IsDeleted returns true if the file with the given metaId, or a directory containing it, is in the trash or has been purged.
+func (h *Hierarchy) IsDeleted(metaId string) bool {
+	visited := make(map[string]bool)
+	for id := metaId; id != "" && !visited[id]; {
+		visited[id] = true
+		meta := h.Meta(id)
+		if meta == nil {
+			return false
+		}
+		if meta.Trashed != 0 || meta.Purged {
+			return true
+		}
+		id = meta.Parent
+	}
+	return false
+}
+
+// Children returns the metaIds of the files in the given directory, sorted by name.
+// The root directory is identified by an empty metaId.
+// Files in the trash are not included.
+func (h *Hierarchy) Children(parent string) []string {
+	var children []string
+	for id := range h.children[parent] {
+		if m := h.files[id].latest().Meta; m.Trashed == 0 && !m.Purged {
+			children = append(children, id)
+		}
+	}
+	sort.Slice(children, func(i, j int) bool {
+		a := h.files[children[i]].latest().Meta.Name
+		b := h.files[children[j]].latest().Meta.Name
+		if a == b {
+			return children[i] < children[j]
+		}
+		return a < b
+	})
+	return children
+}
+
+// Files returns the metaIds of every file and directory reachable from the root and not in the trash, in path order.
+func (h *Hierarchy) Files() []string {
+	var files []string
+	visited := make(map[string]bool)
+	var walk func(string)
+	walk = func(parent string) {
+		for _, id := range h.Children(parent) {
+			if visited[id] {
+				continue
+			}
+			visited[id] = true
+			files = append(files, id)
+			walk(id)
+		}
+	}
+	walk("")
+	return files
+}
+
+// all returns the metaIds of every file and directory which has not been purged, sorted.
+func (h *Hierarchy) all() []string {
+	var ids []string
+	for id, f := range h.files {
+		if !f.latest().Meta.Purged {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Child returns the metaId of the file with the given name in the given directory.
+// If more than one file has the name, the most recently updated is returned.
+func (h *Hierarchy) Child(parent, name string) (string, bool) {
+	var result string
+	var timestamp uint64
+	found := false
+	for _, id := range h.Children(parent) {
+		v := h.files[id].latest()
+		if v.Meta.Name == name && (!found || v.Timestamp > timestamp) {
+			result = id
+			timestamp = v.Timestamp
+			found = true
+		}
+	}
+	return result, found
+}
+
+// checkName returns an error if a file other than the one with the given metaId already has the given name in the given directory.
+func (h *Hierarchy) checkName(metaId, parent, name string) error {
+	if id, ok := h.Child(parent, name); ok && id != metaId {
+		return ErrNameExists{Parent: parent, Name: name}
+	}
+	return nil
+}
+
+// Resolve returns the metaId of the file at the given path, such as "/docs/2026/report.pdf".
+// The root path "/" resolves to an empty metaId.
+func (h *Hierarchy) Resolve(path string) (string, error) {
+	metaId := ""
+	for _, name := range strings.Split(path, PATH_SEPARATOR) {
+		if name == "" {
+			continue
+		}
+		if !h.IsDirectory(metaId) {
+			return "", ErrNoSuchPath{Path: path}
+		}
+		id, ok := h.Child(metaId, name)
+		if !ok {
+			return "", ErrNoSuchPath{Path: path}
+		}
+		metaId = id
+	}
+	return metaId, nil
+}
+
+// Path returns the absolute path of the file with the given metaId.
+func (h *Hierarchy) Path(metaId string) (string, error) {
+	var names []string
+	visited := make(map[string]bool)
+	for id := metaId; id != ""; {
+		if visited[id] {
+			return "", ErrCyclicHierarchy{MetaId: metaId}
+		}
+		visited[id] = true
+		meta := h.Meta(id)
+		if meta == nil {
+			return "", ErrNoSuchMeta{MetaId: id}
+		}
+		names = append([]string{meta.Name}, names...)
+		id = meta.Parent
+	}
+	return PATH_SEPARATOR + strings.Join(names, PATH_SEPARATOR), nil
+}
+
+// IsAncestor returns true if the given ancestor is the given metaId, or one of the directories containing it.
+func (h *Hierarchy) IsAncestor(ancestor, metaId string) bool {
+	visited := make(map[string]bool)
+	for id := metaId; !visited[id]; {
+		if id == ancestor {
+			return true
+		}
+		if id == "" {
+			return false
+		}
+		visited[id] = true
+		meta := h.Meta(id)
+		if meta == nil {
+			return false
+		}
+		id = meta.Parent
+	}
+	return false
+}
+
+func openMetaHierarchy(node bcgo.Node) (bcgo.Channel, *Hierarchy, error) {
+	alias := node.Account().Alias()
+	metas := node.OpenChannel(MetaChannelName(alias), func() bcgo.Channel {
+		return OpenMetaChannel(alias)
+	})
+	if err := metas.Refresh(node.Cache(), node.Network()); err != nil {
+		log.Println(err)
+	}
+	h, err := ReadHierarchy(metas, node.Cache(), node.Network(), node.Account())
+	if err != nil {
+		return nil, nil, err
+	}
+	return metas, h, nil
+}
+
+// CreateDirectory writes a new directory with the given name into the given parent directory of the node's Meta channel.
+func CreateDirectory(node bcgo.Node, listener bcgo.MiningListener, parent, name string) (*bcgo.Reference, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+	metas, h, err := openMetaHierarchy(node)
+	if err != nil {
+		return nil, err
+	}
+	if !h.IsDirectory(parent) {
+		return nil, ErrNotDirectory{MetaId: parent}
+	}
+	if err := h.checkName("", parent, name); err != nil {
+		return nil, err
+	}
+	return write(node, listener, metas, nil, &Meta{
+		Name:   name,
+		Type:   MIME_TYPE_DIRECTORY,
+		Parent: parent,
+	})
+}
+
+// CreatePath creates any missing directories in the given path and returns the metaId of the last.
+func CreatePath(node bcgo.Node, listener bcgo.MiningListener, path string) (string, error) {
+	metas, h, err := openMetaHierarchy(node)
+	if err != nil {
+		return "", err
+	}
+	metaId := ""
+	for _, name := range strings.Split(path, PATH_SEPARATOR) {
+		if name == "" {
+			continue
+		}
+		if err := ValidateName(name); err != nil {
+			return "", err
+		}
+		if id, ok := h.Child(metaId, name); ok {
+			if !h.IsDirectory(id) {
+				return "", ErrNotDirectory{MetaId: id}
+			}
+			metaId = id
+			continue
+		}
+		meta := &Meta{
+			Name:   name,
+			Type:   MIME_TYPE_DIRECTORY,
+			Parent: metaId,
+		}
+		reference, err := write(node, listener, metas, nil, meta)
+		if err != nil {
+			return "", err
+		}
+		h.add(reference, true, &MetaVersion{
+			Timestamp:  reference.Timestamp,
+			RecordHash: reference.RecordHash,
+			Meta:       meta,
+		})
+		metaId = MetaId(reference.RecordHash)
+	}
+	return metaId, nil
+}
+
+// ListDirectory triggers the given callback for the latest version of each file in the given directory, sorted by name.
+func ListDirectory(node bcgo.Node, parent string, callback func(string, *Meta) error) error {
+	_, h, err := openMetaHierarchy(node)
+	if err != nil {
+		return err
+	}
+	if !h.IsDirectory(parent) {
+		return ErrNotDirectory{MetaId: parent}
+	}
+	for _, id := range h.Children(parent) {
+		if err := callback(id, h.Meta(id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResolvePath returns the metaId and latest Meta of the file at the given path in the node's Meta channel.
+func ResolvePath(node bcgo.Node, path string) (string, *Meta, error) {
+	_, h, err := openMetaHierarchy(node)
+	if err != nil {
+		return "", nil, err
+	}
+	metaId, err := h.Resolve(path)
+	if err != nil {
+		return "", nil, err
+	}
+	return metaId, h.Meta(metaId), nil
+}
+
+// Move writes a new version of the Meta with the given metaId into the given parent directory.
+func Move(node bcgo.Node, listener bcgo.MiningListener, metaId, parent string) (*bcgo.Reference, error) {
+	return updateMeta(node, listener, metaId, func(h *Hierarchy, meta *Meta) error {
+		if !h.IsDirectory(parent) {
+			return ErrNotDirectory{MetaId: parent}
+		}
+		if h.IsAncestor(metaId, parent) {
+			return ErrCyclicHierarchy{MetaId: metaId}
+		}
+		if err := h.checkName(metaId, parent, meta.Name); err != nil {
+			return err
+		}
+		meta.Parent = parent
+		return nil
+	})
+}
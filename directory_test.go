@@ -0,0 +1,198 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego_test
+
+import (
+	"aletheiaware.com/bcgo"
+	"aletheiaware.com/spacego"
+	"aletheiaware.com/testinggo"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const testMetaChannel = "Space-Meta-Alice"
+
+func testEntry(hash string, timestamp uint64, references ...*bcgo.Reference) *bcgo.BlockEntry {
+	return &bcgo.BlockEntry{
+		RecordHash: []byte(hash),
+		Record: &bcgo.Record{
+			Timestamp: timestamp,
+			Reference: references,
+		},
+	}
+}
+
+func testReference(hash string) *bcgo.Reference {
+	return &bcgo.Reference{
+		ChannelName: testMetaChannel,
+		RecordHash:  []byte(hash),
+	}
+}
+
+func testHierarchy() *spacego.Hierarchy {
+	h := spacego.NewHierarchy(testMetaChannel)
+	h.Add(testEntry("docs", 1), &spacego.Meta{
+		Name: "docs",
+		Type: spacego.MIME_TYPE_DIRECTORY,
+	})
+	h.Add(testEntry("2026", 2), &spacego.Meta{
+		Name:   "2026",
+		Type:   spacego.MIME_TYPE_DIRECTORY,
+		Parent: spacego.MetaId([]byte("docs")),
+	})
+	h.Add(testEntry("report", 3), &spacego.Meta{
+		Name:   "report.pdf",
+		Type:   spacego.MIME_TYPE_PDF,
+		Parent: spacego.MetaId([]byte("2026")),
+	})
+	h.Add(testEntry("notes", 4), &spacego.Meta{
+		Name: "notes.txt",
+		Type: spacego.MIME_TYPE_TEXT_PLAIN,
+	})
+	return h
+}
+
+func TestHierarchyResolve(t *testing.T) {
+	h := testHierarchy()
+	for name, tt := range map[string]struct {
+		path     string
+		expected string
+		err      error
+	}{
+		"root": {
+			path: "/",
+		},
+		"directory": {
+			path:     "/docs",
+			expected: spacego.MetaId([]byte("docs")),
+		},
+		"file": {
+			path:     "/docs/2026/report.pdf",
+			expected: spacego.MetaId([]byte("report")),
+		},
+		"relative": {
+			path:     "notes.txt",
+			expected: spacego.MetaId([]byte("notes")),
+		},
+		"missing": {
+			path: "/docs/2025",
+			err:  spacego.ErrNoSuchPath{Path: "/docs/2025"},
+		},
+		"through_file": {
+			path: "/notes.txt/foo",
+			err:  spacego.ErrNoSuchPath{Path: "/notes.txt/foo"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, err := h.Resolve(tt.path)
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestHierarchyPath(t *testing.T) {
+	h := testHierarchy()
+	path, err := h.Path(spacego.MetaId([]byte("report")))
+	assert.Nil(t, err)
+	assert.Equal(t, "/docs/2026/report.pdf", path)
+}
+
+func TestHierarchyChildren(t *testing.T) {
+	h := testHierarchy()
+	assert.Equal(t, []string{
+		spacego.MetaId([]byte("docs")),
+		spacego.MetaId([]byte("notes")),
+	}, h.Children(""))
+}
+
+func TestHierarchyMove(t *testing.T) {
+	h := testHierarchy()
+	// Move notes into docs
+	h.Add(testEntry("moved", 5, testReference("notes")), &spacego.Meta{
+		Name:   "notes.txt",
+		Type:   spacego.MIME_TYPE_TEXT_PLAIN,
+		Parent: spacego.MetaId([]byte("docs")),
+	})
+	// Older version arriving late does not replace the newer
+	h.Add(testEntry("notes", 4), &spacego.Meta{
+		Name: "notes.txt",
+		Type: spacego.MIME_TYPE_TEXT_PLAIN,
+	})
+	id, err := h.Resolve("/docs/notes.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, spacego.MetaId([]byte("notes")), id)
+	assert.Equal(t, []string{
+		spacego.MetaId([]byte("docs")),
+	}, h.Children(""))
+	assert.Equal(t, &bcgo.Reference{
+		Timestamp:   4,
+		ChannelName: testMetaChannel,
+		RecordHash:  []byte("notes"),
+	}, h.Reference(id))
+}
+
+func TestHierarchyIsAncestor(t *testing.T) {
+	h := testHierarchy()
+	docs := spacego.MetaId([]byte("docs"))
+	report := spacego.MetaId([]byte("report"))
+	assert.True(t, h.IsAncestor("", report))
+	assert.True(t, h.IsAncestor(docs, report))
+	assert.True(t, h.IsAncestor(docs, docs))
+	assert.False(t, h.IsAncestor(report, docs))
+}
+
+func TestValidateName(t *testing.T) {
+	assert.Nil(t, spacego.ValidateName("report.pdf"))
+	for _, name := range []string{"", ".", "..", "a/b"} {
+		assert.Equal(t, spacego.ErrInvalidName{Name: name}, spacego.ValidateName(name))
+	}
+}
+
+func TestHierarchyFiles(t *testing.T) {
+	h := testHierarchy()
+	assert.Equal(t, []string{
+		spacego.MetaId([]byte("docs")),
+		spacego.MetaId([]byte("2026")),
+		spacego.MetaId([]byte("report")),
+		spacego.MetaId([]byte("notes")),
+	}, h.Files())
+}
+
+func TestCreateDirectory_NameExists(t *testing.T) {
+	node := newFakeNode("alice", newFakeCache())
+	docs, err := spacego.CreateDirectory(node, nil, "", "docs")
+	testinggo.AssertNoError(t, err)
+	_, err = spacego.CreateDirectory(node, nil, "", "docs")
+	assert.Equal(t, spacego.ErrNameExists{Name: "docs"}, err)
+	// The same name in another directory is allowed
+	_, err = spacego.CreateDirectory(node, nil, spacego.MetaId(docs.RecordHash), "docs")
+	testinggo.AssertNoError(t, err)
+}
+
+func TestMove_NameExists(t *testing.T) {
+	node := newFakeNode("alice", newFakeCache())
+	docs, err := spacego.CreateDirectory(node, nil, "", "docs")
+	testinggo.AssertNoError(t, err)
+	docsId := spacego.MetaId(docs.RecordHash)
+	_, err = spacego.CreateDirectory(node, nil, docsId, "2026")
+	testinggo.AssertNoError(t, err)
+	other, err := spacego.CreateDirectory(node, nil, "", "2026")
+	testinggo.AssertNoError(t, err)
+	_, err = spacego.Move(node, nil, spacego.MetaId(other.RecordHash), docsId)
+	assert.Equal(t, spacego.ErrNameExists{Parent: docsId, Name: "2026"}, err)
+}
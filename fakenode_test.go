@@ -0,0 +1,346 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego_test
+
+import (
+	"aletheiaware.com/bcgo"
+	"aletheiaware.com/cryptogo"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"testing"
+)
+
+var (
+	errNoSuchBlock = errors.New("No such block")
+	errNoSuchHead  = errors.New("No such head")
+	errNoEntries   = errors.New("No entries to mine")
+)
+
+// fakeAccount is an Account whose records are not encrypted, so tests can read them without keys.
+type fakeAccount struct {
+	alias string
+}
+
+func (a *fakeAccount) Alias() string {
+	return a.alias
+}
+
+func (a *fakeAccount) PublicKey() []byte {
+	return []byte(a.alias)
+}
+
+func (a *fakeAccount) EncryptKey(key []byte) ([]byte, cryptogo.EncryptionAlgorithm, error) {
+	return key, cryptogo.EncryptionAlgorithm_UNKNOWN_ENCRYPTION, nil
+}
+
+func (a *fakeAccount) Decrypt(algorithm cryptogo.EncryptionAlgorithm, payload, key []byte) ([]byte, error) {
+	if algorithm == cryptogo.EncryptionAlgorithm_AES_256_GCM_NOPADDING {
+		return cryptogo.DecryptAESGCM(key, payload)
+	}
+	return payload, nil
+}
+
+func (a *fakeAccount) DecryptKey(algorithm cryptogo.EncryptionAlgorithm, key []byte) ([]byte, error) {
+	return key, nil
+}
+
+// fakeCache is an in-memory Cache which can also remove what it stores.
+type fakeCache struct {
+	lock    sync.Mutex
+	heads   map[string]*bcgo.Reference
+	blocks  map[string]*bcgo.Block
+	entries map[string][]*bcgo.BlockEntry
+}
+
+func newFakeCache() *fakeCache {
+	return &fakeCache{
+		heads:   make(map[string]*bcgo.Reference),
+		blocks:  make(map[string]*bcgo.Block),
+		entries: make(map[string][]*bcgo.BlockEntry),
+	}
+}
+
+func (c *fakeCache) Head(channel string) (*bcgo.Reference, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	head, ok := c.heads[channel]
+	if !ok {
+		return nil, errNoSuchHead
+	}
+	return head, nil
+}
+
+func (c *fakeCache) Block(hash []byte) (*bcgo.Block, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	block, ok := c.blocks[base64.RawURLEncoding.EncodeToString(hash)]
+	if !ok {
+		return nil, errNoSuchBlock
+	}
+	return block, nil
+}
+
+func (c *fakeCache) Entries(channel string) ([]*bcgo.BlockEntry, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]*bcgo.BlockEntry{}, c.entries[channel]...), nil
+}
+
+func (c *fakeCache) PutEntry(channel string, entry *bcgo.BlockEntry) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries[channel] = append(c.entries[channel], entry)
+	return nil
+}
+
+// takeEntries removes and returns the entries pending in the given channel.
+func (c *fakeCache) takeEntries(channel string) []*bcgo.BlockEntry {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entries := c.entries[channel]
+	delete(c.entries, channel)
+	return entries
+}
+
+func (c *fakeCache) PutHead(channel string, reference *bcgo.Reference) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.heads[channel] = reference
+	return nil
+}
+
+func (c *fakeCache) PutBlock(hash []byte, block *bcgo.Block) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.blocks[base64.RawURLEncoding.EncodeToString(hash)] = block
+	return nil
+}
+
+func (c *fakeCache) RemoveBlock(hash []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.blocks, base64.RawURLEncoding.EncodeToString(hash))
+	return nil
+}
+
+func (c *fakeCache) RemoveHead(channel string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.heads, channel)
+	return nil
+}
+
+// fakeChannel is a Channel whose head is kept in a cache, so nodes sharing the cache see each other's blocks on Refresh.
+type fakeChannel struct {
+	lock     sync.Mutex
+	name     string
+	head     []byte
+	block    *bcgo.Block
+	triggers []func()
+}
+
+func (c *fakeChannel) AddTrigger(trigger func()) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.triggers = append(c.triggers, trigger)
+}
+
+func (c *fakeChannel) AddValidator(bcgo.Validator) {}
+
+func (c *fakeChannel) Name() string {
+	return c.name
+}
+
+func (c *fakeChannel) Head() []byte {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.head
+}
+
+func (c *fakeChannel) Timestamp() uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.block == nil {
+		return 0
+	}
+	return c.block.Timestamp
+}
+
+func (c *fakeChannel) Load(cache bcgo.Cache, network bcgo.Network) error {
+	return c.Refresh(cache, network)
+}
+
+func (c *fakeChannel) Refresh(cache bcgo.Cache, network bcgo.Network) error {
+	head, err := cache.Head(c.name)
+	if err != nil {
+		return nil
+	}
+	block, err := cache.Block(head.BlockHash)
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.head = head.BlockHash
+	c.block = block
+	return nil
+}
+
+func (c *fakeChannel) Update(cache bcgo.Cache, network bcgo.Network, hash []byte, block *bcgo.Block) error {
+	if err := cache.PutHead(c.name, &bcgo.Reference{
+		Timestamp:   block.Timestamp,
+		ChannelName: c.name,
+		BlockHash:   hash,
+	}); err != nil {
+		return err
+	}
+	c.lock.Lock()
+	c.head = hash
+	c.block = block
+	triggers := append([]func(){}, c.triggers...)
+	c.lock.Unlock()
+	for _, t := range triggers {
+		t()
+	}
+	return nil
+}
+
+// fakeNode is a Node which mines every record pending in its cache into one block without proof of work.
+type fakeNode struct {
+	lock     sync.Mutex
+	account  *fakeAccount
+	cache    *fakeCache
+	channels map[string]*fakeChannel
+}
+
+// newFakeNode returns a node for the given alias storing blocks in the given cache, which may be shared with other nodes.
+func newFakeNode(alias string, cache *fakeCache) *fakeNode {
+	return &fakeNode{
+		account:  &fakeAccount{alias: alias},
+		cache:    cache,
+		channels: make(map[string]*fakeChannel),
+	}
+}
+
+func (n *fakeNode) Account() bcgo.Account {
+	return n.account
+}
+
+func (n *fakeNode) Cache() bcgo.Cache {
+	return n.cache
+}
+
+func (n *fakeNode) Network() bcgo.Network {
+	return nil
+}
+
+func (n *fakeNode) OpenChannel(name string, open func() bcgo.Channel) bcgo.Channel {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	c, ok := n.channels[name]
+	if !ok {
+		c = &fakeChannel{
+			name: name,
+		}
+		c.Load(n.cache, nil)
+		n.channels[name] = c
+	}
+	return c
+}
+
+func (n *fakeNode) Write(timestamp uint64, channel bcgo.Channel, access []bcgo.Identity, references []*bcgo.Reference, payload []byte) (*bcgo.Reference, error) {
+	record := &bcgo.Record{
+		Timestamp: timestamp,
+		Creator:   n.account.Alias(),
+		Payload:   payload,
+		Reference: references,
+	}
+	for _, a := range access {
+		record.Access = append(record.Access, &bcgo.Record_Access{
+			Alias: a.Alias(),
+		})
+	}
+	hash, err := cryptogo.HashProtobuf(record)
+	if err != nil {
+		return nil, err
+	}
+	if err := n.cache.PutEntry(channel.Name(), &bcgo.BlockEntry{
+		RecordHash: hash,
+		Record:     record,
+	}); err != nil {
+		return nil, err
+	}
+	return &bcgo.Reference{
+		Timestamp:   timestamp,
+		ChannelName: channel.Name(),
+		RecordHash:  hash,
+	}, nil
+}
+
+func (n *fakeNode) Mine(channel bcgo.Channel, threshold uint64, listener bcgo.MiningListener) ([]byte, *bcgo.Block, error) {
+	entries := n.cache.takeEntries(channel.Name())
+	if len(entries) == 0 {
+		return nil, nil, errNoEntries
+	}
+	if err := channel.Refresh(n.cache, nil); err != nil {
+		return nil, nil, err
+	}
+	block := &bcgo.Block{
+		Timestamp:   bcgo.Timestamp(),
+		ChannelName: channel.Name(),
+		Length:      1,
+		Previous:    channel.Head(),
+		Miner:       n.account.Alias(),
+		Entry:       entries,
+	}
+	if block.Previous != nil {
+		previous, err := n.cache.Block(block.Previous)
+		if err != nil {
+			return nil, nil, err
+		}
+		block.Length = previous.Length + 1
+	}
+	hash, err := cryptogo.HashProtobuf(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, e := range entries {
+		e.BlockHash = hash
+	}
+	if err := n.cache.PutBlock(hash, block); err != nil {
+		return nil, nil, err
+	}
+	if err := channel.Update(n.cache, nil, hash, block); err != nil {
+		return nil, nil, err
+	}
+	return hash, block, nil
+}
+
+// writeRecord writes and mines the given payload, readable by the node's account, to the channel with the given name.
+func writeRecord(t *testing.T, node *fakeNode, name string, references []*bcgo.Reference, payload []byte) *bcgo.Reference {
+	t.Helper()
+	channel := node.OpenChannel(name, nil)
+	reference, err := node.Write(bcgo.Timestamp(), channel, []bcgo.Identity{node.Account()}, references, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := node.Mine(channel, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	return reference
+}
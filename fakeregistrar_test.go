@@ -0,0 +1,190 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego_test
+
+import (
+	"aletheiaware.com/bcgo"
+	"aletheiaware.com/cryptogo"
+	"aletheiaware.com/financego"
+	"aletheiaware.com/spacego"
+	"aletheiaware.com/testinggo"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// fakeRegistrar is an in-process registrar.
+// It holds blocks in memory, serves them as a bcgo.Network, and answers health checks as a http.Handler, such as when served by httptest.NewServer.
+type fakeRegistrar struct {
+	Registrar *spacego.Registrar
+	lock      sync.Mutex
+	heads     map[string]*bcgo.Reference
+	blocks    map[string]*bcgo.Block
+	down      bool
+}
+
+func newFakeRegistrar(alias, domain string, service *financego.Service) *fakeRegistrar {
+	return &fakeRegistrar{
+		Registrar: &spacego.Registrar{
+			Merchant: &financego.Merchant{
+				Alias:  alias,
+				Domain: domain,
+			},
+			Service: service,
+		},
+		heads:  make(map[string]*bcgo.Reference),
+		blocks: make(map[string]*bcgo.Block),
+	}
+}
+
+// SetDown makes the registrar fail every request until it is set up again.
+func (r *fakeRegistrar) SetDown(down bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.down = down
+}
+
+// Drop forgets the block with the given hash, as if it had been lost.
+func (r *fakeRegistrar) Drop(hash []byte) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.blocks, string(hash))
+}
+
+func (r *fakeRegistrar) Head(channel string) (*bcgo.Reference, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if err := r.unavailable(); err != nil {
+		return nil, err
+	}
+	head, ok := r.heads[channel]
+	if !ok {
+		return nil, errNoSuchHead
+	}
+	return head, nil
+}
+
+func (r *fakeRegistrar) Block(reference *bcgo.Reference) (*bcgo.Block, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if err := r.unavailable(); err != nil {
+		return nil, err
+	}
+	block, ok := r.blocks[string(reference.BlockHash)]
+	if !ok {
+		return nil, errNoSuchBlock
+	}
+	return block, nil
+}
+
+// Broadcast stores the given block and any previous blocks it is missing from the given cache, and makes the block the head of its channel if it is longer than the current head.
+func (r *fakeRegistrar) Broadcast(channel bcgo.Channel, cache bcgo.Cache, hash []byte, block *bcgo.Block) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if err := r.unavailable(); err != nil {
+		return err
+	}
+	name := channel.Name()
+	if head, ok := r.heads[name]; ok {
+		if b, ok := r.blocks[string(head.BlockHash)]; ok && b.Length >= block.Length {
+			return nil
+		}
+	}
+	for h, b := hash, block; b != nil; {
+		r.blocks[string(h)] = b
+		h = b.Previous
+		if h == nil {
+			break
+		}
+		if _, ok := r.blocks[string(h)]; ok {
+			break
+		}
+		var err error
+		if b, err = cache.Block(h); err != nil {
+			return err
+		}
+	}
+	r.heads[name] = &bcgo.Reference{
+		Timestamp:   block.Timestamp,
+		ChannelName: name,
+		BlockHash:   hash,
+	}
+	return nil
+}
+
+func (r *fakeRegistrar) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.lock.Lock()
+	down := r.down
+	r.lock.Unlock()
+	if down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, r.Registrar.Merchant.Alias)
+}
+
+func (r *fakeRegistrar) unavailable() error {
+	if r.down {
+		return spacego.ErrHostUnavailable{Host: r.Registrar.Merchant.Domain, Status: http.StatusServiceUnavailable}
+	}
+	return nil
+}
+
+func TestFakeRegistrar(t *testing.T) {
+	registrar := newFakeRegistrar("fake", "fake.example.com", nil)
+	server := httptest.NewServer(registrar)
+	defer server.Close()
+
+	channel := spacego.OpenTagChannel("test")
+	b1 := &bcgo.Block{ChannelName: channel.Name(), Length: 1}
+	h1, err := cryptogo.HashProtobuf(b1)
+	testinggo.AssertNoError(t, err)
+	b2 := &bcgo.Block{ChannelName: channel.Name(), Length: 2, Previous: h1}
+	h2, err := cryptogo.HashProtobuf(b2)
+	testinggo.AssertNoError(t, err)
+
+	testinggo.AssertNoError(t, registrar.Broadcast(channel, nil, h1, b1))
+	testinggo.AssertNoError(t, registrar.Broadcast(channel, nil, h2, b2))
+	// Shorter chains do not replace the head
+	testinggo.AssertNoError(t, registrar.Broadcast(channel, nil, h1, b1))
+
+	head, err := registrar.Head(channel.Name())
+	testinggo.AssertNoError(t, err)
+	assert.Equal(t, h2, head.BlockHash)
+	assert.True(t, spacego.AuditBlocks("fake", channel.Name(), [][]byte{h2, h1}, registrar).Ok())
+
+	registrar.Drop(h1)
+	assert.Equal(t, [][]byte{h1}, spacego.AuditBlocks("fake", channel.Name(), [][]byte{h2, h1}, registrar).Missing)
+
+	monitor := spacego.NewHealthMonitor(nil)
+	monitor.Add("fake", server.URL)
+	registrar.SetDown(true)
+	for i := 0; i < spacego.HEALTH_FAILURE_THRESHOLD; i++ {
+		monitor.Check()
+	}
+	assert.Empty(t, monitor.HealthyHosts())
+	_, err = registrar.Head(channel.Name())
+	assert.Error(t, err)
+
+	registrar.SetDown(false)
+	monitor.Check()
+	assert.Equal(t, []string{"fake"}, monitor.HealthyHosts())
+}
@@ -16,10 +16,159 @@
 
 package spacego
 
+import (
+	"aletheiaware.com/bcgo"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
 type MetaFilter interface {
 	Filter(*Meta) bool
 }
 
+// MetaIdFilter is implemented by filters which also need the metaId of the file, such as those joining other channels.
+// Such a filter cannot match without the metaId, so its Filter returns false, as does that of any And, Or, or Not filter combining it.
+type MetaIdFilter interface {
+	MetaFilter
+	FilterId(string, *Meta) bool
+}
+
+// NeedsMetaId returns true if the given filter, or any filter it combines, can only be evaluated with the metaId of the file using FilterMeta.
+func NeedsMetaId(filter MetaFilter) bool {
+	switch f := filter.(type) {
+	case *andMetaFilter:
+		return f.needsId
+	case *orMetaFilter:
+		return f.needsId
+	case *notMetaFilter:
+		return f.needsId
+	case MetaIdFilter:
+		return true
+	}
+	return false
+}
+
+func anyNeedsMetaId(filters []MetaFilter) bool {
+	for _, f := range filters {
+		if NeedsMetaId(f) {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterMeta returns the result of the given filter for the file with the given metaId and Meta.
+func FilterMeta(filter MetaFilter, metaId string, meta *Meta) bool {
+	if f, ok := filter.(MetaIdFilter); ok {
+		return f.FilterId(metaId, meta)
+	}
+	return filter.Filter(meta)
+}
+
+// ListMeta triggers the given callback for the latest version of each file in the node's Meta channel which matches the given filter, in path order.
+// Files in the trash are not included, and a nil filter matches every file.
+func ListMeta(node bcgo.Node, filter MetaFilter, callback func(string, *Meta) error) error {
+	_, h, err := openMetaHierarchy(node)
+	if err != nil {
+		return err
+	}
+	for _, id := range h.Files() {
+		meta := h.Meta(id)
+		if filter != nil && !FilterMeta(filter, id, meta) {
+			continue
+		}
+		if err := callback(id, meta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func NewAndMetaFilter(filters ...MetaFilter) MetaFilter {
+	return &andMetaFilter{
+		filters: filters,
+		needsId: anyNeedsMetaId(filters),
+	}
+}
+
+type andMetaFilter struct {
+	filters []MetaFilter
+	needsId bool
+}
+
+func (f *andMetaFilter) Filter(meta *Meta) bool {
+	return f.FilterId("", meta)
+}
+
+func (f *andMetaFilter) FilterId(metaId string, meta *Meta) bool {
+	if metaId == "" && f.needsId {
+		// Cannot match without the metaId
+		return false
+	}
+	for _, filter := range f.filters {
+		if !FilterMeta(filter, metaId, meta) {
+			return false
+		}
+	}
+	return true
+}
+
+func NewOrMetaFilter(filters ...MetaFilter) MetaFilter {
+	return &orMetaFilter{
+		filters: filters,
+		needsId: anyNeedsMetaId(filters),
+	}
+}
+
+type orMetaFilter struct {
+	filters []MetaFilter
+	needsId bool
+}
+
+func (f *orMetaFilter) Filter(meta *Meta) bool {
+	return f.FilterId("", meta)
+}
+
+func (f *orMetaFilter) FilterId(metaId string, meta *Meta) bool {
+	if metaId == "" && f.needsId {
+		// Cannot match without the metaId
+		return false
+	}
+	for _, filter := range f.filters {
+		if FilterMeta(filter, metaId, meta) {
+			return true
+		}
+	}
+	return false
+}
+
+func NewNotMetaFilter(filter MetaFilter) MetaFilter {
+	return &notMetaFilter{
+		filter:  filter,
+		needsId: NeedsMetaId(filter),
+	}
+}
+
+type notMetaFilter struct {
+	filter  MetaFilter
+	needsId bool
+}
+
+func (f *notMetaFilter) Filter(meta *Meta) bool {
+	return f.FilterId("", meta)
+}
+
+func (f *notMetaFilter) FilterId(metaId string, meta *Meta) bool {
+	if metaId == "" && f.needsId {
+		// Cannot match without the metaId, rather than matching every file
+		return false
+	}
+	return !FilterMeta(f.filter, metaId, meta)
+}
+
 func NewNameFilter(names ...string) MetaFilter {
 	return &nameFilter{
 		names: names,
@@ -39,6 +188,84 @@ func (f *nameFilter) Filter(meta *Meta) bool {
 	return false
 }
 
+// NewNameGlobFilter returns a MetaFilter which matches names against the given patterns, as used by path.Match.
+func NewNameGlobFilter(patterns ...string) (MetaFilter, error) {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, err
+		}
+	}
+	return &nameGlobFilter{
+		patterns: patterns,
+	}, nil
+}
+
+type nameGlobFilter struct {
+	patterns []string
+}
+
+func (f *nameGlobFilter) Filter(meta *Meta) bool {
+	for _, value := range f.patterns {
+		if ok, _ := path.Match(value, meta.Name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// NewNameRegexFilter returns a MetaFilter which matches names against the given regular expressions.
+func NewNameRegexFilter(expressions ...string) (MetaFilter, error) {
+	var regexps []*regexp.Regexp
+	for _, e := range expressions {
+		r, err := regexp.Compile(e)
+		if err != nil {
+			return nil, err
+		}
+		regexps = append(regexps, r)
+	}
+	return &nameRegexFilter{
+		regexps: regexps,
+	}, nil
+}
+
+type nameRegexFilter struct {
+	regexps []*regexp.Regexp
+}
+
+func (f *nameRegexFilter) Filter(meta *Meta) bool {
+	for _, value := range f.regexps {
+		if value.MatchString(meta.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewNameContainsFilter returns a MetaFilter which matches names containing any of the given substrings, ignoring case.
+func NewNameContainsFilter(substrings ...string) MetaFilter {
+	var lowers []string
+	for _, s := range substrings {
+		lowers = append(lowers, strings.ToLower(s))
+	}
+	return &nameContainsFilter{
+		substrings: lowers,
+	}
+}
+
+type nameContainsFilter struct {
+	substrings []string
+}
+
+func (f *nameContainsFilter) Filter(meta *Meta) bool {
+	name := strings.ToLower(meta.Name)
+	for _, value := range f.substrings {
+		if strings.Contains(name, value) {
+			return true
+		}
+	}
+	return false
+}
+
 func NewTypeFilter(types ...string) MetaFilter {
 	return &typeFilter{
 		types: types,
@@ -58,10 +285,239 @@ func (f *typeFilter) Filter(meta *Meta) bool {
 	return false
 }
 
+// NewTypeWildcardFilter returns a MetaFilter which matches MIME types against the given patterns, such as "image/*" or "*/*".
+func NewTypeWildcardFilter(patterns ...string) MetaFilter {
+	return &typeWildcardFilter{
+		patterns: patterns,
+	}
+}
+
+type typeWildcardFilter struct {
+	patterns []string
+}
+
+func (f *typeWildcardFilter) Filter(meta *Meta) bool {
+	for _, value := range f.patterns {
+		if MatchMimeType(value, meta.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchMimeType returns true if the given MIME type matches the given pattern, where either part of the pattern may be "*".
+func MatchMimeType(pattern, mime string) bool {
+	pt, ps := splitMimeType(pattern)
+	mt, ms := splitMimeType(mime)
+	return (pt == "*" || strings.EqualFold(pt, mt)) && (ps == "*" || strings.EqualFold(ps, ms))
+}
+
+func splitMimeType(mime string) (string, string) {
+	if i := strings.Index(mime, "/"); i >= 0 {
+		return mime[:i], mime[i+1:]
+	}
+	return mime, ""
+}
+
+// NewHasTagFilter returns a MetaFilter which matches files with at least one current tag matching the given TagFilter.
+// Tags are read from the Tag channel of each file, so the filter must be given the metaId using FilterMeta.
+func NewHasTagFilter(node bcgo.Node, filter TagFilter) MetaFilter {
+	return &hasTagFilter{
+		node:   node,
+		filter: filter,
+	}
+}
+
+type hasTagFilter struct {
+	node   bcgo.Node
+	filter TagFilter
+}
+
+func (f *hasTagFilter) Filter(meta *Meta) bool {
+	// Tags cannot be found without the metaId
+	return false
+}
+
+func (f *hasTagFilter) FilterId(metaId string, meta *Meta) bool {
+	if metaId == "" {
+		return false
+	}
+	set, err := ReadTagSet(f.node, metaId)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	for _, tag := range set.Tags() {
+		if f.filter.Filter(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewIndexedTagFilter returns a MetaFilter which matches files with at least one current tag matching the given TagFilter.
+// Tags are looked up in the given TagIndex rather than read from each Tag channel, so the caller keeps the index up to date with TagIndex.Update, and the filter must be given the metaId using FilterMeta.
+func NewIndexedTagFilter(index *TagIndex, filter TagFilter) MetaFilter {
+	return &indexedTagFilter{
+		index:  index,
+		filter: filter,
+	}
+}
+
+type indexedTagFilter struct {
+	index  *TagIndex
+	filter TagFilter
+}
+
+func (f *indexedTagFilter) Filter(meta *Meta) bool {
+	// Tags cannot be found without the metaId
+	return false
+}
+
+func (f *indexedTagFilter) FilterId(metaId string, meta *Meta) bool {
+	if metaId == "" {
+		return false
+	}
+	for _, tag := range f.index.Tags(metaId) {
+		if f.filter.Filter(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewModifiedFilter returns a MetaFilter which compares the time a file's content was last modified, the timestamp of the head of its Delta channel, with the interval [start, end) using the given operator.
+// The filter must be given the metaId using FilterMeta.
+func NewModifiedFilter(node bcgo.Node, operator string, start, end time.Time) MetaFilter {
+	return &modifiedFilter{
+		node:     node,
+		operator: operator,
+		start:    uint64(start.UnixNano()),
+		end:      uint64(end.UnixNano()),
+	}
+}
+
+type modifiedFilter struct {
+	node       bcgo.Node
+	operator   string
+	start, end uint64
+}
+
+func (f *modifiedFilter) Filter(meta *Meta) bool {
+	// Modification time cannot be found without the metaId
+	return false
+}
+
+func (f *modifiedFilter) FilterId(metaId string, meta *Meta) bool {
+	if metaId == "" {
+		return false
+	}
+	deltas := f.node.OpenChannel(DeltaChannelName(metaId), func() bcgo.Channel {
+		return OpenDeltaChannel(metaId)
+	})
+	if err := deltas.Refresh(f.node.Cache(), f.node.Network()); err != nil {
+		log.Println(err)
+	}
+	return compareInterval(f.operator, deltas.Timestamp(), f.start, f.end)
+}
+
+func compareInterval(operator string, value, start, end uint64) bool {
+	switch operator {
+	case ">":
+		return value >= end
+	case ">=":
+		return value >= start
+	case "<":
+		return value < start
+	case "<=":
+		return value < end
+	default:
+		return value >= start && value < end
+	}
+}
+
 type TagFilter interface {
 	Filter(*Tag) bool
 }
 
+// NewTagReasonContainsFilter returns a TagFilter which matches reasons containing any of the given substrings, ignoring case.
+func NewTagReasonContainsFilter(substrings ...string) TagFilter {
+	var lowers []string
+	for _, s := range substrings {
+		lowers = append(lowers, strings.ToLower(s))
+	}
+	return &tagReasonContainsFilter{
+		substrings: lowers,
+	}
+}
+
+type tagReasonContainsFilter struct {
+	substrings []string
+}
+
+func (f *tagReasonContainsFilter) Filter(tag *Tag) bool {
+	reason := strings.ToLower(tag.Reason)
+	for _, value := range f.substrings {
+		if strings.Contains(reason, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func NewAndTagFilter(filters ...TagFilter) TagFilter {
+	return &andTagFilter{
+		filters: filters,
+	}
+}
+
+type andTagFilter struct {
+	filters []TagFilter
+}
+
+func (f *andTagFilter) Filter(tag *Tag) bool {
+	for _, filter := range f.filters {
+		if !filter.Filter(tag) {
+			return false
+		}
+	}
+	return true
+}
+
+func NewOrTagFilter(filters ...TagFilter) TagFilter {
+	return &orTagFilter{
+		filters: filters,
+	}
+}
+
+type orTagFilter struct {
+	filters []TagFilter
+}
+
+func (f *orTagFilter) Filter(tag *Tag) bool {
+	for _, filter := range f.filters {
+		if filter.Filter(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func NewNotTagFilter(filter TagFilter) TagFilter {
+	return &notTagFilter{
+		filter: filter,
+	}
+}
+
+type notTagFilter struct {
+	filter TagFilter
+}
+
+func (f *notTagFilter) Filter(tag *Tag) bool {
+	return !f.filter.Filter(tag)
+}
+
+// NewTagFilter returns a TagFilter which matches tag values against the given patterns, as used by MatchTag.
 func NewTagFilter(tags ...string) TagFilter {
 	return &tagFilter{
 		tags: tags,
@@ -74,7 +530,7 @@ type tagFilter struct {
 
 func (f *tagFilter) Filter(tag *Tag) bool {
 	for _, value := range f.tags {
-		if tag.Value == value {
+		if MatchTag(value, tag.Value) {
 			return true
 		}
 	}
@@ -0,0 +1,230 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego_test
+
+import (
+	"aletheiaware.com/spacego"
+	"aletheiaware.com/testinggo"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// idFilter matches files by metaId, standing in for filters which join other channels.
+type idFilter string
+
+func (f idFilter) Filter(*spacego.Meta) bool {
+	return false
+}
+
+func (f idFilter) FilterId(metaId string, meta *spacego.Meta) bool {
+	return string(f) == metaId
+}
+
+func TestMetaFilter(t *testing.T) {
+	glob, err := spacego.NewNameGlobFilter("*.invoice.pdf")
+	testinggo.AssertNoError(t, err)
+	regex, err := spacego.NewNameRegexFilter("^draft-[0-9]+")
+	testinggo.AssertNoError(t, err)
+	for name, tt := range map[string]struct {
+		filter   spacego.MetaFilter
+		meta     *spacego.Meta
+		expected bool
+	}{
+		"name": {
+			filter:   spacego.NewNameFilter("foo", "bar"),
+			meta:     &spacego.Meta{Name: "bar"},
+			expected: true,
+		},
+		"glob_match": {
+			filter:   glob,
+			meta:     &spacego.Meta{Name: "march.invoice.pdf"},
+			expected: true,
+		},
+		"glob_mismatch": {
+			filter: glob,
+			meta:   &spacego.Meta{Name: "march.pdf"},
+		},
+		"regex_match": {
+			filter:   regex,
+			meta:     &spacego.Meta{Name: "draft-12.txt"},
+			expected: true,
+		},
+		"regex_mismatch": {
+			filter: regex,
+			meta:   &spacego.Meta{Name: "final-draft-12.txt"},
+		},
+		"contains": {
+			filter:   spacego.NewNameContainsFilter("Draft"),
+			meta:     &spacego.Meta{Name: "final-DRAFT.txt"},
+			expected: true,
+		},
+		"type_wildcard": {
+			filter:   spacego.NewTypeWildcardFilter("image/*"),
+			meta:     &spacego.Meta{Type: spacego.MIME_TYPE_IMAGE_PNG},
+			expected: true,
+		},
+		"type_wildcard_mismatch": {
+			filter: spacego.NewTypeWildcardFilter("image/*"),
+			meta:   &spacego.Meta{Type: spacego.MIME_TYPE_PDF},
+		},
+		"type_wildcard_all": {
+			filter:   spacego.NewTypeWildcardFilter("*/*"),
+			meta:     &spacego.Meta{Type: spacego.MIME_TYPE_PDF},
+			expected: true,
+		},
+		"and": {
+			filter: spacego.NewAndMetaFilter(
+				spacego.NewTypeFilter(spacego.MIME_TYPE_PDF),
+				spacego.NewNotMetaFilter(spacego.NewNameContainsFilter("archive")),
+			),
+			meta:     &spacego.Meta{Name: "invoice.pdf", Type: spacego.MIME_TYPE_PDF},
+			expected: true,
+		},
+		"and_mismatch": {
+			filter: spacego.NewAndMetaFilter(
+				spacego.NewTypeFilter(spacego.MIME_TYPE_PDF),
+				spacego.NewNotMetaFilter(spacego.NewNameContainsFilter("archive")),
+			),
+			meta: &spacego.Meta{Name: "archive.pdf", Type: spacego.MIME_TYPE_PDF},
+		},
+		"or": {
+			filter: spacego.NewOrMetaFilter(
+				spacego.NewTypeFilter(spacego.MIME_TYPE_PDF),
+				spacego.NewTypeWildcardFilter("image/*"),
+			),
+			meta:     &spacego.Meta{Type: spacego.MIME_TYPE_IMAGE_GIF},
+			expected: true,
+		},
+		"or_empty": {
+			filter: spacego.NewOrMetaFilter(),
+			meta:   &spacego.Meta{},
+		},
+		"and_empty": {
+			filter:   spacego.NewAndMetaFilter(),
+			meta:     &spacego.Meta{},
+			expected: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.filter.Filter(tt.meta))
+		})
+	}
+}
+
+func TestMetaFilterInvalid(t *testing.T) {
+	_, err := spacego.NewNameGlobFilter("[")
+	assert.Error(t, err)
+	_, err = spacego.NewNameRegexFilter("(")
+	assert.Error(t, err)
+}
+
+func TestFilterMeta(t *testing.T) {
+	meta := &spacego.Meta{Type: spacego.MIME_TYPE_PDF}
+	filter := spacego.NewAndMetaFilter(
+		spacego.NewTypeFilter(spacego.MIME_TYPE_PDF),
+		spacego.NewNotMetaFilter(idFilter("archived")),
+	)
+	assert.True(t, spacego.FilterMeta(filter, "current", meta))
+	assert.False(t, spacego.FilterMeta(filter, "archived", meta))
+	assert.True(t, spacego.FilterMeta(spacego.NewTypeFilter(spacego.MIME_TYPE_PDF), "", meta))
+}
+
+func TestFilterMeta_WithoutMetaId(t *testing.T) {
+	meta := &spacego.Meta{Type: spacego.MIME_TYPE_PDF}
+	for name, filter := range map[string]spacego.MetaFilter{
+		"not": spacego.NewNotMetaFilter(idFilter("archived")),
+		"and": spacego.NewAndMetaFilter(
+			spacego.NewTypeFilter(spacego.MIME_TYPE_PDF),
+			spacego.NewNotMetaFilter(idFilter("archived")),
+		),
+		"or": spacego.NewOrMetaFilter(
+			spacego.NewTypeFilter(spacego.MIME_TYPE_PDF),
+			idFilter("archived"),
+		),
+		"not_not": spacego.NewNotMetaFilter(spacego.NewNotMetaFilter(idFilter("archived"))),
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.True(t, spacego.NeedsMetaId(filter))
+			// Filters needing the metaId match nothing without it
+			assert.False(t, filter.Filter(meta))
+			assert.False(t, spacego.FilterMeta(filter, "", meta))
+		})
+	}
+	assert.False(t, spacego.NeedsMetaId(spacego.NewNotMetaFilter(spacego.NewTypeFilter(spacego.MIME_TYPE_PDF))))
+	assert.True(t, spacego.NewNotMetaFilter(spacego.NewTypeFilter(spacego.MIME_TYPE_TEXT_PLAIN)).Filter(meta))
+}
+
+func TestHasTagFilter(t *testing.T) {
+	node := newFakeNode("alice", newFakeCache())
+	for id, value := range map[string]string{
+		"a": "draft",
+		"b": "work",
+	} {
+		data, err := proto.Marshal(&spacego.Tag{Value: value})
+		testinggo.AssertNoError(t, err)
+		writeRecord(t, node, spacego.TagChannelName(id), nil, data)
+	}
+	meta := &spacego.Meta{}
+	draft := spacego.NewHasTagFilter(node, spacego.NewTagFilter("draft"))
+	assert.True(t, spacego.FilterMeta(draft, "a", meta))
+	assert.False(t, spacego.FilterMeta(draft, "b", meta))
+	assert.False(t, spacego.FilterMeta(draft, "c", meta))
+	assert.False(t, draft.Filter(meta))
+
+	notDraft := spacego.NewNotMetaFilter(draft)
+	assert.False(t, spacego.FilterMeta(notDraft, "a", meta))
+	assert.True(t, spacego.FilterMeta(notDraft, "b", meta))
+	assert.False(t, notDraft.Filter(meta))
+}
+
+func TestIndexedTagFilter(t *testing.T) {
+	i := testTagIndex(t)
+	meta := &spacego.Meta{}
+	draft := spacego.NewIndexedTagFilter(i, spacego.NewTagFilter("draft"))
+	assert.True(t, spacego.FilterMeta(draft, "a", meta))
+	assert.False(t, spacego.FilterMeta(draft, "b", meta))
+	// Removed tags do not match
+	assert.False(t, spacego.FilterMeta(draft, "c", meta))
+	assert.False(t, draft.Filter(meta))
+
+	notDraft := spacego.NewNotMetaFilter(draft)
+	assert.False(t, spacego.FilterMeta(notDraft, "a", meta))
+	assert.True(t, spacego.FilterMeta(notDraft, "b", meta))
+	assert.False(t, notDraft.Filter(meta))
+}
+
+func TestTagFilter(t *testing.T) {
+	filter := spacego.NewAndTagFilter(
+		spacego.NewOrTagFilter(
+			spacego.NewTagFilter("invoice"),
+			spacego.NewTagFilter("receipt"),
+		),
+		spacego.NewNotTagFilter(spacego.NewTagFilter("receipt")),
+	)
+	assert.True(t, filter.Filter(&spacego.Tag{Value: "invoice"}))
+	assert.False(t, filter.Filter(&spacego.Tag{Value: "receipt"}))
+	assert.False(t, filter.Filter(&spacego.Tag{Value: "archived"}))
+}
+
+func TestMatchMimeType(t *testing.T) {
+	assert.True(t, spacego.MatchMimeType("image/png", "image/png"))
+	assert.True(t, spacego.MatchMimeType("IMAGE/*", "image/png"))
+	assert.True(t, spacego.MatchMimeType("*/*", "text/plain"))
+	assert.False(t, spacego.MatchMimeType("image/*", "text/plain"))
+	assert.False(t, spacego.MatchMimeType("image/png", "image/jpeg"))
+}
@@ -9,4 +9,5 @@ require (
 	aletheiaware.com/testinggo v1.2.2
 	github.com/golang/protobuf v1.5.2
 	github.com/stretchr/testify v1.7.0
+	golang.org/x/image v0.18.0
 )
@@ -0,0 +1,456 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego
+
+import (
+	"aletheiaware.com/bcgo"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	HEALTH_CHECK_INTERVAL    = time.Minute
+	HEALTH_CHECK_TIMEOUT     = 10 * time.Second
+	HEALTH_WINDOW            = 10 // Number of recent checks used for success rate and latency
+	HEALTH_FAILURE_THRESHOLD = 3  // Number of consecutive failures after which a host is unhealthy
+)
+
+var ErrNoHealthyHosts = errors.New("No healthy hosts")
+
+type ErrHostUnavailable struct {
+	Host   string
+	Status int
+}
+
+func (e ErrHostUnavailable) Error() string {
+	return fmt.Sprintf("Host unavailable: %s responded %d", e.Host, e.Status)
+}
+
+type ErrInsufficientBroadcast struct {
+	Accepted int
+	Required int
+	Err      error // Last error returned by a host
+}
+
+func (e ErrInsufficientBroadcast) Error() string {
+	return fmt.Sprintf("Insufficient broadcast: %d of %d required hosts accepted: %v", e.Accepted, e.Required, e.Err)
+}
+
+// HostHealth is the state of a host as seen by a HealthMonitor.
+type HostHealth struct {
+	Host                string
+	Endpoint            string
+	Healthy             bool
+	Checks              int           // Number of checks in the window
+	SuccessRate         float64       // Fraction of checks in the window which succeeded
+	Latency             time.Duration // Mean latency of the checks in the window which succeeded
+	ConsecutiveFailures int
+	LastChecked         time.Time
+	LastError           error
+}
+
+// HealthListener is triggered when a host becomes healthy or unhealthy.
+type HealthListener func(host string, healthy bool)
+
+// HealthMonitor probes the endpoint of each host, tracks its success rate and latency, and marks it unhealthy after HEALTH_FAILURE_THRESHOLD consecutive failures.
+// A host is healthy when added and becomes healthy again when a check succeeds.
+// HealthMonitor is safe for concurrent use.
+type HealthMonitor struct {
+	client    *http.Client
+	lock      sync.Mutex
+	hosts     map[string]*hostHealth
+	listeners []HealthListener
+	stop      chan struct{}
+}
+
+type hostHealth struct {
+	endpoint string
+	results  []healthResult
+	failures int
+	healthy  bool
+	checked  time.Time
+	err      error
+}
+
+type healthResult struct {
+	latency time.Duration
+	ok      bool
+}
+
+// NewHealthMonitor returns a HealthMonitor which probes hosts using the given client, or one with HEALTH_CHECK_TIMEOUT if nil.
+func NewHealthMonitor(client *http.Client) *HealthMonitor {
+	if client == nil {
+		client = &http.Client{
+			Timeout: HEALTH_CHECK_TIMEOUT,
+		}
+	}
+	return &HealthMonitor{
+		client: client,
+		hosts:  make(map[string]*hostHealth),
+	}
+}
+
+// Add monitors the given host by probing the given endpoint URL, replacing any endpoint previously added for the host.
+func (m *HealthMonitor) Add(host, endpoint string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if h, ok := m.hosts[host]; ok {
+		h.endpoint = endpoint
+		return
+	}
+	m.hosts[host] = &hostHealth{
+		endpoint: endpoint,
+		healthy:  true,
+	}
+}
+
+// AddHosts monitors the given hosts, such as those from ProfileHosts(), by probing their websites.
+func (m *HealthMonitor) AddHosts(hosts ...string) {
+	for _, h := range hosts {
+		m.Add(h, "https://"+h)
+	}
+}
+
+// AddRegistrars monitors the domain of each registrar in the Registrar channel.
+func (m *HealthMonitor) AddRegistrars(node bcgo.Node) error {
+	return AllRegistrars(node, func(entry *bcgo.BlockEntry, registrar *Registrar) error {
+		if registrar.Merchant != nil && registrar.Merchant.Domain != "" {
+			m.AddHosts(registrar.Merchant.Domain)
+		}
+		return nil
+	})
+}
+
+// Remove stops monitoring the given host.
+func (m *HealthMonitor) Remove(host string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.hosts, host)
+}
+
+// AddListener adds a listener which is triggered when a host becomes healthy or unhealthy.
+func (m *HealthMonitor) AddListener(listener HealthListener) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.listeners = append(m.listeners, listener)
+}
+
+// Check probes every host concurrently and returns once all have responded or timed out.
+func (m *HealthMonitor) Check() {
+	m.lock.Lock()
+	endpoints := make(map[string]string)
+	for host, h := range m.hosts {
+		endpoints[host] = h.endpoint
+	}
+	m.lock.Unlock()
+	var wg sync.WaitGroup
+	for host, endpoint := range endpoints {
+		wg.Add(1)
+		go func(host, endpoint string) {
+			defer wg.Done()
+			latency, err := m.probe(host, endpoint)
+			m.Record(host, latency, err)
+		}(host, endpoint)
+	}
+	wg.Wait()
+}
+
+// Record updates the state of the given host with the result of a request, such as a check or a request made by the caller.
+func (m *HealthMonitor) Record(host string, latency time.Duration, err error) {
+	m.lock.Lock()
+	h, ok := m.hosts[host]
+	if !ok {
+		m.lock.Unlock()
+		return
+	}
+	h.results = append(h.results, healthResult{
+		latency: latency,
+		ok:      err == nil,
+	})
+	if len(h.results) > HEALTH_WINDOW {
+		h.results = h.results[len(h.results)-HEALTH_WINDOW:]
+	}
+	h.checked = time.Now()
+	h.err = err
+	was := h.healthy
+	if err == nil {
+		h.failures = 0
+		h.healthy = true
+	} else {
+		h.failures++
+		if h.failures >= HEALTH_FAILURE_THRESHOLD {
+			h.healthy = false
+		}
+	}
+	changed := was != h.healthy
+	healthy := h.healthy
+	listeners := append([]HealthListener{}, m.listeners...)
+	m.lock.Unlock()
+	if changed {
+		for _, l := range listeners {
+			l(host, healthy)
+		}
+	}
+}
+
+// Start checks every host at the given interval, or HEALTH_CHECK_INTERVAL if zero, until Stop is called.
+func (m *HealthMonitor) Start(interval time.Duration) {
+	if interval <= 0 {
+		interval = HEALTH_CHECK_INTERVAL
+	}
+	m.lock.Lock()
+	if m.stop != nil {
+		m.lock.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	m.stop = stop
+	m.lock.Unlock()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		m.Check()
+		for {
+			select {
+			case <-ticker.C:
+				m.Check()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends periodic checking started by Start.
+func (m *HealthMonitor) Stop() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.stop != nil {
+		close(m.stop)
+		m.stop = nil
+	}
+}
+
+// Status returns the state of every host, sorted by host.
+func (m *HealthMonitor) Status() []*HostHealth {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var status []*HostHealth
+	for host, h := range m.hosts {
+		s := &HostHealth{
+			Host:                host,
+			Endpoint:            h.endpoint,
+			Healthy:             h.healthy,
+			Checks:              len(h.results),
+			ConsecutiveFailures: h.failures,
+			LastChecked:         h.checked,
+			LastError:           h.err,
+		}
+		var successes int
+		var total time.Duration
+		for _, r := range h.results {
+			if r.ok {
+				successes++
+				total += r.latency
+			}
+		}
+		if len(h.results) > 0 {
+			s.SuccessRate = float64(successes) / float64(len(h.results))
+		}
+		if successes > 0 {
+			s.Latency = total / time.Duration(successes)
+		}
+		status = append(status, s)
+	}
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Host < status[j].Host
+	})
+	return status
+}
+
+// Hosts returns the healthy hosts, fastest first, followed by the unhealthy hosts, least recently failing first, as a failover order.
+func (m *HealthMonitor) Hosts() []string {
+	status := m.Status()
+	sort.SliceStable(status, func(i, j int) bool {
+		a, b := status[i], status[j]
+		if a.Healthy != b.Healthy {
+			return a.Healthy
+		}
+		if a.Healthy {
+			return a.Latency < b.Latency
+		}
+		return a.ConsecutiveFailures < b.ConsecutiveFailures
+	})
+	var hosts []string
+	for _, s := range status {
+		hosts = append(hosts, s.Host)
+	}
+	return hosts
+}
+
+// HealthyHosts returns the healthy hosts, fastest first.
+func (m *HealthMonitor) HealthyHosts() []string {
+	var hosts []string
+	status := m.Status()
+	sort.SliceStable(status, func(i, j int) bool {
+		return status[i].Latency < status[j].Latency
+	})
+	for _, s := range status {
+		if s.Healthy {
+			hosts = append(hosts, s.Host)
+		}
+	}
+	return hosts
+}
+
+// Sufficient returns true if at least MinimumRegistrars() hosts are healthy, so the app can warn when data is at risk.
+func (m *HealthMonitor) Sufficient() bool {
+	return len(m.HealthyHosts()) >= MinimumRegistrars()
+}
+
+func (m *HealthMonitor) probe(host, endpoint string) (time.Duration, error) {
+	start := time.Now()
+	response, err := m.client.Get(endpoint)
+	if err != nil {
+		return 0, err
+	}
+	response.Body.Close()
+	latency := time.Since(start)
+	if response.StatusCode >= http.StatusInternalServerError {
+		return latency, ErrHostUnavailable{Host: host, Status: response.StatusCode}
+	}
+	return latency, nil
+}
+
+// NewFailoverNetwork returns a bcgo.Network which routes requests to the hosts of the given monitor, using the network returned by the given function for each host.
+// The latency or transport error of every request is recorded with the monitor, so hosts which cannot be reached become unhealthy, while a host answering that it does not have a head or block still counts as healthy.
+// Reads try healthy hosts fastest first, then unhealthy hosts, until one succeeds.
+// Writes are broadcast to every healthy host, and then to unhealthy hosts until MinimumRegistrars() have accepted them, or fail with ErrInsufficientBroadcast.
+func NewFailoverNetwork(monitor *HealthMonitor, network func(host string) bcgo.Network) bcgo.Network {
+	return &failoverNetwork{
+		monitor: monitor,
+		network: network,
+	}
+}
+
+type failoverNetwork struct {
+	monitor *HealthMonitor
+	network func(string) bcgo.Network
+}
+
+func (n *failoverNetwork) Head(channel string) (*bcgo.Reference, error) {
+	var last error = ErrNoHealthyHosts
+	for _, host := range n.monitor.Hosts() {
+		var reference *bcgo.Reference
+		err := n.request(host, func(network bcgo.Network) (err error) {
+			reference, err = network.Head(channel)
+			return
+		})
+		if err == nil {
+			return reference, nil
+		}
+		last = err
+	}
+	return nil, last
+}
+
+func (n *failoverNetwork) Block(reference *bcgo.Reference) (*bcgo.Block, error) {
+	var last error = ErrNoHealthyHosts
+	for _, host := range n.monitor.Hosts() {
+		var block *bcgo.Block
+		err := n.request(host, func(network bcgo.Network) (err error) {
+			block, err = network.Block(reference)
+			return
+		})
+		if err == nil {
+			return block, nil
+		}
+		last = err
+	}
+	return nil, last
+}
+
+func (n *failoverNetwork) Broadcast(channel bcgo.Channel, cache bcgo.Cache, hash []byte, block *bcgo.Block) error {
+	required := MinimumRegistrars()
+	var last error = ErrNoHealthyHosts
+	accepted := 0
+	broadcast := func(host string) {
+		if err := n.request(host, func(network bcgo.Network) error {
+			return network.Broadcast(channel, cache, hash, block)
+		}); err != nil {
+			last = err
+		} else {
+			accepted++
+		}
+	}
+	healthy := make(map[string]bool)
+	for _, host := range n.monitor.HealthyHosts() {
+		healthy[host] = true
+		broadcast(host)
+	}
+	for _, host := range n.monitor.Hosts() {
+		if accepted >= required {
+			break
+		}
+		if !healthy[host] {
+			broadcast(host)
+		}
+	}
+	if accepted < required {
+		return ErrInsufficientBroadcast{
+			Accepted: accepted,
+			Required: required,
+			Err:      last,
+		}
+	}
+	return nil
+}
+
+// request makes the given request of the network of the given host, and records its latency or transport error with the monitor.
+func (n *failoverNetwork) request(host string, request func(bcgo.Network) error) error {
+	start := time.Now()
+	err := request(n.network(host))
+	if isTransportError(err) {
+		n.monitor.Record(host, time.Since(start), err)
+	} else {
+		// The host answered, even if only to say it has no such head or block
+		n.monitor.Record(host, time.Since(start), nil)
+	}
+	return err
+}
+
+// isTransportError returns true if the given error is a failure to reach a host, such as failing to dial, timing out, losing the connection, or the host responding with a server error.
+func isTransportError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var unavailable ErrHostUnavailable
+	if errors.As(err, &unavailable) {
+		return unavailable.Status >= http.StatusInternalServerError
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,200 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego_test
+
+import (
+	"aletheiaware.com/bcgo"
+	"aletheiaware.com/spacego"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestHealthMonitor(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer up.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	down.Close()
+
+	m := spacego.NewHealthMonitor(nil)
+	m.Add("up", up.URL)
+	m.Add("failing", failing.URL)
+	m.Add("down", down.URL)
+
+	// Listeners are triggered by concurrent checks
+	var lock sync.Mutex
+	changes := make(map[string]bool)
+	m.AddListener(func(host string, healthy bool) {
+		lock.Lock()
+		changes[host] = healthy
+		lock.Unlock()
+	})
+
+	// Hosts are healthy until checked
+	assert.Equal(t, []string{"down", "failing", "up"}, m.HealthyHosts())
+
+	for i := 0; i < spacego.HEALTH_FAILURE_THRESHOLD-1; i++ {
+		m.Check()
+	}
+	assert.Equal(t, 3, len(m.HealthyHosts()))
+	assert.Empty(t, changes)
+
+	m.Check()
+	assert.Equal(t, []string{"up"}, m.HealthyHosts())
+	assert.Equal(t, map[string]bool{"down": false, "failing": false}, changes)
+	assert.Equal(t, "up", m.Hosts()[0])
+
+	status := m.Status()
+	assert.Equal(t, 3, len(status))
+	assert.Equal(t, "failing", status[1].Host)
+	assert.False(t, status[1].Healthy)
+	assert.Equal(t, spacego.HEALTH_FAILURE_THRESHOLD, status[1].ConsecutiveFailures)
+	assert.Equal(t, 0.0, status[1].SuccessRate)
+	assert.Equal(t, spacego.ErrHostUnavailable{Host: "failing", Status: http.StatusServiceUnavailable}, status[1].LastError)
+	assert.Equal(t, "up", status[2].Host)
+	assert.True(t, status[2].Healthy)
+	assert.Equal(t, 1.0, status[2].SuccessRate)
+	assert.True(t, m.Sufficient())
+
+	// Recovery
+	m.Record("failing", 0, nil)
+	assert.True(t, changes["failing"])
+	assert.Equal(t, 2, len(m.HealthyHosts()))
+}
+
+type fakeNetwork struct {
+	host      string
+	err       error
+	broadcast map[string]bool
+}
+
+func (n *fakeNetwork) Head(channel string) (*bcgo.Reference, error) {
+	if n.err != nil {
+		return nil, n.err
+	}
+	return &bcgo.Reference{ChannelName: channel, BlockHash: []byte(n.host)}, nil
+}
+
+func (n *fakeNetwork) Block(reference *bcgo.Reference) (*bcgo.Block, error) {
+	if n.err != nil {
+		return nil, n.err
+	}
+	return &bcgo.Block{ChannelName: n.host}, nil
+}
+
+func (n *fakeNetwork) Broadcast(channel bcgo.Channel, cache bcgo.Cache, hash []byte, block *bcgo.Block) error {
+	if n.err != nil {
+		return n.err
+	}
+	n.broadcast[n.host] = true
+	return nil
+}
+
+func TestFailoverNetwork(t *testing.T) {
+	errDown := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("Connection refused")}
+	broadcast := make(map[string]bool)
+	networks := map[string]*fakeNetwork{
+		"a": {host: "a", err: errDown, broadcast: broadcast},
+		"b": {host: "b", broadcast: broadcast},
+		"c": {host: "c", broadcast: broadcast},
+	}
+	m := spacego.NewHealthMonitor(nil)
+	for host := range networks {
+		m.Add(host, "")
+	}
+	network := spacego.NewFailoverNetwork(m, func(host string) bcgo.Network {
+		return networks[host]
+	})
+
+	// Healthy hosts are tried until one succeeds
+	reference, err := network.Head("Test")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("b"), reference.BlockHash)
+
+	// Every request is recorded
+	status := m.Status()
+	assert.Equal(t, 1, status[0].ConsecutiveFailures)
+	assert.Equal(t, errDown, status[0].LastError)
+	assert.Equal(t, 1, status[1].Checks)
+	assert.Equal(t, 1.0, status[1].SuccessRate)
+	assert.Equal(t, 0, status[2].Checks)
+
+	// Hosts answering that they have no such head are not failing
+	errNotFound := errors.New("No such head")
+	networks["b"].err = errNotFound
+	networks["c"].err = errNotFound
+	_, err = network.Head("Test")
+	assert.Equal(t, errNotFound, err)
+	for _, s := range m.Status() {
+		if s.Host != "a" {
+			assert.Equal(t, 0, s.ConsecutiveFailures)
+			assert.Equal(t, 1.0, s.SuccessRate)
+		}
+	}
+	networks["b"].err = nil
+	networks["c"].err = nil
+
+	// Hosts failing requests become unhealthy
+	for i := 1; i < spacego.HEALTH_FAILURE_THRESHOLD; i++ {
+		_, err := network.Block(&bcgo.Reference{})
+		assert.NoError(t, err)
+	}
+	assert.ElementsMatch(t, []string{"b", "c"}, m.HealthyHosts())
+
+	// Unhealthy hosts are not written to once enough healthy hosts accept
+	assert.NoError(t, network.Broadcast(nil, nil, nil, &bcgo.Block{}))
+	assert.Equal(t, map[string]bool{"b": true, "c": true}, broadcast)
+
+	// Reads fail over to unhealthy hosts
+	networks["b"].err = errDown
+	networks["c"].err = errDown
+	networks["a"].err = nil
+	block, err := network.Block(&bcgo.Reference{})
+	assert.NoError(t, err)
+	assert.Equal(t, "a", block.ChannelName)
+
+	// Writes fail over to unhealthy hosts when too few healthy hosts accept them
+	for host := range broadcast {
+		delete(broadcast, host)
+	}
+	for host := range networks {
+		for i := 0; i < spacego.HEALTH_FAILURE_THRESHOLD; i++ {
+			m.Record(host, 0, errDown)
+		}
+	}
+	assert.Empty(t, m.HealthyHosts())
+	assert.NoError(t, network.Broadcast(nil, nil, nil, &bcgo.Block{}))
+	assert.Equal(t, map[string]bool{"a": true}, broadcast)
+
+	// Writes fail when too few hosts accept them
+	networks["a"].err = errDown
+	assert.Equal(t, spacego.ErrInsufficientBroadcast{
+		Accepted: 0,
+		Required: spacego.MinimumRegistrars(),
+		Err:      errDown,
+	}, network.Broadcast(nil, nil, nil, &bcgo.Block{}))
+}
@@ -0,0 +1,176 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego
+
+import (
+	"aletheiaware.com/bcgo"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Hosts are found, in order of precedence, from the SPACE_HOSTS environment variable, the current profile of the config file named by the SPACE_CONFIG environment variable, the Registrar channel, and the default hosts of the current profile.
+const (
+	SPACE_HOSTS_ENV   = "SPACE_HOSTS"   // Comma separated hosts
+	SPACE_CONFIG_ENV  = "SPACE_CONFIG"  // Path of a JSON HostConfig
+	SPACE_PROFILE_ENV = "SPACE_PROFILE" // Name of the profile, such as "live", "test", or one defined in the config file
+
+	SPACE_PROFILE_LIVE = "live"
+	SPACE_PROFILE_TEST = "test"
+)
+
+type ErrNoSuchProfile struct {
+	Profile string
+	Config  string // Path of the config file, if any
+}
+
+func (e ErrNoSuchProfile) Error() string {
+	if e.Config == "" {
+		return fmt.Sprintf("No such profile: %s", e.Profile)
+	}
+	return fmt.Sprintf("No such profile: %s in %s", e.Profile, e.Config)
+}
+
+// HostConfig maps the names of profiles to their hosts, and is read from JSON such as {"profiles": {"local": ["localhost"]}}.
+type HostConfig struct {
+	Profiles map[string][]string `json:"profiles"`
+}
+
+// ReadHostConfig parses the JSON HostConfig in the file with the given path.
+func ReadHostConfig(path string) (*HostConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &HostConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Profile returns the profile named by the SPACE_PROFILE environment variable, or else "live" or "test" according to bcgo.IsLive().
+func Profile() string {
+	if p := strings.TrimSpace(os.Getenv(SPACE_PROFILE_ENV)); p != "" {
+		return p
+	}
+	if bcgo.IsLive() {
+		return SPACE_PROFILE_LIVE
+	}
+	return SPACE_PROFILE_TEST
+}
+
+// DefaultHosts returns the hosts run by Aletheia Ware for the given profile, or nil if the profile is neither "live" nor "test".
+func DefaultHosts(profile string) []string {
+	switch profile {
+	case SPACE_PROFILE_LIVE:
+		return []string{
+			"space-nyc.aletheiaware.com",
+			"space-sfo.aletheiaware.com",
+		}
+	case SPACE_PROFILE_TEST:
+		return []string{
+			"test-space.aletheiaware.com",
+		}
+	default:
+		return nil
+	}
+}
+
+// ConfiguredHosts returns the hosts given by the SPACE_HOSTS environment variable, or else by the current profile of the config file named by the SPACE_CONFIG environment variable.
+// Nil is returned if neither is set, and an error if the config file cannot be read or does not define the current profile, so a misconfigured client does not fall back to other hosts.
+func ConfiguredHosts() ([]string, error) {
+	if hosts := splitHosts(os.Getenv(SPACE_HOSTS_ENV)); len(hosts) > 0 {
+		return hosts, nil
+	}
+	path := os.Getenv(SPACE_CONFIG_ENV)
+	if path == "" {
+		return nil, nil
+	}
+	config, err := ReadHostConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	profile := Profile()
+	hosts := config.Profiles[profile]
+	if len(hosts) == 0 {
+		return nil, ErrNoSuchProfile{Profile: profile, Config: path}
+	}
+	return hosts, nil
+}
+
+// ProfileHosts returns the hosts given by ConfiguredHosts, or else the default hosts of the current profile, or ErrNoSuchProfile if the profile has none.
+func ProfileHosts() ([]string, error) {
+	hosts, err := ConfiguredHosts()
+	if err != nil {
+		return nil, err
+	}
+	if len(hosts) > 0 {
+		return hosts, nil
+	}
+	return defaultProfileHosts()
+}
+
+// SpaceHostsForNode returns the hosts given by ConfiguredHosts, or else the domains of the registrars in the Registrar channel, sorted, or else the default hosts of the current profile, or ErrNoSuchProfile if the profile has none.
+func SpaceHostsForNode(node bcgo.Node) ([]string, error) {
+	hosts, err := ConfiguredHosts()
+	if err != nil {
+		return nil, err
+	}
+	if len(hosts) > 0 {
+		return hosts, nil
+	}
+	domains := make(map[string]bool)
+	if err := AllRegistrars(node, func(entry *bcgo.BlockEntry, registrar *Registrar) error {
+		if registrar.Merchant != nil && registrar.Merchant.Domain != "" {
+			domains[registrar.Merchant.Domain] = true
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	for d := range domains {
+		hosts = append(hosts, d)
+	}
+	if len(hosts) > 0 {
+		sort.Strings(hosts)
+		return hosts, nil
+	}
+	return defaultProfileHosts()
+}
+
+func defaultProfileHosts() ([]string, error) {
+	profile := Profile()
+	hosts := DefaultHosts(profile)
+	if len(hosts) == 0 {
+		return nil, ErrNoSuchProfile{Profile: profile}
+	}
+	return hosts, nil
+}
+
+func splitHosts(value string) []string {
+	var hosts []string
+	for _, h := range strings.Split(value, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
@@ -0,0 +1,117 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego_test
+
+import (
+	"aletheiaware.com/spacego"
+	"aletheiaware.com/testinggo"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func setenv(t *testing.T, key, value string) {
+	t.Helper()
+	previous, ok := os.LookupEnv(key)
+	testinggo.AssertNoError(t, os.Setenv(key, value))
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, previous)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func TestSpaceHosts(t *testing.T) {
+	file, err := ioutil.TempFile("", "space-config")
+	testinggo.AssertNoError(t, err)
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(`{"profiles": {"test": ["test.example.com"], "local": ["localhost", "127.0.0.1"]}}`)
+	testinggo.AssertNoError(t, err)
+	testinggo.AssertNoError(t, file.Close())
+
+	malformed, err := ioutil.TempFile("", "space-config")
+	testinggo.AssertNoError(t, err)
+	defer os.Remove(malformed.Name())
+	_, err = malformed.WriteString(`{"profiles": [`)
+	testinggo.AssertNoError(t, err)
+	testinggo.AssertNoError(t, malformed.Close())
+
+	for name, tt := range map[string]struct {
+		hosts    string
+		config   string
+		profile  string
+		expected []string
+		err      string
+	}{
+		"default": {
+			expected: []string{"test-space.aletheiaware.com"},
+		},
+		"default_live": {
+			profile:  spacego.SPACE_PROFILE_LIVE,
+			expected: []string{"space-nyc.aletheiaware.com", "space-sfo.aletheiaware.com"},
+		},
+		"config": {
+			config:   file.Name(),
+			expected: []string{"test.example.com"},
+		},
+		"config_profile": {
+			config:   file.Name(),
+			profile:  "local",
+			expected: []string{"localhost", "127.0.0.1"},
+		},
+		"config_missing_profile": {
+			config:  file.Name(),
+			profile: spacego.SPACE_PROFILE_LIVE,
+			err:     spacego.ErrNoSuchProfile{Profile: spacego.SPACE_PROFILE_LIVE, Config: file.Name()}.Error(),
+		},
+		"config_missing": {
+			config: file.Name() + "-missing",
+			err:    "open " + file.Name() + "-missing: no such file or directory",
+		},
+		"config_malformed": {
+			config: malformed.Name(),
+			err:    "unexpected end of JSON input",
+		},
+		"custom_profile": {
+			profile: "local",
+			err:     spacego.ErrNoSuchProfile{Profile: "local"}.Error(),
+		},
+		"env": {
+			hosts:    "a.example.com, b.example.com,",
+			config:   file.Name(),
+			expected: []string{"a.example.com", "b.example.com"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			setenv(t, spacego.SPACE_HOSTS_ENV, tt.hosts)
+			setenv(t, spacego.SPACE_CONFIG_ENV, tt.config)
+			setenv(t, spacego.SPACE_PROFILE_ENV, tt.profile)
+			hosts, err := spacego.ProfileHosts()
+			if tt.err == "" {
+				testinggo.AssertNoError(t, err)
+			} else {
+				testinggo.AssertError(t, tt.err, err)
+			}
+			assert.Equal(t, tt.expected, hosts)
+			// The deprecated SpaceHosts ignores configuration
+			assert.Equal(t, spacego.DefaultHosts(spacego.SPACE_PROFILE_TEST), spacego.SpaceHosts())
+		})
+	}
+}
@@ -0,0 +1,262 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego
+
+import (
+	"aletheiaware.com/bcgo"
+	"aletheiaware.com/financego"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"log"
+	"sort"
+	"time"
+)
+
+// Period is a billing period from Start (inclusive) to End (exclusive), in nanoseconds like block timestamps.
+type Period struct {
+	Start uint64
+	End   uint64
+}
+
+// MonthlyPeriod returns the calendar month, in UTC, containing the given time.
+func MonthlyPeriod(t time.Time) Period {
+	t = t.UTC()
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return Period{
+		Start: uint64(start.UnixNano()),
+		End:   uint64(start.AddDate(0, 1, 0).UnixNano()),
+	}
+}
+
+// Next returns the calendar month following the period.
+func (p Period) Next() Period {
+	return MonthlyPeriod(time.Unix(0, int64(p.End)))
+}
+
+// Previous returns the calendar month preceding the period.
+func (p Period) Previous() Period {
+	return MonthlyPeriod(time.Unix(0, int64(p.Start)).AddDate(0, 0, -1))
+}
+
+// Contains returns true if the given timestamp, in nanoseconds, is within the period.
+func (p Period) Contains(timestamp uint64) bool {
+	return timestamp >= p.Start && timestamp < p.End
+}
+
+func (p Period) String() string {
+	return time.Unix(0, int64(p.Start)).UTC().Format("2006-01")
+}
+
+// Usage is the number of bytes an alias stores in each kind of channel at a point in time.
+// Sizes are of the blocks as mined, so they can be measured without decrypting, and re-derived by registrars and customers alike.
+type Usage struct {
+	Alias   string
+	End     uint64 // Blocks mined before this timestamp are counted
+	Meta    uint64
+	Rule    uint64
+	Delta   uint64
+	Preview uint64
+	Tag     uint64
+}
+
+// Total returns the number of bytes stored in all channels.
+func (u *Usage) Total() uint64 {
+	return u.Meta + u.Rule + u.Delta + u.Preview + u.Tag
+}
+
+// ChannelUsage returns the number of bytes of the blocks in the chain with the given head which were mined before the given timestamp, and the entries they hold.
+func ChannelUsage(channel string, head []byte, cache bcgo.Cache, network bcgo.Network, end uint64) (uint64, []*bcgo.BlockEntry, error) {
+	if head == nil {
+		return 0, nil, nil
+	}
+	var size uint64
+	var entries []*bcgo.BlockEntry
+	if err := bcgo.Iterate(channel, head, nil, cache, network, func(hash []byte, block *bcgo.Block) error {
+		if block.Timestamp >= end {
+			return nil
+		}
+		size += uint64(proto.Size(block))
+		entries = append(entries, block.Entry...)
+		return nil
+	}); err != nil {
+		return 0, nil, err
+	}
+	return size, entries, nil
+}
+
+// MeasureUsage returns the storage used by the given alias at the given timestamp, from the sizes of its Meta and Rule channels and of the Delta, Preview, and Tag channels of each file in its Meta channel.
+// Only block headers and record hashes are used, so a registrar holding the encrypted blocks measures the same figure as the customer.
+func MeasureUsage(node bcgo.Node, alias string, end uint64) (*Usage, error) {
+	usage := &Usage{
+		Alias: alias,
+		End:   end,
+	}
+	cache := node.Cache()
+	network := node.Network()
+	measure := func(name string, open func() bcgo.Channel) (uint64, []*bcgo.BlockEntry, error) {
+		channel := node.OpenChannel(name, open)
+		if err := channel.Refresh(cache, network); err != nil {
+			log.Println(err)
+		}
+		return ChannelUsage(name, channel.Head(), cache, network, end)
+	}
+	metas := MetaChannelName(alias)
+	var entries []*bcgo.BlockEntry
+	var err error
+	if usage.Meta, entries, err = measure(metas, func() bcgo.Channel {
+		return OpenMetaChannel(alias)
+	}); err != nil {
+		return nil, err
+	}
+	if usage.Rule, _, err = measure(RuleChannelName(alias), func() bcgo.Channel {
+		return OpenRuleChannel(alias)
+	}); err != nil {
+		return nil, err
+	}
+	// Each file is measured once, as every version of a file references its first, whose hash is the metaId of the file
+	var ids []string
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		id := MetaId(MetaOrigin(metas, e).RecordHash)
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		metaId := id
+		size, _, err := measure(DeltaChannelName(metaId), func() bcgo.Channel {
+			return OpenDeltaChannel(metaId)
+		})
+		if err != nil {
+			return nil, err
+		}
+		usage.Delta += size
+		if size, _, err = measure(PreviewChannelName(metaId), func() bcgo.Channel {
+			return OpenPreviewChannel(metaId)
+		}); err != nil {
+			return nil, err
+		}
+		usage.Preview += size
+		if size, _, err = measure(TagChannelName(metaId), func() bcgo.Channel {
+			return OpenTagChannel(metaId)
+		}); err != nil {
+			return nil, err
+		}
+		usage.Tag += size
+	}
+	return usage, nil
+}
+
+// UsageRecordId returns the identifier of the UsageRecord of the given subscription for the given period, so a record can be matched when usage is re-derived.
+// The identifier includes the subscription id so a customer with more than one subscription to a merchant has a record for each.
+func UsageRecordId(subscription *financego.Subscription, period Period) string {
+	return fmt.Sprintf("%s-%s-%s-%s", subscription.MerchantAlias, subscription.CustomerAlias, subscription.SubscriptionId, period)
+}
+
+// NewUsageRecord returns a UsageRecord of the given subscription reporting the total bytes of the given usage, stored at the end of the given period.
+func NewUsageRecord(subscription *financego.Subscription, usage *Usage, period Period) *financego.UsageRecord {
+	return &financego.UsageRecord{
+		MerchantAlias:      subscription.MerchantAlias,
+		CustomerAlias:      subscription.CustomerAlias,
+		Processor:          subscription.Processor,
+		UsageRecordId:      UsageRecordId(subscription, period),
+		SubscriptionId:     subscription.SubscriptionId,
+		SubscriptionItemId: subscription.SubscriptionItemId,
+		Timestamp:          time.Unix(0, int64(period.End)).Unix(),
+		Quantity:           int64(usage.Total()),
+	}
+}
+
+// AllUsageRecords triggers the given callback for each usage record the node can read, newest first.
+func AllUsageRecords(node bcgo.Node, callback financego.UsageRecordCallback) error {
+	records := node.OpenChannel(SPACE_USAGE_RECORD, func() bcgo.Channel {
+		return OpenUsageRecordChannel()
+	})
+	if err := records.Refresh(node.Cache(), node.Network()); err != nil {
+		log.Println(err)
+	}
+	return bcgo.Read(records.Name(), records.Head(), nil, node.Cache(), node.Network(), node.Account(), nil, func(entry *bcgo.BlockEntry, key, data []byte) error {
+		// Unmarshal as UsageRecord
+		r := &financego.UsageRecord{}
+		if err := proto.Unmarshal(data, r); err != nil {
+			return err
+		}
+		return callback(entry, r)
+	})
+}
+
+// MeterUsage measures the usage of each customer subscribed to the node's alias, as a registrar, at the end of the given period and writes a UsageRecord for each to the Usage Record channel, skipping subscriptions already recorded for the period.
+// Each record is readable by the node's account and the identities returned by the given function for the customer, if any, and the records written are returned.
+func MeterUsage(node bcgo.Node, listener bcgo.MiningListener, period Period, identities func(customer string) ([]bcgo.Identity, error)) ([]*financego.UsageRecord, error) {
+	merchant := node.Account().Alias()
+	var subscriptions []*financego.Subscription
+	if err := AllSubscriptions(node, func(entry *bcgo.BlockEntry, s *financego.Subscription) error {
+		if s.MerchantAlias == merchant {
+			subscriptions = append(subscriptions, s)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	recorded := make(map[string]bool)
+	if err := AllUsageRecords(node, func(entry *bcgo.BlockEntry, r *financego.UsageRecord) error {
+		recorded[r.UsageRecordId] = true
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	channel := node.OpenChannel(SPACE_USAGE_RECORD, func() bcgo.Channel {
+		return OpenUsageRecordChannel()
+	})
+	var written []*financego.UsageRecord
+	for _, s := range subscriptions {
+		id := UsageRecordId(s, period)
+		if recorded[id] {
+			continue
+		}
+		recorded[id] = true
+		usage, err := MeasureUsage(node, s.CustomerAlias, period.End)
+		if err != nil {
+			return written, err
+		}
+		record := NewUsageRecord(s, usage, period)
+		data, err := proto.Marshal(record)
+		if err != nil {
+			return written, err
+		}
+		access := []bcgo.Identity{node.Account()}
+		if identities != nil {
+			ids, err := identities(s.CustomerAlias)
+			if err != nil {
+				return written, err
+			}
+			access = append(access, ids...)
+		}
+		if _, err := node.Write(bcgo.Timestamp(), channel, access, nil, data); err != nil {
+			return written, err
+		}
+		written = append(written, record)
+	}
+	if len(written) > 0 {
+		if _, _, err := node.Mine(channel, Threshold(channel.Name()), listener); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
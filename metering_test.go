@@ -0,0 +1,196 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego_test
+
+import (
+	"aletheiaware.com/bcgo"
+	"aletheiaware.com/financego"
+	"aletheiaware.com/spacego"
+	"aletheiaware.com/testinggo"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestMonthlyPeriod(t *testing.T) {
+	p := spacego.MonthlyPeriod(time.Date(2021, time.December, 15, 12, 0, 0, 0, time.UTC))
+	assert.Equal(t, uint64(time.Date(2021, time.December, 1, 0, 0, 0, 0, time.UTC).UnixNano()), p.Start)
+	assert.Equal(t, uint64(time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC).UnixNano()), p.End)
+	assert.Equal(t, "2021-12", p.String())
+	assert.True(t, p.Contains(p.Start))
+	assert.False(t, p.Contains(p.End))
+	assert.Equal(t, "2022-01", p.Next().String())
+	assert.Equal(t, "2021-11", p.Previous().String())
+	assert.Equal(t, p, p.Next().Previous())
+}
+
+func TestNewUsageRecord(t *testing.T) {
+	period := spacego.MonthlyPeriod(time.Date(2021, time.March, 2, 0, 0, 0, 0, time.UTC))
+	subscription := &financego.Subscription{
+		MerchantAlias:      "nyc",
+		CustomerAlias:      "alice",
+		SubscriptionId:     "sub_1",
+		SubscriptionItemId: "si_1",
+	}
+	usage := &spacego.Usage{
+		Alias:   "alice",
+		Meta:    100,
+		Rule:    10,
+		Delta:   2000,
+		Preview: 300,
+		Tag:     40,
+	}
+	assert.Equal(t, uint64(2450), usage.Total())
+	assert.Equal(t, &financego.UsageRecord{
+		MerchantAlias:      "nyc",
+		CustomerAlias:      "alice",
+		UsageRecordId:      "nyc-alice-sub_1-2021-03",
+		SubscriptionId:     "sub_1",
+		SubscriptionItemId: "si_1",
+		Timestamp:          time.Date(2021, time.April, 1, 0, 0, 0, 0, time.UTC).Unix(),
+		Quantity:           2450,
+	}, spacego.NewUsageRecord(subscription, usage, period))
+}
+
+// blockSize returns the size of the head block of the channel with the given name.
+func blockSize(t *testing.T, node *fakeNode, name string) uint64 {
+	t.Helper()
+	block, err := node.cache.Block(node.OpenChannel(name, nil).Head())
+	testinggo.AssertNoError(t, err)
+	return uint64(proto.Size(block))
+}
+
+func TestChannelUsage(t *testing.T) {
+	node := newFakeNode("alice", newFakeCache())
+	first := writeRecord(t, node, "Test", nil, []byte("foo"))
+	firstSize := blockSize(t, node, "Test")
+	end := bcgo.Timestamp()
+	second := writeRecord(t, node, "Test", nil, []byte("barbaz"))
+	secondSize := blockSize(t, node, "Test")
+	head := node.OpenChannel("Test", nil).Head()
+
+	size, entries, err := spacego.ChannelUsage("Test", head, node.cache, nil, bcgo.Timestamp())
+	testinggo.AssertNoError(t, err)
+	assert.Equal(t, firstSize+secondSize, size)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, second.RecordHash, entries[0].RecordHash)
+	assert.Equal(t, first.RecordHash, entries[1].RecordHash)
+
+	// Blocks mined after the end are not counted
+	size, entries, err = spacego.ChannelUsage("Test", head, node.cache, nil, end)
+	testinggo.AssertNoError(t, err)
+	assert.Equal(t, firstSize, size)
+	assert.Equal(t, 1, len(entries))
+
+	size, entries, err = spacego.ChannelUsage("Test", nil, node.cache, nil, end)
+	testinggo.AssertNoError(t, err)
+	assert.Zero(t, size)
+	assert.Empty(t, entries)
+}
+
+func TestMeasureUsage(t *testing.T) {
+	node := newFakeNode("alice", newFakeCache())
+	metas := spacego.MetaChannelName("alice")
+	data, err := proto.Marshal(&spacego.Meta{Name: "notes.txt", Type: spacego.MIME_TYPE_TEXT_PLAIN})
+	testinggo.AssertNoError(t, err)
+	origin := writeRecord(t, node, metas, nil, data)
+	metaSize := blockSize(t, node, metas)
+	metaId := spacego.MetaId(origin.RecordHash)
+	appendText(t, node, metaId, 0, "Hello World")
+	deltaSize := blockSize(t, node, spacego.DeltaChannelName(metaId))
+	_, err = spacego.AddTag(node, nil, readHierarchy(t, node), metaId, "work", "")
+	testinggo.AssertNoError(t, err)
+	tagSize := blockSize(t, node, spacego.TagChannelName(metaId))
+	// Renaming adds a version referencing the first
+	data, err = proto.Marshal(&spacego.Meta{Name: "hello.txt", Type: spacego.MIME_TYPE_TEXT_PLAIN})
+	testinggo.AssertNoError(t, err)
+	version := writeRecord(t, node, metas, []*bcgo.Reference{origin}, data)
+	metaSize += blockSize(t, node, metas)
+
+	usage, err := spacego.MeasureUsage(node, "alice", bcgo.Timestamp())
+	testinggo.AssertNoError(t, err)
+	assert.Equal(t, &spacego.Usage{
+		Alias: "alice",
+		End:   usage.End,
+		Meta:  metaSize,
+		Delta: deltaSize,
+		Tag:   tagSize,
+	}, usage)
+	// Versions are measured as the file they belong to
+	versionId := spacego.MetaId(version.RecordHash)
+	for _, name := range []string{
+		spacego.DeltaChannelName(versionId),
+		spacego.PreviewChannelName(versionId),
+		spacego.TagChannelName(versionId),
+	} {
+		_, ok := node.channels[name]
+		assert.False(t, ok, name)
+	}
+}
+
+func TestMeterUsage(t *testing.T) {
+	cache := newFakeCache()
+	customer := newFakeNode("alice", cache)
+	merchant := newFakeNode("nyc", cache)
+	_, err := spacego.Onboard(customer, nil, testRegistrar("nyc", "space-nyc.example.com", "US", 20), "cus_alice", &financego.Subscription{SubscriptionId: "sub_1"}, func(string) ([]bcgo.Identity, error) {
+		return []bcgo.Identity{merchant.Account()}, nil
+	})
+	testinggo.AssertNoError(t, err)
+	metaId := writeTextFile(t, customer, "notes.txt")
+	appendText(t, customer, metaId, 0, "Hello World")
+
+	period := spacego.MonthlyPeriod(time.Now())
+	usage, err := spacego.MeasureUsage(customer, "alice", period.End)
+	testinggo.AssertNoError(t, err)
+	assert.NotZero(t, usage.Total())
+
+	identities := func(alias string) ([]bcgo.Identity, error) {
+		assert.Equal(t, "alice", alias)
+		return []bcgo.Identity{customer.Account()}, nil
+	}
+	records, err := spacego.MeterUsage(merchant, nil, period, identities)
+	testinggo.AssertNoError(t, err)
+	assert.Equal(t, 1, len(records))
+	assert.Equal(t, "nyc-alice-sub_1-"+period.String(), records[0].UsageRecordId)
+	assert.Equal(t, "sub_1", records[0].SubscriptionId)
+	// The registrar measures the same usage as the customer
+	assert.Equal(t, int64(usage.Total()), records[0].Quantity)
+
+	// Metering again does not duplicate the record
+	records, err = spacego.MeterUsage(merchant, nil, period, identities)
+	testinggo.AssertNoError(t, err)
+	assert.Empty(t, records)
+
+	// A second subscription is metered separately
+	_, err = spacego.Onboard(customer, nil, testRegistrar("nyc", "space-nyc.example.com", "US", 20), "cus_alice", &financego.Subscription{SubscriptionId: "sub_2"}, func(string) ([]bcgo.Identity, error) {
+		return []bcgo.Identity{merchant.Account()}, nil
+	})
+	testinggo.AssertNoError(t, err)
+	records, err = spacego.MeterUsage(merchant, nil, period, identities)
+	testinggo.AssertNoError(t, err)
+	assert.Equal(t, 1, len(records))
+	assert.Equal(t, "nyc-alice-sub_2-"+period.String(), records[0].UsageRecordId)
+
+	// The customer can read the records
+	var ids []string
+	testinggo.AssertNoError(t, spacego.AllUsageRecords(customer, func(entry *bcgo.BlockEntry, r *financego.UsageRecord) error {
+		ids = append(ids, r.UsageRecordId)
+		return nil
+	}))
+	assert.ElementsMatch(t, []string{"nyc-alice-sub_1-" + period.String(), "nyc-alice-sub_2-" + period.String()}, ids)
+}
@@ -0,0 +1,237 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const MIME_SNIFF_LENGTH = 512
+
+// MimeSniffer returns true if the given leading bytes of a file match a MIME type.
+type MimeSniffer func([]byte) bool
+
+type mimeSniffer struct {
+	mime    string
+	sniffer MimeSniffer
+}
+
+var mimeRegistry = struct {
+	sync.RWMutex
+	magics     []*mimeSniffer
+	heuristics []*mimeSniffer
+	extensions map[string]string
+	types      map[string]bool
+}{
+	extensions: make(map[string]string),
+	types:      make(map[string]bool),
+}
+
+func init() {
+	RegisterMimeMagic(MIME_TYPE_IMAGE_JPEG, MagicSniffer(0, []byte{0xFF, 0xD8, 0xFF}))
+	RegisterMimeMagic(MIME_TYPE_IMAGE_PNG, MagicSniffer(0, []byte("\x89PNG\r\n\x1a\n")))
+	RegisterMimeMagic(MIME_TYPE_IMAGE_GIF, MagicSniffer(0, []byte("GIF87a")), MagicSniffer(0, []byte("GIF89a")))
+	RegisterMimeMagic(MIME_TYPE_IMAGE_WEBP, func(data []byte) bool {
+		return MagicSniffer(0, []byte("RIFF"))(data) && MagicSniffer(8, []byte("WEBP"))(data)
+	})
+	RegisterMimeMagic(MIME_TYPE_PDF, MagicSniffer(0, []byte("%PDF-")))
+	RegisterMimeMagic(MIME_TYPE_AUDIO_MPEG, MagicSniffer(0, []byte("ID3")), sniffMPEGAudioFrame)
+	RegisterMimeMagic(MIME_TYPE_VIDEO_MPEG, MagicSniffer(0, []byte{0x00, 0x00, 0x01, 0xBA}), MagicSniffer(0, []byte{0x00, 0x00, 0x01, 0xB3}))
+	RegisterMimeHeuristic(MIME_TYPE_PROTOBUF, sniffProtobuf)
+	RegisterMimeHeuristic(MIME_TYPE_TEXT_PLAIN, sniffText)
+	RegisterMimeExtension(MIME_TYPE_IMAGE_JPEG, ".jpg", ".jpeg")
+	RegisterMimeExtension(MIME_TYPE_IMAGE_PNG, ".png")
+	RegisterMimeExtension(MIME_TYPE_IMAGE_GIF, ".gif")
+	RegisterMimeExtension(MIME_TYPE_IMAGE_WEBP, ".webp")
+	RegisterMimeExtension(MIME_TYPE_PDF, ".pdf")
+	RegisterMimeExtension(MIME_TYPE_AUDIO_MPEG, ".mp3")
+	RegisterMimeExtension(MIME_TYPE_VIDEO_MPEG, ".mpg", ".mpeg")
+	RegisterMimeExtension(MIME_TYPE_PROTOBUF, ".pb")
+	RegisterMimeExtension(MIME_TYPE_TEXT_PLAIN, ".txt")
+}
+
+// RegisterMimeMagic registers sniffers which identify the given MIME type by signature bytes, such as a file header.
+// Magic sniffers are tried before extensions, most recently registered first.
+func RegisterMimeMagic(mime string, sniffers ...MimeSniffer) {
+	mimeRegistry.Lock()
+	defer mimeRegistry.Unlock()
+	for _, s := range sniffers {
+		mimeRegistry.magics = append(mimeRegistry.magics, &mimeSniffer{mime, s})
+	}
+	mimeRegistry.types[mime] = true
+}
+
+// RegisterMimeHeuristic registers sniffers which guess the given MIME type from the content, such as text encoding.
+// Heuristic sniffers are tried after extensions, most recently registered first.
+func RegisterMimeHeuristic(mime string, sniffers ...MimeSniffer) {
+	mimeRegistry.Lock()
+	defer mimeRegistry.Unlock()
+	for _, s := range sniffers {
+		mimeRegistry.heuristics = append(mimeRegistry.heuristics, &mimeSniffer{mime, s})
+	}
+	mimeRegistry.types[mime] = true
+}
+
+// RegisterMimeExtension registers file name extensions, such as ".md", for the given MIME type.
+// Registering an extension again replaces the previous MIME type.
+func RegisterMimeExtension(mime string, extensions ...string) {
+	mimeRegistry.Lock()
+	defer mimeRegistry.Unlock()
+	for _, e := range extensions {
+		mimeRegistry.extensions[strings.ToLower(e)] = mime
+	}
+	mimeRegistry.types[mime] = true
+}
+
+// MagicSniffer returns a MimeSniffer which matches the given bytes at the given offset.
+func MagicSniffer(offset int, magic []byte) MimeSniffer {
+	return func(data []byte) bool {
+		return len(data) >= offset+len(magic) && bytes.Equal(data[offset:offset+len(magic)], magic)
+	}
+}
+
+// DetectMimeType returns the MIME type of the file with the given name and leading bytes, or MIME_TYPE_UNKNOWN.
+func DetectMimeType(name string, data []byte) string {
+	if len(data) > MIME_SNIFF_LENGTH {
+		data = data[:MIME_SNIFF_LENGTH]
+	}
+	mimeRegistry.RLock()
+	defer mimeRegistry.RUnlock()
+	if mime := sniff(mimeRegistry.magics, data); mime != "" {
+		return mime
+	}
+	if mime, ok := mimeRegistry.extensions[strings.ToLower(path.Ext(name))]; ok {
+		return mime
+	}
+	if mime := sniff(mimeRegistry.heuristics, data); mime != "" {
+		return mime
+	}
+	return MIME_TYPE_UNKNOWN
+}
+
+// DetectMimeTypeFromReader returns the MIME type of the file with the given name and content, and a reader which yields the entire content.
+func DetectMimeTypeFromReader(name string, reader io.Reader) (string, io.Reader, error) {
+	buffer := make([]byte, MIME_SNIFF_LENGTH)
+	count, err := io.ReadFull(reader, buffer)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", nil, err
+	}
+	buffer = buffer[:count]
+	return DetectMimeType(name, buffer), io.MultiReader(bytes.NewReader(buffer), reader), nil
+}
+
+func registeredMimeTypes() []string {
+	mimeRegistry.RLock()
+	defer mimeRegistry.RUnlock()
+	var mimes []string
+	for m := range mimeRegistry.types {
+		mimes = append(mimes, m)
+	}
+	return mimes
+}
+
+func sniff(sniffers []*mimeSniffer, data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	for i := len(sniffers) - 1; i >= 0; i-- {
+		if s := sniffers[i]; s.sniffer(data) {
+			return s.mime
+		}
+	}
+	return ""
+}
+
+func sniffMPEGAudioFrame(data []byte) bool {
+	// Frame sync (11 bits set), MPEG version not reserved, layer not reserved
+	return len(data) >= 2 && data[0] == 0xFF && data[1]&0xE0 == 0xE0 && data[1]&0x18 != 0x08 && data[1]&0x06 != 0x00
+}
+
+func sniffText(data []byte) bool {
+	// Allow a rune to be cut off at the end of the sniffed bytes
+	for i := 0; i < utf8.UTFMax && i < len(data); i++ {
+		if utf8.Valid(data[:len(data)-i]) {
+			data = data[:len(data)-i]
+			break
+		}
+	}
+	if !utf8.Valid(data) {
+		return false
+	}
+	for _, r := range string(data) {
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' && r != '\f' {
+			return false
+		}
+	}
+	return true
+}
+
+func sniffProtobuf(data []byte) bool {
+	// Check the data can be parsed as a sequence of wire format fields, allowing the last field to be cut off at the end of the sniffed bytes
+	truncated := len(data) == MIME_SNIFF_LENGTH
+	fields := 0
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return truncated && n == 0 && fields > 0
+		}
+		data = data[n:]
+		if key>>3 == 0 {
+			return false
+		}
+		switch key & 7 {
+		case 0: // Varint
+			_, n = binary.Uvarint(data)
+			if n < 0 || (n == 0 && !truncated) {
+				return false
+			}
+			if n == 0 {
+				return fields > 0
+			}
+		case 1: // 64-bit
+			n = 8
+		case 2: // Length-delimited
+			length, m := binary.Uvarint(data)
+			if m < 0 || (m == 0 && !truncated) {
+				return false
+			}
+			if m == 0 {
+				return fields > 0
+			}
+			if length > uint64(MAX_SIZE_BYTES) {
+				return false
+			}
+			n = m + int(length)
+		case 5: // 32-bit
+			n = 4
+		default:
+			return false
+		}
+		if n > len(data) {
+			return truncated && fields > 0
+		}
+		data = data[n:]
+		fields++
+	}
+	return fields > 0
+}
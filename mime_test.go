@@ -0,0 +1,131 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego_test
+
+import (
+	"aletheiaware.com/spacego"
+	"aletheiaware.com/testinggo"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestDetectMimeType(t *testing.T) {
+	protobuf, err := proto.Marshal(&spacego.Meta{
+		Name: "foo",
+		Type: spacego.MIME_TYPE_TEXT_PLAIN,
+	})
+	testinggo.AssertNoError(t, err)
+	for name, tt := range map[string]struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		"empty": {
+			expected: spacego.MIME_TYPE_UNKNOWN,
+		},
+		"jpeg": {
+			data:     []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x10},
+			expected: spacego.MIME_TYPE_IMAGE_JPEG,
+		},
+		"png": {
+			data:     []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"),
+			expected: spacego.MIME_TYPE_IMAGE_PNG,
+		},
+		"gif": {
+			data:     []byte("GIF89a\x01\x00\x01\x00"),
+			expected: spacego.MIME_TYPE_IMAGE_GIF,
+		},
+		"webp": {
+			data:     []byte("RIFF\x24\x00\x00\x00WEBPVP8 "),
+			expected: spacego.MIME_TYPE_IMAGE_WEBP,
+		},
+		"pdf": {
+			data:     []byte("%PDF-1.7\n"),
+			expected: spacego.MIME_TYPE_PDF,
+		},
+		"mp3_id3": {
+			data:     []byte("ID3\x04\x00\x00"),
+			expected: spacego.MIME_TYPE_AUDIO_MPEG,
+		},
+		"mp3_frame": {
+			data:     []byte{0xFF, 0xFB, 0x90, 0x64},
+			expected: spacego.MIME_TYPE_AUDIO_MPEG,
+		},
+		"mpeg": {
+			data:     []byte{0x00, 0x00, 0x01, 0xBA, 0x44},
+			expected: spacego.MIME_TYPE_VIDEO_MPEG,
+		},
+		"text": {
+			data:     []byte("Hello World\n"),
+			expected: spacego.MIME_TYPE_TEXT_PLAIN,
+		},
+		"text_utf8": {
+			data:     []byte("Grüße, 世界"),
+			expected: spacego.MIME_TYPE_TEXT_PLAIN,
+		},
+		"protobuf": {
+			data:     protobuf,
+			expected: spacego.MIME_TYPE_PROTOBUF,
+		},
+		"binary": {
+			data:     []byte{0x00, 0x01, 0x02, 0x03},
+			expected: spacego.MIME_TYPE_UNKNOWN,
+		},
+		"extension": {
+			name:     "photo.JPG",
+			expected: spacego.MIME_TYPE_IMAGE_JPEG,
+		},
+		"magic_before_extension": {
+			name:     "photo.jpg",
+			data:     []byte("\x89PNG\r\n\x1a\n"),
+			expected: spacego.MIME_TYPE_IMAGE_PNG,
+		},
+		"extension_before_heuristic": {
+			name:     "data.pb",
+			data:     []byte("foobar"),
+			expected: spacego.MIME_TYPE_PROTOBUF,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, spacego.DetectMimeType(tt.name, tt.data))
+		})
+	}
+}
+
+func TestRegisterMimeType(t *testing.T) {
+	const markdown = "text/markdown"
+	const zip = "application/zip"
+	spacego.RegisterMimeExtension(markdown, ".md")
+	spacego.RegisterMimeMagic(zip, spacego.MagicSniffer(0, []byte("PK\x03\x04")))
+	assert.Equal(t, markdown, spacego.DetectMimeType("README.md", []byte("# Title\n")))
+	assert.Equal(t, zip, spacego.DetectMimeType("archive", []byte("PK\x03\x04\x14\x00")))
+	assert.Contains(t, spacego.MimeTypes(), markdown)
+	assert.Contains(t, spacego.MimeTypes(), zip)
+}
+
+func TestDetectMimeTypeFromReader(t *testing.T) {
+	content := "GIF89a" + strings.Repeat("x", 1000)
+	mime, reader, err := spacego.DetectMimeTypeFromReader("", strings.NewReader(content))
+	testinggo.AssertNoError(t, err)
+	assert.Equal(t, spacego.MIME_TYPE_IMAGE_GIF, mime)
+	data, err := ioutil.ReadAll(reader)
+	testinggo.AssertNoError(t, err)
+	assert.Equal(t, content, string(data))
+}
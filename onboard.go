@@ -0,0 +1,261 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego
+
+import (
+	"aletheiaware.com/bcgo"
+	"aletheiaware.com/financego"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"sync"
+)
+
+type ErrInvalidRegistrar struct {
+	Alias string
+}
+
+func (e ErrInvalidRegistrar) Error() string {
+	return fmt.Sprintf("Invalid registrar: %s", e.Alias)
+}
+
+// Onboarding is the registration, and subscription if any, of a customer with a registrar.
+type Onboarding struct {
+	Registrar    *Registrar
+	Registration *financego.Registration
+	Subscription *financego.Subscription
+	// References to the records written, nil if an existing record was reused
+	RegistrationReference *bcgo.Reference
+	SubscriptionReference *bcgo.Reference
+}
+
+// onboarding serializes onboarding of each customer with each merchant, so concurrent calls cannot both find no registration and write one.
+// A lock is only kept while in use.
+var onboarding = struct {
+	sync.Mutex
+	locks map[string]*onboardingLock
+}{
+	locks: make(map[string]*onboardingLock),
+}
+
+type onboardingLock struct {
+	sync.Mutex
+	users int
+}
+
+// lockOnboarding locks the onboarding of the given customer with the given merchant, and returns a function which unlocks it.
+func lockOnboarding(customer, merchant string) func() {
+	key := customer + "/" + merchant
+	onboarding.Lock()
+	l, ok := onboarding.locks[key]
+	if !ok {
+		l = &onboardingLock{}
+		onboarding.locks[key] = l
+	}
+	l.users++
+	onboarding.Unlock()
+	l.Lock()
+	return func() {
+		l.Unlock()
+		onboarding.Lock()
+		l.users--
+		if l.users == 0 {
+			delete(onboarding.locks, key)
+		}
+		onboarding.Unlock()
+	}
+}
+
+// NewRegistration returns a Registration of the customer with the given alias and payment processor customer ID with the given registrar.
+func NewRegistration(customer string, registrar *Registrar, customerId string) *financego.Registration {
+	return &financego.Registration{
+		MerchantAlias: registrar.Merchant.Alias,
+		CustomerAlias: customer,
+		Processor:     registrar.Merchant.Processor,
+		CustomerId:    customerId,
+	}
+}
+
+// NewSubscription returns a copy of the given Subscription completed for the given registration, taking the product and plan from the registrar's service if not set.
+func NewSubscription(registration *financego.Registration, registrar *Registrar, subscription *financego.Subscription) *financego.Subscription {
+	s := proto.Clone(subscription).(*financego.Subscription)
+	s.MerchantAlias = registration.MerchantAlias
+	s.CustomerAlias = registration.CustomerAlias
+	s.Processor = registration.Processor
+	s.CustomerId = registration.CustomerId
+	if registrar.Service != nil {
+		if s.ProductId == "" {
+			s.ProductId = registrar.Service.ProductId
+		}
+		if s.PlanId == "" {
+			s.PlanId = registrar.Service.PlanId
+		}
+	}
+	return s
+}
+
+// Onboard registers the node's alias with the given registrar using the given payment processor customer ID, and subscribes it if a Subscription is given, writing each record to the Registration and Subscription channels and waiting for it to be mined.
+// Each record is readable by the node's account and the identities returned by the given function for the registrar's merchant, so the registrar can bill the customer.
+// Onboard is idempotent; an existing registration with the registrar is reused rather than duplicated, as is an existing subscription with the same subscription ID, so a retry after a failure only writes the records which are missing.
+// A record written to the node's cache but not yet mined, such as when mining failed, is mined rather than written again.
+func Onboard(node bcgo.Node, listener bcgo.MiningListener, registrar *Registrar, customerId string, subscription *financego.Subscription, identities func(merchant string) ([]bcgo.Identity, error)) (*Onboarding, error) {
+	if registrar == nil || registrar.Merchant == nil {
+		return nil, ErrInvalidRegistrar{}
+	}
+	merchant := registrar.Merchant.Alias
+	if merchant == "" || registrar.Merchant.Domain == "" {
+		return nil, ErrInvalidRegistrar{Alias: merchant}
+	}
+	access := []bcgo.Identity{node.Account()}
+	if identities != nil {
+		ids, err := identities(merchant)
+		if err != nil {
+			return nil, err
+		}
+		access = append(access, ids...)
+	}
+	unlock := lockOnboarding(node.Account().Alias(), merchant)
+	defer unlock()
+
+	result := &Onboarding{
+		Registrar: registrar,
+	}
+	if err := AllRegistrationsForNode(node, func(entry *bcgo.BlockEntry, r *financego.Registration) error {
+		if r.MerchantAlias == merchant {
+			result.Registration = r
+			return bcgo.ErrStopIteration{}
+		}
+		return nil
+	}); err != nil {
+		switch err.(type) {
+		case bcgo.ErrStopIteration:
+			// Do nothing
+			break
+		default:
+			return nil, err
+		}
+	}
+	if result.Registration == nil {
+		registrations := node.OpenChannel(SPACE_REGISTRATION, func() bcgo.Channel {
+			return OpenRegistrationChannel()
+		})
+		r := &financego.Registration{}
+		reference, err := minePending(node, listener, registrations, func(data []byte) (bool, error) {
+			if err := proto.Unmarshal(data, r); err != nil {
+				return false, err
+			}
+			return r.MerchantAlias == merchant, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if reference == nil {
+			r = NewRegistration(node.Account().Alias(), registrar, customerId)
+			if reference, err = writeAccess(node, listener, registrations, access, nil, r); err != nil {
+				return nil, err
+			}
+		}
+		result.Registration = r
+		result.RegistrationReference = reference
+	}
+
+	if subscription == nil {
+		return result, nil
+	}
+	if err := AllSubscriptionsForNode(node, func(entry *bcgo.BlockEntry, s *financego.Subscription) error {
+		if s.MerchantAlias == merchant && s.SubscriptionId == subscription.SubscriptionId {
+			result.Subscription = s
+			return bcgo.ErrStopIteration{}
+		}
+		return nil
+	}); err != nil {
+		switch err.(type) {
+		case bcgo.ErrStopIteration:
+			// Do nothing
+			break
+		default:
+			return nil, err
+		}
+	}
+	if result.Subscription == nil {
+		subscriptions := node.OpenChannel(SPACE_SUBSCRIPTION, func() bcgo.Channel {
+			return OpenSubscriptionChannel()
+		})
+		s := &financego.Subscription{}
+		reference, err := minePending(node, listener, subscriptions, func(data []byte) (bool, error) {
+			if err := proto.Unmarshal(data, s); err != nil {
+				return false, err
+			}
+			return s.MerchantAlias == merchant && s.SubscriptionId == subscription.SubscriptionId, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if reference == nil {
+			s = NewSubscription(result.Registration, registrar, subscription)
+			if reference, err = writeAccess(node, listener, subscriptions, access, nil, s); err != nil {
+				return nil, err
+			}
+		}
+		result.Subscription = s
+		result.SubscriptionReference = reference
+	}
+	return result, nil
+}
+
+// minePending looks for a record written by the node's account to the given channel but not yet mined whose payload is accepted by the given function, and mines the channel if one is found.
+// A reference to the record is returned, or nil if there is none.
+func minePending(node bcgo.Node, listener bcgo.MiningListener, channel bcgo.Channel, accept func([]byte) (bool, error)) (*bcgo.Reference, error) {
+	entries, err := node.Cache().Entries(channel.Name())
+	if err != nil {
+		return nil, err
+	}
+	alias := node.Account().Alias()
+	var reference *bcgo.Reference
+	if err := readEntries(node.Account(), &bcgo.Block{Entry: entries}, func(entry *bcgo.BlockEntry, data []byte) error {
+		if entry.Record.Creator != alias {
+			return nil
+		}
+		ok, err := accept(data)
+		if err != nil {
+			return err
+		}
+		if ok {
+			reference = &bcgo.Reference{
+				Timestamp:   entry.Record.Timestamp,
+				ChannelName: channel.Name(),
+				RecordHash:  entry.RecordHash,
+			}
+			return bcgo.ErrStopIteration{}
+		}
+		return nil
+	}); err != nil {
+		switch err.(type) {
+		case bcgo.ErrStopIteration:
+			// Do nothing
+			break
+		default:
+			return nil, err
+		}
+	}
+	if reference == nil {
+		return nil, nil
+	}
+	if _, _, err := node.Mine(channel, Threshold(channel.Name()), listener); err != nil {
+		return nil, err
+	}
+	return reference, nil
+}
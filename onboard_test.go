@@ -0,0 +1,174 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego_test
+
+import (
+	"aletheiaware.com/bcgo"
+	"aletheiaware.com/financego"
+	"aletheiaware.com/spacego"
+	"aletheiaware.com/testinggo"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNewSubscription(t *testing.T) {
+	registrar := testRegistrar("nyc", "space-nyc.example.com", "US", 20)
+	registrar.Service.ProductId = "prod_space"
+	registrar.Service.PlanId = "plan_storage"
+	registration := spacego.NewRegistration("alice", registrar, "cus_alice")
+	assert.Equal(t, &financego.Registration{
+		MerchantAlias: "nyc",
+		CustomerAlias: "alice",
+		CustomerId:    "cus_alice",
+	}, registration)
+
+	for name, tt := range map[string]struct {
+		subscription *financego.Subscription
+		expected     *financego.Subscription
+	}{
+		"service": {
+			subscription: &financego.Subscription{SubscriptionId: "sub_1", SubscriptionItemId: "si_1"},
+			expected: &financego.Subscription{
+				MerchantAlias:      "nyc",
+				CustomerAlias:      "alice",
+				CustomerId:         "cus_alice",
+				SubscriptionId:     "sub_1",
+				SubscriptionItemId: "si_1",
+				ProductId:          "prod_space",
+				PlanId:             "plan_storage",
+			},
+		},
+		"plan": {
+			subscription: &financego.Subscription{SubscriptionId: "sub_1", PlanId: "plan_archive", CustomerAlias: "mallory"},
+			expected: &financego.Subscription{
+				MerchantAlias:  "nyc",
+				CustomerAlias:  "alice",
+				CustomerId:     "cus_alice",
+				SubscriptionId: "sub_1",
+				ProductId:      "prod_space",
+				PlanId:         "plan_archive",
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := spacego.NewSubscription(registration, registrar, tt.subscription)
+			assert.Equal(t, tt.expected, s)
+			assert.NotSame(t, tt.subscription, s)
+		})
+	}
+}
+
+func TestOnboard(t *testing.T) {
+	cache := newFakeCache()
+	customer := newFakeNode("alice", cache)
+	merchant := newFakeNode("nyc", cache)
+	registrar := testRegistrar("nyc", "space-nyc.example.com", "US", 20)
+	identities := func(alias string) ([]bcgo.Identity, error) {
+		assert.Equal(t, "nyc", alias)
+		return []bcgo.Identity{merchant.Account()}, nil
+	}
+	subscription := &financego.Subscription{SubscriptionId: "sub_1"}
+
+	// Registration only, as if subscribing failed
+	onboarding, err := spacego.Onboard(customer, nil, registrar, "cus_alice", nil, identities)
+	testinggo.AssertNoError(t, err)
+	assert.NotNil(t, onboarding.RegistrationReference)
+	assert.Nil(t, onboarding.Subscription)
+
+	// Retrying reuses the registration and writes the subscription
+	onboarding, err = spacego.Onboard(customer, nil, registrar, "cus_alice", subscription, identities)
+	testinggo.AssertNoError(t, err)
+	assert.Nil(t, onboarding.RegistrationReference)
+	assert.NotNil(t, onboarding.SubscriptionReference)
+	assert.Equal(t, "cus_alice", onboarding.Registration.CustomerId)
+	assert.Equal(t, "sub_1", onboarding.Subscription.SubscriptionId)
+
+	// Retrying again writes nothing
+	onboarding, err = spacego.Onboard(customer, nil, registrar, "cus_alice", subscription, identities)
+	testinggo.AssertNoError(t, err)
+	assert.Nil(t, onboarding.RegistrationReference)
+	assert.Nil(t, onboarding.SubscriptionReference)
+
+	// The merchant can read each record once
+	count := func(name string) int {
+		var records int
+		testinggo.AssertNoError(t, bcgo.Read(name, merchant.OpenChannel(name, nil).Head(), nil, cache, nil, merchant.Account(), nil, func(*bcgo.BlockEntry, []byte, []byte) error {
+			records++
+			return nil
+		}))
+		return records
+	}
+	assert.Equal(t, 1, count(spacego.SPACE_REGISTRATION))
+	assert.Equal(t, 1, count(spacego.SPACE_SUBSCRIPTION))
+	testinggo.AssertNoError(t, spacego.AllSubscriptions(merchant, func(entry *bcgo.BlockEntry, s *financego.Subscription) error {
+		assert.True(t, proto.Equal(onboarding.Subscription, s))
+		return nil
+	}))
+}
+
+func TestOnboard_InvalidRegistrar(t *testing.T) {
+	node := newFakeNode("alice", newFakeCache())
+	for name, tt := range map[string]struct {
+		registrar *spacego.Registrar
+		err       error
+	}{
+		"nil": {
+			err: spacego.ErrInvalidRegistrar{},
+		},
+		"no_merchant": {
+			registrar: &spacego.Registrar{},
+			err:       spacego.ErrInvalidRegistrar{},
+		},
+		"no_alias": {
+			registrar: testRegistrar("", "space-nyc.example.com", "US", 20),
+			err:       spacego.ErrInvalidRegistrar{},
+		},
+		"no_domain": {
+			registrar: testRegistrar("nyc", "", "US", 20),
+			err:       spacego.ErrInvalidRegistrar{Alias: "nyc"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := spacego.Onboard(node, nil, tt.registrar, "cus_alice", nil, nil)
+			assert.Equal(t, tt.err, err)
+		})
+	}
+}
+
+func TestOnboard_Pending(t *testing.T) {
+	cache := newFakeCache()
+	customer := newFakeNode("alice", cache)
+	registrar := testRegistrar("nyc", "space-nyc.example.com", "US", 20)
+	// Written but not mined, as if mining failed
+	data, err := proto.Marshal(spacego.NewRegistration("alice", registrar, "cus_alice"))
+	testinggo.AssertNoError(t, err)
+	pending, err := customer.Write(bcgo.Timestamp(), customer.OpenChannel(spacego.SPACE_REGISTRATION, nil), []bcgo.Identity{customer.Account()}, nil, data)
+	testinggo.AssertNoError(t, err)
+
+	// Retrying mines the pending registration rather than writing another
+	onboarding, err := spacego.Onboard(customer, nil, registrar, "cus_alice", nil, nil)
+	testinggo.AssertNoError(t, err)
+	assert.Equal(t, pending.RecordHash, onboarding.RegistrationReference.RecordHash)
+	assert.Equal(t, "cus_alice", onboarding.Registration.CustomerId)
+	count := 0
+	testinggo.AssertNoError(t, spacego.AllRegistrationsForNode(customer, func(entry *bcgo.BlockEntry, r *financego.Registration) error {
+		count++
+		return nil
+	}))
+	assert.Equal(t, 1, count)
+}
@@ -0,0 +1,131 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego
+
+import (
+	"image"
+	"math"
+)
+
+const (
+	MIME_TYPE_PLACEHOLDER = "image/x-blurhash"
+
+	PREVIEW_PLACEHOLDER_X = 4 // Horizontal components
+	PREVIEW_PLACEHOLDER_Y = 3 // Vertical components
+	// Images are reduced to this size before the components are computed
+	PREVIEW_PLACEHOLDER_SAMPLE = 32
+)
+
+const base83 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// placeholderPreview creates a BlurHash of the given image, which clients can decode into a blurred placeholder while a thumbnail loads.
+// The width and height are those of the PREVIEW_IMAGE_SIZE rendition, so the placeholder has the same aspect ratio.
+func placeholderPreview(src image.Image) *Preview {
+	width, height := PreviewSize(src.Bounds().Dx(), src.Bounds().Dy(), PREVIEW_IMAGE_SIZE)
+	return &Preview{
+		Type:   MIME_TYPE_PLACEHOLDER,
+		Data:   []byte(blurhash(scaleImage(src, PREVIEW_PLACEHOLDER_SAMPLE), PREVIEW_PLACEHOLDER_X, PREVIEW_PLACEHOLDER_Y)),
+		Width:  uint32(width),
+		Height: uint32(height),
+	}
+}
+
+// blurhash encodes the given image as the given number of cosine components, as described at https://blurha.sh
+func blurhash(img *image.RGBA, xComponents, yComponents int) string {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+			var f [3]float64
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					basis := normalisation * math.Cos(math.Pi*float64(i*x)/float64(width)) * math.Cos(math.Pi*float64(j*y)/float64(height))
+					offset := img.PixOffset(x+img.Rect.Min.X, y+img.Rect.Min.Y)
+					for c := 0; c < 3; c++ {
+						f[c] += basis * srgbToLinear(img.Pix[offset+c])
+					}
+				}
+			}
+			scale := 1 / float64(width*height)
+			for c := 0; c < 3; c++ {
+				f[c] *= scale
+			}
+			factors = append(factors, f)
+		}
+	}
+
+	var hash []byte
+	hash = appendBase83(hash, (xComponents-1)+(yComponents-1)*9, 1)
+
+	maximum := 1.0
+	if len(factors) > 1 {
+		var actual float64
+		for _, f := range factors[1:] {
+			for _, v := range f {
+				actual = math.Max(actual, math.Abs(v))
+			}
+		}
+		quantised := int(math.Max(0, math.Min(82, math.Floor(actual*166-0.5))))
+		maximum = float64(quantised+1) / 166
+		hash = appendBase83(hash, quantised, 1)
+	} else {
+		hash = appendBase83(hash, 0, 1)
+	}
+
+	dc := factors[0]
+	hash = appendBase83(hash, linearToSRGB(dc[0])<<16|linearToSRGB(dc[1])<<8|linearToSRGB(dc[2]), 4)
+
+	for _, f := range factors[1:] {
+		var q [3]int
+		for c, v := range f {
+			q[c] = int(math.Max(0, math.Min(18, math.Floor(signPow(v/maximum, 0.5)*9+9.5))))
+		}
+		hash = appendBase83(hash, q[0]*19*19+q[1]*19+q[2], 2)
+	}
+	return string(hash)
+}
+
+func appendBase83(hash []byte, value, length int) []byte {
+	for i := length - 1; i >= 0; i-- {
+		hash = append(hash, base83[value/int(math.Pow(83, float64(i)))%83])
+	}
+	return hash
+}
+
+func srgbToLinear(value uint8) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(value float64) int {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(value, exponent float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exponent), value)
+}
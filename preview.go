@@ -0,0 +1,390 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego
+
+import (
+	"aletheiaware.com/bcgo"
+	"bytes"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	PREVIEW_IMAGE_QUALITY = 80
+	PREVIEW_IMAGE_SMALL   = 64
+	PREVIEW_IMAGE_LARGE   = 512
+	// Images with more pixels are not decoded, as they would use too much memory
+	PREVIEW_IMAGE_MAX_PIXELS = 64 * 1024 * 1024
+)
+
+// PreviewGenerator creates a Preview of a file with the given Meta from its content.
+type PreviewGenerator func(*Meta, io.Reader) (*Preview, error)
+
+// PreviewsGenerator creates several renditions of a Preview of a file with the given Meta from its content.
+type PreviewsGenerator func(*Meta, io.Reader) ([]*Preview, error)
+
+type ErrNoPreviewGenerator struct {
+	Type string
+}
+
+func (e ErrNoPreviewGenerator) Error() string {
+	return fmt.Sprintf("No preview generator for type: %s", e.Type)
+}
+
+type ErrNoPreview struct {
+	MetaId string
+}
+
+func (e ErrNoPreview) Error() string {
+	return fmt.Sprintf("No preview: %s", e.MetaId)
+}
+
+type ErrImageTooLarge struct {
+	Width, Height int
+}
+
+func (e ErrImageTooLarge) Error() string {
+	return fmt.Sprintf("Image too large: %dx%d exceeds %d pixels", e.Width, e.Height, PREVIEW_IMAGE_MAX_PIXELS)
+}
+
+type previewGenerator struct {
+	pattern    string
+	generator  PreviewGenerator
+	renditions PreviewsGenerator
+}
+
+var previewRegistry = struct {
+	sync.RWMutex
+	generators []*previewGenerator
+	renditions []*previewGenerator
+}{}
+
+func init() {
+	RegisterPreviewGenerator(MIME_TYPE_IMAGE_JPEG, GenerateImagePreview)
+	RegisterPreviewGenerator(MIME_TYPE_IMAGE_JPG, GenerateImagePreview)
+	RegisterPreviewGenerator(MIME_TYPE_IMAGE_GIF, GenerateImagePreview)
+	RegisterPreviewGenerator(MIME_TYPE_IMAGE_PNG, GenerateImagePreview)
+	RegisterPreviewGenerator(MIME_TYPE_IMAGE_WEBP, GenerateImagePreview)
+	RegisterPreviewGenerator("text/*", GenerateTextPreview)
+	RegisterPreviewGenerator(MIME_TYPE_AUDIO_MPEG, GenerateAudioPreview)
+	RegisterPreviewsGenerator(MIME_TYPE_IMAGE_JPEG, GenerateImagePreviews)
+	RegisterPreviewsGenerator(MIME_TYPE_IMAGE_JPG, GenerateImagePreviews)
+	RegisterPreviewsGenerator(MIME_TYPE_IMAGE_GIF, GenerateImagePreviews)
+	RegisterPreviewsGenerator(MIME_TYPE_IMAGE_PNG, GenerateImagePreviews)
+	RegisterPreviewsGenerator(MIME_TYPE_IMAGE_WEBP, GenerateImagePreviews)
+}
+
+// PreviewImageSizes returns the sizes of the image renditions created by GenerateImagePreviews, smallest first.
+func PreviewImageSizes() []int {
+	return []int{
+		PREVIEW_IMAGE_SMALL,
+		PREVIEW_IMAGE_SIZE,
+		PREVIEW_IMAGE_LARGE,
+	}
+}
+
+// RegisterPreviewGenerator registers a generator for files whose MIME type matches the given pattern, such as "text/*".
+// Generators for an exact MIME type are preferred over wildcard patterns, and otherwise the most recently registered match is used.
+func RegisterPreviewGenerator(pattern string, generator PreviewGenerator) {
+	previewRegistry.Lock()
+	defer previewRegistry.Unlock()
+	previewRegistry.generators = append(previewRegistry.generators, &previewGenerator{
+		pattern:   pattern,
+		generator: generator,
+	})
+}
+
+// RegisterPreviewsGenerator registers a generator of several renditions for files whose MIME type matches the given pattern.
+// Patterns are matched as in RegisterPreviewGenerator.
+func RegisterPreviewsGenerator(pattern string, generator PreviewsGenerator) {
+	previewRegistry.Lock()
+	defer previewRegistry.Unlock()
+	previewRegistry.renditions = append(previewRegistry.renditions, &previewGenerator{
+		pattern:    pattern,
+		renditions: generator,
+	})
+}
+
+// GeneratePreview creates a Preview of the file with the given Meta from its content using the registered generator for its MIME type.
+func GeneratePreview(meta *Meta, content io.Reader) (*Preview, error) {
+	previewRegistry.RLock()
+	g := lookupPreviewGenerator(previewRegistry.generators, meta.Type)
+	previewRegistry.RUnlock()
+	if g == nil {
+		return nil, ErrNoPreviewGenerator{Type: meta.Type}
+	}
+	return g.generator(meta, content)
+}
+
+// GeneratePreviews creates the renditions of a Preview of the file with the given Meta from its content.
+// If no generator of renditions is registered for its MIME type, the single Preview from GeneratePreview is returned.
+func GeneratePreviews(meta *Meta, content io.Reader) ([]*Preview, error) {
+	previewRegistry.RLock()
+	g := lookupPreviewGenerator(previewRegistry.renditions, meta.Type)
+	previewRegistry.RUnlock()
+	if g == nil {
+		preview, err := GeneratePreview(meta, content)
+		if err != nil {
+			return nil, err
+		}
+		return []*Preview{preview}, nil
+	}
+	return g.renditions(meta, content)
+}
+
+// WritePreviews writes the given previews to the Preview channel of the file with the given metaId.
+// The file must not be purged in the given Hierarchy.
+func WritePreviews(node bcgo.Node, listener bcgo.MiningListener, h *Hierarchy, metaId string, previews []*Preview) ([]*bcgo.Reference, error) {
+	access, err := h.Access(node.Account(), metaId)
+	if err != nil {
+		return nil, err
+	}
+	return writePreviews(node, listener, metaId, access, nil, previews)
+}
+
+func writePreviews(node bcgo.Node, listener bcgo.MiningListener, metaId string, access []bcgo.Identity, references []*bcgo.Reference, previews []*Preview) ([]*bcgo.Reference, error) {
+	channel := node.OpenChannel(PreviewChannelName(metaId), func() bcgo.Channel {
+		return OpenPreviewChannel(metaId)
+	})
+	var written []*bcgo.Reference
+	for _, p := range previews {
+		data, err := proto.Marshal(p)
+		if err != nil {
+			return nil, err
+		}
+		reference, err := node.Write(bcgo.Timestamp(), channel, access, references, data)
+		if err != nil {
+			return nil, err
+		}
+		written = append(written, reference)
+	}
+	if _, _, err := node.Mine(channel, Threshold(channel.Name()), listener); err != nil {
+		return nil, err
+	}
+	return written, nil
+}
+
+// BestPreview returns the preview of the file with the given metaId which best fits the given width and height, as chosen by SelectPreview.
+// Previews are considered newest first, so a newer rendition replaces an older one of the same size.
+// If any preview references a version of the file, only those referencing the PreviewVersion are considered, so previews of old content are ignored.
+func BestPreview(node bcgo.Node, metaId string, width, height uint32) (*Preview, error) {
+	channel := node.OpenChannel(PreviewChannelName(metaId), func() bcgo.Channel {
+		return OpenPreviewChannel(metaId)
+	})
+	if err := channel.Refresh(node.Cache(), node.Network()); err != nil {
+		log.Println(err)
+	}
+	var (
+		previews []*Preview
+		version  *bcgo.Reference
+	)
+	if err := ReadPreview(channel, node.Cache(), node.Network(), node.Account(), nil, func(entry *bcgo.BlockEntry, preview *Preview) error {
+		v := previewVersion(entry, metaId)
+		if version == nil && v != nil {
+			version = v
+			previews = nil
+		}
+		if version == nil || (v != nil && bytes.Equal(v.BlockHash, version.BlockHash)) {
+			previews = append(previews, preview)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	best := SelectPreview(previews, width, height)
+	if best == nil {
+		return nil, ErrNoPreview{MetaId: metaId}
+	}
+	return best, nil
+}
+
+// SelectPreview returns the smallest of the given previews which covers the given width and height, or the largest if none do.
+// Placeholders are only selected if there is no other preview, and earlier previews are preferred over later ones of the same size.
+func SelectPreview(previews []*Preview, width, height uint32) *Preview {
+	var best *Preview
+	for _, p := range previews {
+		if best == nil || betterPreview(p, best, width, height) {
+			best = p
+		}
+	}
+	return best
+}
+
+func betterPreview(a, b *Preview, width, height uint32) bool {
+	if pa, pb := a.Type == MIME_TYPE_PLACEHOLDER, b.Type == MIME_TYPE_PLACEHOLDER; pa != pb {
+		return pb
+	}
+	ca := a.Width >= width && a.Height >= height
+	cb := b.Width >= width && b.Height >= height
+	if ca != cb {
+		return ca
+	}
+	if ca {
+		// Both cover, prefer smaller
+		return previewArea(a) < previewArea(b)
+	}
+	// Neither covers, prefer larger
+	return previewArea(a) > previewArea(b)
+}
+
+func previewArea(p *Preview) uint64 {
+	return uint64(p.Width) * uint64(p.Height)
+}
+
+// GenerateImagePreview decodes the given image content and creates a JPEG thumbnail which fits within PREVIEW_IMAGE_SIZE, preserving the aspect ratio.
+// Transparent areas are filled with white.
+func GenerateImagePreview(meta *Meta, content io.Reader) (*Preview, error) {
+	src, err := decodeImage(content)
+	if err != nil {
+		return nil, err
+	}
+	return imagePreview(scaleImage(src, PREVIEW_IMAGE_SIZE))
+}
+
+// GenerateImagePreviews decodes the given image content and creates a JPEG thumbnail for each of PreviewImageSizes, and a placeholder.
+// Sizes larger than the image produce a single rendition at its original size.
+func GenerateImagePreviews(meta *Meta, content io.Reader) ([]*Preview, error) {
+	src, err := decodeImage(content)
+	if err != nil {
+		return nil, err
+	}
+	var previews []*Preview
+	seen := make(map[image.Point]bool)
+	for _, size := range PreviewImageSizes() {
+		dst := scaleImage(src, size)
+		if seen[dst.Bounds().Size()] {
+			continue
+		}
+		seen[dst.Bounds().Size()] = true
+		preview, err := imagePreview(dst)
+		if err != nil {
+			return nil, err
+		}
+		previews = append(previews, preview)
+	}
+	previews = append(previews, placeholderPreview(src))
+	return previews, nil
+}
+
+// decodeImage decodes the given image content, returning ErrImageTooLarge without decoding the pixels if the image has more than PREVIEW_IMAGE_MAX_PIXELS.
+func decodeImage(content io.Reader) (image.Image, error) {
+	// Keep the header read by DecodeConfig so it can be read again by Decode
+	var header bytes.Buffer
+	config, _, err := image.DecodeConfig(io.TeeReader(content, &header))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(config.Width)*uint64(config.Height) > PREVIEW_IMAGE_MAX_PIXELS {
+		return nil, ErrImageTooLarge{
+			Width:  config.Width,
+			Height: config.Height,
+		}
+	}
+	src, _, err := image.Decode(io.MultiReader(&header, content))
+	if err != nil {
+		return nil, err
+	}
+	return src, nil
+}
+
+// GenerateTextPreview creates a plain text excerpt of at most PREVIEW_TEXT_LENGTH bytes, without splitting a multi-byte character.
+func GenerateTextPreview(meta *Meta, content io.Reader) (*Preview, error) {
+	buffer := make([]byte, PREVIEW_TEXT_LENGTH)
+	count, err := io.ReadFull(content, buffer)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return &Preview{
+		Type: MIME_TYPE_TEXT_PLAIN,
+		Data: truncateUTF8(buffer[:count]),
+	}, nil
+}
+
+// PreviewSize returns the dimensions of an image with the given width and height scaled down to fit within a square of the given size, preserving the aspect ratio.
+// Images which already fit are not scaled up.
+func PreviewSize(width, height, size int) (int, int) {
+	if width <= size && height <= size {
+		return width, height
+	}
+	if width >= height {
+		return size, max1(height * size / width)
+	}
+	return max1(width * size / height), size
+}
+
+func scaleImage(src image.Image, size int) *image.RGBA {
+	width, height := PreviewSize(src.Bounds().Dx(), src.Bounds().Dy(), size)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Over, nil)
+	return dst
+}
+
+func imagePreview(img image.Image) (*Preview, error) {
+	var buffer bytes.Buffer
+	if err := jpeg.Encode(&buffer, img, &jpeg.Options{Quality: PREVIEW_IMAGE_QUALITY}); err != nil {
+		return nil, err
+	}
+	return &Preview{
+		Type:   MIME_TYPE_IMAGE_DEFAULT,
+		Data:   buffer.Bytes(),
+		Width:  uint32(img.Bounds().Dx()),
+		Height: uint32(img.Bounds().Dy()),
+	}, nil
+}
+
+func lookupPreviewGenerator(generators []*previewGenerator, mime string) *previewGenerator {
+	var wildcard *previewGenerator
+	for i := len(generators) - 1; i >= 0; i-- {
+		g := generators[i]
+		if g.pattern == mime {
+			return g
+		}
+		if wildcard == nil && MatchMimeType(g.pattern, mime) {
+			wildcard = g
+		}
+	}
+	return wildcard
+}
+
+// truncateUTF8 drops any partial rune cut off at the end of the given data.
+func truncateUTF8(data []byte) []byte {
+	for i := 0; i < utf8.UTFMax && i < len(data); i++ {
+		if r, size := utf8.DecodeLastRune(data[:len(data)-i]); r != utf8.RuneError || size > 1 {
+			return data[:len(data)-i]
+		}
+	}
+	return data
+}
+
+func max1(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
@@ -0,0 +1,356 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego_test
+
+import (
+	"aletheiaware.com/bcgo"
+	"aletheiaware.com/spacego"
+	"aletheiaware.com/testinggo"
+	"bytes"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func testImage(width, height int) image.Image {
+	img := image.NewPaletted(image.Rect(0, 0, width, height), color.Palette{color.Black, color.White})
+	for x := 0; x < width; x += 2 {
+		for y := 0; y < height; y++ {
+			img.SetColorIndex(x, y, 1)
+		}
+	}
+	return img
+}
+
+func TestGeneratePreview_Image(t *testing.T) {
+	for name, tt := range map[string]struct {
+		mime           string
+		encode         func(io.Writer, image.Image) error
+		width, height  int
+		expectedWidth  uint32
+		expectedHeight uint32
+	}{
+		"jpeg_landscape": {
+			mime: spacego.MIME_TYPE_IMAGE_JPEG,
+			encode: func(w io.Writer, i image.Image) error {
+				return jpeg.Encode(w, i, nil)
+			},
+			width:          512,
+			height:         256,
+			expectedWidth:  128,
+			expectedHeight: 64,
+		},
+		"png_portrait": {
+			mime:           spacego.MIME_TYPE_IMAGE_PNG,
+			encode:         png.Encode,
+			width:          100,
+			height:         400,
+			expectedWidth:  32,
+			expectedHeight: 128,
+		},
+		"gif_small": {
+			mime: spacego.MIME_TYPE_IMAGE_GIF,
+			encode: func(w io.Writer, i image.Image) error {
+				return gif.Encode(w, i, nil)
+			},
+			width:          20,
+			height:         10,
+			expectedWidth:  20,
+			expectedHeight: 10,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var buffer bytes.Buffer
+			testinggo.AssertNoError(t, tt.encode(&buffer, testImage(tt.width, tt.height)))
+			preview, err := spacego.GeneratePreview(&spacego.Meta{
+				Name: name,
+				Type: tt.mime,
+			}, &buffer)
+			testinggo.AssertNoError(t, err)
+			assert.Equal(t, spacego.MIME_TYPE_IMAGE_DEFAULT, preview.Type)
+			assert.Equal(t, tt.expectedWidth, preview.Width)
+			assert.Equal(t, tt.expectedHeight, preview.Height)
+			config, err := jpeg.DecodeConfig(bytes.NewReader(preview.Data))
+			testinggo.AssertNoError(t, err)
+			assert.Equal(t, int(tt.expectedWidth), config.Width)
+			assert.Equal(t, int(tt.expectedHeight), config.Height)
+		})
+	}
+}
+
+func TestGeneratePreview_ImageTooLarge(t *testing.T) {
+	// A GIF header claiming a 65535x65535 image, without any pixels
+	content := append([]byte("GIF89a"), 0xff, 0xff, 0xff, 0xff, 0, 0, 0)
+	for name, generate := range map[string]func() error{
+		"preview": func() error {
+			_, err := spacego.GenerateImagePreview(&spacego.Meta{}, bytes.NewReader(content))
+			return err
+		},
+		"previews": func() error {
+			_, err := spacego.GenerateImagePreviews(&spacego.Meta{}, bytes.NewReader(content))
+			return err
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, spacego.ErrImageTooLarge{
+				Width:  65535,
+				Height: 65535,
+			}, generate())
+		})
+	}
+}
+
+func TestGeneratePreview_Text(t *testing.T) {
+	for name, tt := range map[string]struct {
+		content  string
+		expected string
+	}{
+		"short": {
+			content:  "Hello World",
+			expected: "Hello World",
+		},
+		"long": {
+			content:  strings.Repeat("a", 100),
+			expected: strings.Repeat("a", spacego.PREVIEW_TEXT_LENGTH),
+		},
+		"multibyte": {
+			// Euro sign is 3 bytes, so the 22nd straddles the limit
+			content:  strings.Repeat("€", 30),
+			expected: strings.Repeat("€", 21),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			preview, err := spacego.GeneratePreview(&spacego.Meta{
+				Name: name,
+				Type: spacego.MIME_TYPE_TEXT_PLAIN,
+			}, strings.NewReader(tt.content))
+			testinggo.AssertNoError(t, err)
+			assert.Equal(t, spacego.MIME_TYPE_TEXT_PLAIN, preview.Type)
+			assert.Equal(t, tt.expected, string(preview.Data))
+		})
+	}
+}
+
+func TestGeneratePreview_Unsupported(t *testing.T) {
+	_, err := spacego.GeneratePreview(&spacego.Meta{
+		Name: "movie.mpg",
+		Type: spacego.MIME_TYPE_VIDEO_MPEG,
+	}, strings.NewReader(""))
+	assert.Equal(t, spacego.ErrNoPreviewGenerator{Type: spacego.MIME_TYPE_VIDEO_MPEG}, err)
+}
+
+func TestRegisterPreviewGenerator(t *testing.T) {
+	spacego.RegisterPreviewGenerator("text/x-test", func(meta *spacego.Meta, content io.Reader) (*spacego.Preview, error) {
+		data, err := ioutil.ReadAll(content)
+		if err != nil {
+			return nil, err
+		}
+		return &spacego.Preview{
+			Type: meta.Type,
+			Data: bytes.ToUpper(data),
+		}, nil
+	})
+	preview, err := spacego.GeneratePreview(&spacego.Meta{
+		Name: "test",
+		Type: "text/x-test",
+	}, strings.NewReader("hello"))
+	testinggo.AssertNoError(t, err)
+	assert.Equal(t, "HELLO", string(preview.Data))
+}
+
+func TestGeneratePreviews(t *testing.T) {
+	for name, tt := range map[string]struct {
+		width, height int
+		expected      [][2]uint32
+	}{
+		"large": {
+			width:  1024,
+			height: 768,
+			expected: [][2]uint32{
+				{64, 48},
+				{128, 96},
+				{512, 384},
+				{128, 96},
+			},
+		},
+		"small": {
+			width:  100,
+			height: 50,
+			expected: [][2]uint32{
+				{64, 32},
+				{100, 50},
+				{100, 50},
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var buffer bytes.Buffer
+			testinggo.AssertNoError(t, png.Encode(&buffer, testImage(tt.width, tt.height)))
+			previews, err := spacego.GeneratePreviews(&spacego.Meta{
+				Name: name,
+				Type: spacego.MIME_TYPE_IMAGE_PNG,
+			}, &buffer)
+			testinggo.AssertNoError(t, err)
+			var got [][2]uint32
+			for _, p := range previews {
+				got = append(got, [2]uint32{p.Width, p.Height})
+			}
+			assert.Equal(t, tt.expected, got)
+			last := previews[len(previews)-1]
+			assert.Equal(t, spacego.MIME_TYPE_PLACEHOLDER, last.Type)
+			assert.Equal(t, 28, len(last.Data))
+		})
+	}
+}
+
+func TestGeneratePreviews_Placeholder(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 16, 16))
+	for i := range img.Pix {
+		img.Pix[i] = 0xFF
+	}
+	var buffer bytes.Buffer
+	testinggo.AssertNoError(t, png.Encode(&buffer, img))
+	previews, err := spacego.GeneratePreviews(&spacego.Meta{
+		Name: "white.png",
+		Type: spacego.MIME_TYPE_IMAGE_PNG,
+	}, &buffer)
+	testinggo.AssertNoError(t, err)
+	hash := string(previews[len(previews)-1].Data)
+	// 4x3 components
+	assert.Equal(t, "L", hash[:1])
+	// Average colour is white
+	assert.Equal(t, "TSUA", hash[2:6])
+}
+
+func TestGeneratePreviews_Fallback(t *testing.T) {
+	previews, err := spacego.GeneratePreviews(&spacego.Meta{
+		Name: "notes.txt",
+		Type: spacego.MIME_TYPE_TEXT_PLAIN,
+	}, strings.NewReader("Hello World"))
+	testinggo.AssertNoError(t, err)
+	assert.Equal(t, 1, len(previews))
+	assert.Equal(t, "Hello World", string(previews[0].Data))
+}
+
+func TestBestPreview(t *testing.T) {
+	cache := newFakeCache()
+	node := newFakeNode("alice", cache)
+	_, err := spacego.BestPreview(node, "m", 64, 64)
+	assert.Equal(t, spacego.ErrNoPreview{MetaId: "m"}, err)
+
+	preview := &spacego.Preview{Type: spacego.MIME_TYPE_IMAGE_DEFAULT, Width: 64, Height: 48}
+	data, err := proto.Marshal(preview)
+	testinggo.AssertNoError(t, err)
+	writeRecord(t, node, spacego.PreviewChannelName("m"), nil, data)
+	best, err := spacego.BestPreview(node, "m", 64, 64)
+	testinggo.AssertNoError(t, err)
+	assert.True(t, proto.Equal(preview, best))
+
+	t.Run("RefreshError", func(t *testing.T) {
+		// The head in the cache points to a missing block, so the last known head is read instead
+		testinggo.AssertNoError(t, cache.PutHead(spacego.PreviewChannelName("m"), &bcgo.Reference{
+			BlockHash: []byte("missing"),
+		}))
+		best, err := spacego.BestPreview(node, "m", 64, 64)
+		testinggo.AssertNoError(t, err)
+		assert.True(t, proto.Equal(preview, best))
+	})
+}
+
+func TestBestPreview_Version(t *testing.T) {
+	node := newFakeNode("alice", newFakeCache())
+	name := spacego.PreviewChannelName("m")
+	write := func(preview *spacego.Preview, version string) {
+		data, err := proto.Marshal(preview)
+		testinggo.AssertNoError(t, err)
+		var references []*bcgo.Reference
+		if version != "" {
+			references = append(references, &bcgo.Reference{
+				ChannelName: spacego.DeltaChannelName("m"),
+				BlockHash:   []byte(version),
+			})
+		}
+		writeRecord(t, node, name, references, data)
+	}
+	unversioned := &spacego.Preview{Type: spacego.MIME_TYPE_IMAGE_DEFAULT, Width: 1024, Height: 768}
+	old := &spacego.Preview{Type: spacego.MIME_TYPE_IMAGE_DEFAULT, Width: 512, Height: 384}
+	current := &spacego.Preview{Type: spacego.MIME_TYPE_IMAGE_DEFAULT, Width: 64, Height: 48}
+	write(unversioned, "")
+	write(old, "v1")
+	write(current, "v2")
+	// Larger previews of older versions are not selected
+	best, err := spacego.BestPreview(node, "m", 512, 384)
+	testinggo.AssertNoError(t, err)
+	assert.True(t, proto.Equal(current, best))
+}
+
+func TestSelectPreview(t *testing.T) {
+	small := &spacego.Preview{Type: spacego.MIME_TYPE_IMAGE_DEFAULT, Width: 64, Height: 48}
+	medium := &spacego.Preview{Type: spacego.MIME_TYPE_IMAGE_DEFAULT, Width: 128, Height: 96}
+	large := &spacego.Preview{Type: spacego.MIME_TYPE_IMAGE_DEFAULT, Width: 512, Height: 384}
+	placeholder := &spacego.Preview{Type: spacego.MIME_TYPE_PLACEHOLDER, Width: 128, Height: 96}
+	all := []*spacego.Preview{placeholder, large, small, medium}
+	for name, tt := range map[string]struct {
+		previews      []*spacego.Preview
+		width, height uint32
+		expected      *spacego.Preview
+	}{
+		"empty": {},
+		"exact": {
+			previews: all,
+			width:    128,
+			height:   96,
+			expected: medium,
+		},
+		"between": {
+			previews: all,
+			width:    200,
+			height:   100,
+			expected: large,
+		},
+		"tiny": {
+			previews: all,
+			width:    16,
+			height:   16,
+			expected: small,
+		},
+		"huge": {
+			previews: all,
+			width:    2048,
+			height:   2048,
+			expected: large,
+		},
+		"placeholder_only": {
+			previews: []*spacego.Preview{placeholder},
+			width:    64,
+			height:   64,
+			expected: placeholder,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, spacego.SelectPreview(tt.previews, tt.width, tt.height))
+		})
+	}
+}
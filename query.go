@@ -0,0 +1,456 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego
+
+/*
+   Query Language
+
+   query      = or
+   or         = and { "OR" and }
+   and        = unary { [ "AND" ] unary }
+   unary      = "NOT" unary | primary
+   primary    = "(" or ")" | term
+   term       = field operator value | value
+   operator   = ":" | ":~" | "=" | ">" | ">=" | "<" | "<="
+   value      = word | '"' { character } '"'
+
+   Meta fields:
+     name:*.pdf     name matches glob, or equals value without wildcards
+     name:~draft    name contains value, ignoring case
+     name:/^a.*b$/  name matches regular expression
+     name=report    name equals value
+     type:image/*   MIME type matches, either part may be *
+     tag:holiday    file has tag
+     tag:project/*  file has tag project or any tag below it, see MatchTag
+     modified>DATE  file content was last modified after date (2006-01-02 or RFC3339)
+     draft          bare value is the same as name:~draft
+
+   Tag fields:
+     value:holiday  tag value equals value, or matches a pattern such as status:*
+     reason:~rule   tag reason contains value, ignoring case
+     holiday        bare value is the same as value:holiday
+*/
+
+import (
+	"aletheiaware.com/bcgo"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	QUERY_AND = "AND"
+	QUERY_OR  = "OR"
+	QUERY_NOT = "NOT"
+
+	QUERY_FIELD_MODIFIED = "modified"
+	QUERY_FIELD_NAME     = "name"
+	QUERY_FIELD_REASON   = "reason"
+	QUERY_FIELD_TAG      = "tag"
+	QUERY_FIELD_TYPE     = "type"
+	QUERY_FIELD_VALUE    = "value"
+)
+
+type ErrQuerySyntax struct {
+	Offset  int
+	Message string
+}
+
+func (e ErrQuerySyntax) Error() string {
+	return fmt.Sprintf("Syntax error at offset %d: %s", e.Offset, e.Message)
+}
+
+// CompileMetaQuery parses the given query into a MetaFilter.
+// Filters on tags look up the given TagIndex, or read each file's Tag channel from the given node if the index is nil, and filters on modification time read the file's Delta channel, so both must be given the metaId using FilterMeta.
+func CompileMetaQuery(node bcgo.Node, index *TagIndex, query string) (MetaFilter, error) {
+	p := &queryParser{
+		input: query,
+		compile: func(t *queryTerm) (interface{}, error) {
+			return compileMetaTerm(node, index, t)
+		},
+		combine: func(op string, operands []interface{}) interface{} {
+			var filters []MetaFilter
+			for _, o := range operands {
+				filters = append(filters, o.(MetaFilter))
+			}
+			switch op {
+			case QUERY_AND:
+				return NewAndMetaFilter(filters...)
+			case QUERY_OR:
+				return NewOrMetaFilter(filters...)
+			default:
+				return NewNotMetaFilter(filters[0])
+			}
+		},
+	}
+	result, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	return result.(MetaFilter), nil
+}
+
+// CompileTagQuery parses the given query into a TagFilter.
+func CompileTagQuery(query string) (TagFilter, error) {
+	p := &queryParser{
+		input: query,
+		compile: func(t *queryTerm) (interface{}, error) {
+			return compileTagTerm(t)
+		},
+		combine: func(op string, operands []interface{}) interface{} {
+			var filters []TagFilter
+			for _, o := range operands {
+				filters = append(filters, o.(TagFilter))
+			}
+			switch op {
+			case QUERY_AND:
+				return NewAndTagFilter(filters...)
+			case QUERY_OR:
+				return NewOrTagFilter(filters...)
+			default:
+				return NewNotTagFilter(filters[0])
+			}
+		},
+	}
+	result, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	return result.(TagFilter), nil
+}
+
+type queryTerm struct {
+	offset   int // Offset of term
+	field    string
+	operator string
+	value    string
+	vOffset  int // Offset of value
+}
+
+func (t *queryTerm) errorf(format string, args ...interface{}) error {
+	return ErrQuerySyntax{
+		Offset:  t.offset,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+func compileMetaTerm(node bcgo.Node, index *TagIndex, t *queryTerm) (MetaFilter, error) {
+	switch t.field {
+	case "":
+		return NewNameContainsFilter(t.value), nil
+	case QUERY_FIELD_NAME:
+		switch t.operator {
+		case ":":
+			if len(t.value) > 1 && strings.HasPrefix(t.value, "/") && strings.HasSuffix(t.value, "/") {
+				f, err := NewNameRegexFilter(t.value[1 : len(t.value)-1])
+				if err != nil {
+					return nil, ErrQuerySyntax{Offset: t.vOffset, Message: err.Error()}
+				}
+				return f, nil
+			}
+			if strings.ContainsAny(t.value, "*?[") {
+				f, err := NewNameGlobFilter(t.value)
+				if err != nil {
+					return nil, ErrQuerySyntax{Offset: t.vOffset, Message: err.Error()}
+				}
+				return f, nil
+			}
+			return NewNameFilter(t.value), nil
+		case ":~":
+			return NewNameContainsFilter(t.value), nil
+		case "=":
+			return NewNameFilter(t.value), nil
+		}
+	case QUERY_FIELD_TYPE:
+		switch t.operator {
+		case ":":
+			return NewTypeWildcardFilter(t.value), nil
+		case "=":
+			return NewTypeFilter(t.value), nil
+		}
+	case QUERY_FIELD_TAG:
+		switch t.operator {
+		case ":", "=":
+			if index != nil {
+				return NewIndexedTagFilter(index, NewTagFilter(t.value)), nil
+			}
+			return NewHasTagFilter(node, NewTagFilter(t.value)), nil
+		}
+	case QUERY_FIELD_MODIFIED:
+		start, end, err := parseQueryTime(t.value)
+		if err != nil {
+			return nil, ErrQuerySyntax{Offset: t.vOffset, Message: err.Error()}
+		}
+		switch t.operator {
+		case ":", "=", ">", ">=", "<", "<=":
+			return NewModifiedFilter(node, t.operator, start, end), nil
+		}
+	default:
+		return nil, t.errorf("unknown field '%s'", t.field)
+	}
+	return nil, t.errorf("operator '%s' not supported by field '%s'", t.operator, t.field)
+}
+
+func compileTagTerm(t *queryTerm) (TagFilter, error) {
+	switch t.field {
+	case "":
+		return NewTagFilter(t.value), nil
+	case QUERY_FIELD_VALUE, QUERY_FIELD_TAG:
+		switch t.operator {
+		case ":", "=":
+			return NewTagFilter(t.value), nil
+		}
+	case QUERY_FIELD_REASON:
+		switch t.operator {
+		case ":~":
+			return NewTagReasonContainsFilter(t.value), nil
+		}
+	default:
+		return nil, t.errorf("unknown field '%s'", t.field)
+	}
+	return nil, t.errorf("operator '%s' not supported by field '%s'", t.operator, t.field)
+}
+
+// parseQueryTime returns the interval covered by the given date or time.
+func parseQueryTime(value string) (time.Time, time.Time, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, t.Add(24 * time.Hour), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid time '%s', expected 2006-01-02 or RFC3339", value)
+	}
+	return t, t.Add(time.Nanosecond), nil
+}
+
+// queryParser is a recursive descent parser which uses compile to turn each term into a filter, and combine to join filters with AND, OR, and NOT.
+type queryParser struct {
+	input   string
+	pos     int
+	compile func(*queryTerm) (interface{}, error)
+	combine func(string, []interface{}) interface{}
+}
+
+func (p *queryParser) parse() (interface{}, error) {
+	p.skipSpace()
+	if p.pos >= len(p.input) {
+		return nil, ErrQuerySyntax{Offset: p.pos, Message: "empty query"}
+	}
+	r, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return nil, ErrQuerySyntax{Offset: p.pos, Message: fmt.Sprintf("unexpected '%c'", p.input[p.pos])}
+	}
+	return r, nil
+}
+
+func (p *queryParser) or() (interface{}, error) {
+	first, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	operands := []interface{}{first}
+	for p.keyword(QUERY_OR) {
+		next, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, next)
+	}
+	if len(operands) == 1 {
+		return first, nil
+	}
+	return p.combine(QUERY_OR, operands), nil
+}
+
+func (p *queryParser) and() (interface{}, error) {
+	first, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	operands := []interface{}{first}
+	for {
+		if p.keyword(QUERY_AND) {
+			next, err := p.unary()
+			if err != nil {
+				return nil, err
+			}
+			operands = append(operands, next)
+			continue
+		}
+		// Implicit AND between adjacent terms
+		p.skipSpace()
+		if p.pos >= len(p.input) || p.input[p.pos] == ')' || p.peekKeyword(QUERY_OR) {
+			break
+		}
+		next, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, next)
+	}
+	if len(operands) == 1 {
+		return first, nil
+	}
+	return p.combine(QUERY_AND, operands), nil
+}
+
+func (p *queryParser) unary() (interface{}, error) {
+	if p.keyword(QUERY_NOT) {
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return p.combine(QUERY_NOT, []interface{}{operand}), nil
+	}
+	return p.primary()
+}
+
+func (p *queryParser) primary() (interface{}, error) {
+	p.skipSpace()
+	if p.pos >= len(p.input) {
+		return nil, ErrQuerySyntax{Offset: p.pos, Message: "unexpected end of query"}
+	}
+	switch p.input[p.pos] {
+	case '(':
+		open := p.pos
+		p.pos++
+		r, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.pos >= len(p.input) || p.input[p.pos] != ')' {
+			return nil, ErrQuerySyntax{Offset: open, Message: "unclosed '('"}
+		}
+		p.pos++
+		return r, nil
+	case ')':
+		return nil, ErrQuerySyntax{Offset: p.pos, Message: "unexpected ')'"}
+	}
+	t, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	return p.compile(t)
+}
+
+func (p *queryParser) skipSpace() {
+	for p.pos < len(p.input) {
+		r, w := utf8.DecodeRuneInString(p.input[p.pos:])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		p.pos += w
+	}
+}
+
+// peekKeyword returns true if the given keyword is next in the input as a whole word.
+func (p *queryParser) peekKeyword(keyword string) bool {
+	p.skipSpace()
+	end := p.pos + len(keyword)
+	if end > len(p.input) || p.input[p.pos:end] != keyword {
+		return false
+	}
+	return end == len(p.input) || isQueryDelimiter(p.input[end:])
+}
+
+// keyword consumes the given keyword if it is next in the input.
+func (p *queryParser) keyword(keyword string) bool {
+	if p.peekKeyword(keyword) {
+		p.pos += len(keyword)
+		return true
+	}
+	return false
+}
+
+func (p *queryParser) term() (*queryTerm, error) {
+	t := &queryTerm{
+		offset: p.pos,
+	}
+	// Field
+	start := p.pos
+	for p.pos < len(p.input) && (p.input[p.pos] >= 'a' && p.input[p.pos] <= 'z') {
+		p.pos++
+	}
+	if p.pos > start && p.pos < len(p.input) {
+		for _, op := range []string{":~", ">=", "<=", ":", "=", ">", "<"} {
+			if strings.HasPrefix(p.input[p.pos:], op) {
+				t.field = p.input[start:p.pos]
+				t.operator = op
+				p.pos += len(op)
+				break
+			}
+		}
+	}
+	if t.field == "" {
+		// Bare value
+		p.pos = start
+	}
+	// Value
+	t.vOffset = p.pos
+	value, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	t.value = value
+	return t, nil
+}
+
+func (p *queryParser) value() (string, error) {
+	if p.pos >= len(p.input) || isQueryDelimiter(p.input[p.pos:]) {
+		return "", ErrQuerySyntax{Offset: p.pos, Message: "expected value"}
+	}
+	if p.input[p.pos] == '"' {
+		open := p.pos
+		p.pos++
+		var value strings.Builder
+		for p.pos < len(p.input) {
+			c := p.input[p.pos]
+			p.pos++
+			switch c {
+			case '"':
+				return value.String(), nil
+			case '\\':
+				if p.pos < len(p.input) {
+					value.WriteByte(p.input[p.pos])
+					p.pos++
+				}
+			default:
+				value.WriteByte(c)
+			}
+		}
+		return "", ErrQuerySyntax{Offset: open, Message: "unclosed '\"'"}
+	}
+	start := p.pos
+	for p.pos < len(p.input) && !isQueryDelimiter(p.input[p.pos:]) {
+		_, w := utf8.DecodeRuneInString(p.input[p.pos:])
+		p.pos += w
+	}
+	return p.input[start:p.pos], nil
+}
+
+// isQueryDelimiter returns true if the given input starts with a parenthesis or a space.
+func isQueryDelimiter(input string) bool {
+	r, _ := utf8.DecodeRuneInString(input)
+	return r == '(' || r == ')' || unicode.IsSpace(r)
+}
@@ -0,0 +1,196 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego_test
+
+import (
+	"aletheiaware.com/spacego"
+	"aletheiaware.com/testinggo"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCompileMetaQuery(t *testing.T) {
+	photo := &spacego.Meta{Name: "Holiday.png", Type: spacego.MIME_TYPE_IMAGE_PNG}
+	draft := &spacego.Meta{Name: "holiday-draft.jpg", Type: spacego.MIME_TYPE_IMAGE_JPEG}
+	invoice := &spacego.Meta{Name: "march.invoice.pdf", Type: spacego.MIME_TYPE_PDF}
+	notes := &spacego.Meta{Name: "my notes.txt", Type: spacego.MIME_TYPE_TEXT_PLAIN}
+	for name, tt := range map[string]struct {
+		query    string
+		expected []*spacego.Meta
+	}{
+		"type_wildcard": {
+			query:    "type:image/*",
+			expected: []*spacego.Meta{photo, draft},
+		},
+		"not_name_contains": {
+			query:    "type:image/* AND NOT name:~DRAFT",
+			expected: []*spacego.Meta{photo},
+		},
+		"implicit_and": {
+			query:    "type:image/* NOT name:~draft",
+			expected: []*spacego.Meta{photo},
+		},
+		"or": {
+			query:    "type=application/pdf OR type:text/*",
+			expected: []*spacego.Meta{invoice, notes},
+		},
+		"precedence": {
+			query:    "type:image/* AND name:~draft OR type=application/pdf",
+			expected: []*spacego.Meta{draft, invoice},
+		},
+		"parentheses": {
+			query:    "type:image/* AND (name:~draft OR type=application/pdf)",
+			expected: []*spacego.Meta{draft},
+		},
+		"glob": {
+			query:    "name:*.invoice.pdf",
+			expected: []*spacego.Meta{invoice},
+		},
+		"regex": {
+			query:    "name:/^[A-Z]/",
+			expected: []*spacego.Meta{photo},
+		},
+		"quoted": {
+			query:    `name="my notes.txt"`,
+			expected: []*spacego.Meta{notes},
+		},
+		"bare": {
+			query:    "holiday",
+			expected: []*spacego.Meta{photo, draft},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			filter, err := spacego.CompileMetaQuery(nil, nil, tt.query)
+			testinggo.AssertNoError(t, err)
+			var got []*spacego.Meta
+			for _, m := range []*spacego.Meta{photo, draft, invoice, notes} {
+				if filter.Filter(m) {
+					got = append(got, m)
+				}
+			}
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestCompileMetaQuery_NonASCII(t *testing.T) {
+	// The second bytes of à (C3 A0) and Å (C3 85) are not spaces
+	voila := &spacego.Meta{Name: "voilà.txt", Type: spacego.MIME_TYPE_TEXT_PLAIN}
+	aland := &spacego.Meta{Name: "Åland.pdf", Type: spacego.MIME_TYPE_PDF}
+	for name, tt := range map[string]struct {
+		query    string
+		expected []*spacego.Meta
+	}{
+		"bare": {
+			query:    "voilà",
+			expected: []*spacego.Meta{voila},
+		},
+		"field": {
+			query:    "name=Åland.pdf",
+			expected: []*spacego.Meta{aland},
+		},
+		"keyword": {
+			query:    "name:~voilà OR name:~Åland",
+			expected: []*spacego.Meta{voila, aland},
+		},
+		"unicode_space": {
+			query:    "voilà\u00a0OR\u2003Åland",
+			expected: []*spacego.Meta{voila, aland},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			filter, err := spacego.CompileMetaQuery(nil, nil, tt.query)
+			testinggo.AssertNoError(t, err)
+			var got []*spacego.Meta
+			for _, m := range []*spacego.Meta{voila, aland} {
+				if filter.Filter(m) {
+					got = append(got, m)
+				}
+			}
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestCompileMetaQuery_Tag(t *testing.T) {
+	i := testTagIndex(t)
+	meta := &spacego.Meta{Name: "notes.txt"}
+	filter, err := spacego.CompileMetaQuery(nil, i, "tag:work AND NOT tag:draft")
+	testinggo.AssertNoError(t, err)
+	assert.False(t, spacego.FilterMeta(filter, "a", meta))
+	assert.True(t, spacego.FilterMeta(filter, "b", meta))
+	assert.True(t, spacego.FilterMeta(filter, "c", meta))
+	assert.False(t, filter.Filter(meta))
+}
+
+func TestCompileMetaQueryError(t *testing.T) {
+	for name, tt := range map[string]struct {
+		query    string
+		expected error
+	}{
+		"empty": {
+			query:    "  ",
+			expected: spacego.ErrQuerySyntax{Offset: 2, Message: "empty query"},
+		},
+		"unknown_field": {
+			query:    "type:image/* AND size>10",
+			expected: spacego.ErrQuerySyntax{Offset: 17, Message: "unknown field 'size'"},
+		},
+		"unsupported_operator": {
+			query:    "tag>holiday",
+			expected: spacego.ErrQuerySyntax{Offset: 0, Message: "operator '>' not supported by field 'tag'"},
+		},
+		"missing_value": {
+			query:    "name: foo",
+			expected: spacego.ErrQuerySyntax{Offset: 5, Message: "expected value"},
+		},
+		"dangling_operator": {
+			query:    "foo AND",
+			expected: spacego.ErrQuerySyntax{Offset: 7, Message: "unexpected end of query"},
+		},
+		"unclosed_parenthesis": {
+			query:    "foo AND (bar OR baz",
+			expected: spacego.ErrQuerySyntax{Offset: 8, Message: "unclosed '('"},
+		},
+		"unexpected_parenthesis": {
+			query:    "foo)",
+			expected: spacego.ErrQuerySyntax{Offset: 3, Message: "unexpected ')'"},
+		},
+		"unclosed_quote": {
+			query:    `name:"foo`,
+			expected: spacego.ErrQuerySyntax{Offset: 5, Message: "unclosed '\"'"},
+		},
+		"invalid_time": {
+			query:    "modified>yesterday",
+			expected: spacego.ErrQuerySyntax{Offset: 9, Message: "invalid time 'yesterday', expected 2006-01-02 or RFC3339"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := spacego.CompileMetaQuery(nil, nil, tt.query)
+			assert.Equal(t, tt.expected, err)
+		})
+	}
+}
+
+func TestCompileTagQuery(t *testing.T) {
+	filter, err := spacego.CompileTagQuery("(invoice OR value:receipt) AND NOT reason:~auto")
+	testinggo.AssertNoError(t, err)
+	assert.True(t, filter.Filter(&spacego.Tag{Value: "invoice", Reason: "Added by Alice"}))
+	assert.True(t, filter.Filter(&spacego.Tag{Value: "receipt"}))
+	assert.False(t, filter.Filter(&spacego.Tag{Value: "invoice", Reason: "Automatic rule"}))
+	assert.False(t, filter.Filter(&spacego.Tag{Value: "holiday"}))
+}
@@ -0,0 +1,273 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego
+
+import (
+	"aletheiaware.com/bcgo"
+	"aletheiaware.com/financego"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"io"
+	"sync"
+)
+
+// Fractions of a quota at which a QuotaEvent is triggered.
+const (
+	QUOTA_WARNING  = 0.80
+	QUOTA_CRITICAL = 0.95
+)
+
+// Estimated number of bytes a record adds to its block beyond its payload, measured as the encoded size of a BlockEntry whose record is readable by one alias, less its payload, with 4096-bit RSA keys, AES-GCM, and SHA-512:
+// timestamp 10, creator 22, access with an encrypted key 542, encryption nonce and tag 30, algorithms 4, signature 515, record hash 66, and entry framing 4, rounded up from 1193.
+const RECORD_OVERHEAD = 1200
+
+type ErrQuotaExceeded struct {
+	Alias string
+	Usage uint64
+	Limit uint64
+}
+
+func (e ErrQuotaExceeded) Error() string {
+	return fmt.Sprintf("Quota exceeded: %s would use %d of %d bytes", e.Alias, e.Usage, e.Limit)
+}
+
+var quotas = struct {
+	sync.RWMutex
+	limits map[string]uint64
+}{
+	limits: make(map[string]uint64),
+}
+
+// SetQuota limits the storage of the given alias to the given number of bytes, overriding any quota derived from its subscriptions.
+// A limit of zero removes the local quota.
+func SetQuota(alias string, limit uint64) {
+	quotas.Lock()
+	defer quotas.Unlock()
+	if limit == 0 {
+		delete(quotas.limits, alias)
+	} else {
+		quotas.limits[alias] = limit
+	}
+}
+
+// ServiceQuota returns the number of bytes covered by the given service, which is the group size of a fixed amount service, or zero if the service is metered and so unlimited.
+// Registrars give the group size of their service in bytes, see Registrar.
+func ServiceQuota(service *financego.Service) uint64 {
+	if service == nil || service.Mode != financego.Mode_FIXED_AMOUNT || service.GroupSize <= 0 {
+		return 0
+	}
+	return uint64(service.GroupSize)
+}
+
+// Quota returns the storage limit of the node's alias in bytes; the local quota set by SetQuota if any, or else the smallest quota of the services of the registrars it is subscribed to, as every registrar stores every block.
+// Zero means unlimited.
+func Quota(node bcgo.Node) (uint64, error) {
+	alias := node.Account().Alias()
+	quotas.RLock()
+	limit, ok := quotas.limits[alias]
+	quotas.RUnlock()
+	if ok {
+		return limit, nil
+	}
+	if err := AllRegistrarsForNode(node, func(registrar *Registrar, registration *financego.Registration, subscription *financego.Subscription) error {
+		if subscription == nil {
+			return nil
+		}
+		if q := ServiceQuota(registrar.Service); q > 0 && (limit == 0 || q < limit) {
+			limit = q
+		}
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return limit, nil
+}
+
+// QuotaEvent is triggered when usage reaches a threshold of a quota, such as QUOTA_WARNING, or would exceed it.
+type QuotaEvent struct {
+	Alias     string
+	Usage     uint64
+	Limit     uint64
+	Threshold float64 // Fraction of the limit reached, or 1 if exceeded
+}
+
+// QuotaListener is triggered with each QuotaEvent.
+type QuotaListener func(*QuotaEvent)
+
+// UploadGuard checks uploads against the quota of an alias before and while they are written.
+// When enforcing, an upload which would exceed the quota is refused with ErrQuotaExceeded; otherwise it is allowed and an event with a threshold of 1 is triggered.
+// Events for QUOTA_WARNING and QUOTA_CRITICAL are triggered once each, when usage, including the upload being checked, first reaches them.
+// UploadGuard is safe for concurrent use.
+type UploadGuard struct {
+	alias    string
+	limit    uint64
+	enforce  bool
+	listener QuotaListener
+	lock     sync.Mutex
+	usage    uint64
+	notified map[float64]bool
+}
+
+// NewUploadGuard returns an UploadGuard for the given alias with the given usage and limit in bytes, where a zero limit is unlimited.
+func NewUploadGuard(alias string, usage, limit uint64, enforce bool, listener QuotaListener) *UploadGuard {
+	return &UploadGuard{
+		alias:    alias,
+		limit:    limit,
+		enforce:  enforce,
+		listener: listener,
+		usage:    usage,
+		notified: make(map[float64]bool),
+	}
+}
+
+// UploadGuardForNode returns an UploadGuard for the node's alias, with its current usage measured by MeasureUsage and its limit given by Quota.
+func UploadGuardForNode(node bcgo.Node, enforce bool, listener QuotaListener) (*UploadGuard, error) {
+	limit, err := Quota(node)
+	if err != nil {
+		return nil, err
+	}
+	alias := node.Account().Alias()
+	usage, err := MeasureUsage(node, alias, bcgo.Timestamp())
+	if err != nil {
+		return nil, err
+	}
+	return NewUploadGuard(alias, usage.Total(), limit, enforce, listener), nil
+}
+
+// Usage returns the usage in bytes, including what has been added since the guard was created.
+func (g *UploadGuard) Usage() uint64 {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.usage
+}
+
+// Check returns ErrQuotaExceeded if adding the given number of bytes would exceed the quota and the guard is enforcing, and triggers any events reached.
+func (g *UploadGuard) Check(size uint64) error {
+	g.lock.Lock()
+	events, err := g.check(g.usage + size)
+	g.lock.Unlock()
+	g.trigger(events)
+	return err
+}
+
+// Add records the given number of bytes as written, after checking them as Check does.
+func (g *UploadGuard) Add(size uint64) error {
+	g.lock.Lock()
+	events, err := g.check(g.usage + size)
+	if err == nil {
+		g.usage += size
+	}
+	g.lock.Unlock()
+	g.trigger(events)
+	return err
+}
+
+// DeltaUsage returns the estimated number of bytes the given delta adds to usage as measured by MeasureUsage, once written and mined.
+func DeltaUsage(delta *Delta) uint64 {
+	return uint64(proto.Size(delta)) + RECORD_OVERHEAD
+}
+
+// UploadUsage returns the estimated number of bytes an upload of the given size adds to usage, as DeltaUsage, when split by CreateDeltas into deltas of at most the given size.
+func UploadUsage(size, max uint64) uint64 {
+	if max == 0 {
+		return 0
+	}
+	var usage uint64
+	for offset := uint64(0); offset < size; offset += max {
+		count := max
+		if size-offset < max {
+			count = size - offset
+		}
+		usage += insertSize(offset, count) + RECORD_OVERHEAD
+	}
+	return usage
+}
+
+// insertSize returns the encoded size of a Delta inserting the given number of bytes at the given offset, as proto.Size would, without allocating the bytes.
+func insertSize(offset, count uint64) uint64 {
+	var size uint64
+	if offset > 0 {
+		// Tag and varint of Offset
+		size += 1 + uint64(proto.SizeVarint(offset))
+	}
+	if count > 0 {
+		// Tag, length, and bytes of Insert
+		size += 1 + uint64(proto.SizeVarint(count)) + count
+	}
+	return size
+}
+
+// CreateDeltas checks the upload of the given size, if known, before calling CreateDeltas, and adds each delta as it is created so an upload of unknown size is stopped once it would exceed the quota.
+// Uploads are charged by UploadUsage and DeltaUsage, in the same units as the usage measured by MeasureUsage.
+// Records cannot be removed from a channel, so when an upload of unknown size is refused the deltas already passed to the callback remain, and are included in Usage.
+// Callers should only mine the deltas, and write the Meta of the file, once CreateDeltas returns without error, so that a refused upload is never mined.
+func (g *UploadGuard) CreateDeltas(reader io.Reader, size uint64, max uint64, callback func(*Delta) error) error {
+	if err := g.Check(UploadUsage(size, max)); err != nil {
+		return err
+	}
+	return CreateDeltas(reader, max, func(delta *Delta) error {
+		if err := g.Add(DeltaUsage(delta)); err != nil {
+			return err
+		}
+		return callback(delta)
+	})
+}
+
+func (g *UploadGuard) check(usage uint64) ([]*QuotaEvent, error) {
+	if g.limit == 0 {
+		return nil, nil
+	}
+	exceeded := &QuotaEvent{
+		Alias:     g.alias,
+		Usage:     usage,
+		Limit:     g.limit,
+		Threshold: 1,
+	}
+	if usage > g.limit && g.enforce {
+		return []*QuotaEvent{exceeded}, ErrQuotaExceeded{
+			Alias: g.alias,
+			Usage: usage,
+			Limit: g.limit,
+		}
+	}
+	// Events are triggered in ascending order of threshold
+	var events []*QuotaEvent
+	for _, t := range []float64{QUOTA_WARNING, QUOTA_CRITICAL} {
+		if !g.notified[t] && float64(usage) >= t*float64(g.limit) {
+			g.notified[t] = true
+			events = append(events, &QuotaEvent{
+				Alias:     g.alias,
+				Usage:     usage,
+				Limit:     g.limit,
+				Threshold: t,
+			})
+		}
+	}
+	if usage > g.limit {
+		events = append(events, exceeded)
+	}
+	return events, nil
+}
+
+func (g *UploadGuard) trigger(events []*QuotaEvent) {
+	if g.listener == nil {
+		return
+	}
+	for _, e := range events {
+		g.listener(e)
+	}
+}
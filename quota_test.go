@@ -0,0 +1,239 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego_test
+
+import (
+	"aletheiaware.com/bcgo"
+	"aletheiaware.com/financego"
+	"aletheiaware.com/spacego"
+	"aletheiaware.com/testinggo"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestServiceQuota(t *testing.T) {
+	for name, tt := range map[string]struct {
+		service  *financego.Service
+		expected uint64
+	}{
+		"nil": {},
+		"fixed": {
+			service: &financego.Service{
+				Mode:      financego.Mode_FIXED_AMOUNT,
+				GroupSize: 1000000,
+			},
+			expected: 1000000,
+		},
+		"fixed_without_size": {
+			service: &financego.Service{
+				Mode: financego.Mode_FIXED_AMOUNT,
+			},
+		},
+		"metered": {
+			service: &financego.Service{
+				Mode:      financego.Mode_METERED_LAST_USAGE,
+				GroupSize: 1000000,
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, spacego.ServiceQuota(tt.service))
+		})
+	}
+}
+
+func TestUploadGuard_Check(t *testing.T) {
+	for name, tt := range map[string]struct {
+		usage, limit uint64
+		enforce      bool
+		size         uint64
+		err          error
+		thresholds   []float64
+	}{
+		"unlimited": {
+			usage: 1000,
+			size:  1000,
+		},
+		"below_warning": {
+			usage: 100,
+			limit: 1000,
+			size:  600,
+		},
+		"warning": {
+			usage: 100,
+			limit: 1000,
+			size:  700,
+			thresholds: []float64{
+				spacego.QUOTA_WARNING,
+			},
+		},
+		"critical": {
+			usage: 100,
+			limit: 1000,
+			size:  900,
+			thresholds: []float64{
+				spacego.QUOTA_WARNING,
+				spacego.QUOTA_CRITICAL,
+			},
+		},
+		"full": {
+			usage:   100,
+			limit:   1000,
+			enforce: true,
+			size:    900,
+			thresholds: []float64{
+				spacego.QUOTA_WARNING,
+				spacego.QUOTA_CRITICAL,
+			},
+		},
+		"exceeded_refused": {
+			usage:   100,
+			limit:   1000,
+			enforce: true,
+			size:    901,
+			err: spacego.ErrQuotaExceeded{
+				Alias: "alice",
+				Usage: 1001,
+				Limit: 1000,
+			},
+			thresholds: []float64{1},
+		},
+		"exceeded_warned": {
+			usage: 100,
+			limit: 1000,
+			size:  901,
+			thresholds: []float64{
+				spacego.QUOTA_WARNING,
+				spacego.QUOTA_CRITICAL,
+				1,
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var thresholds []float64
+			guard := spacego.NewUploadGuard("alice", tt.usage, tt.limit, tt.enforce, func(e *spacego.QuotaEvent) {
+				assert.Equal(t, "alice", e.Alias)
+				assert.Equal(t, tt.usage+tt.size, e.Usage)
+				assert.Equal(t, tt.limit, e.Limit)
+				thresholds = append(thresholds, e.Threshold)
+			})
+			assert.Equal(t, tt.err, guard.Check(tt.size))
+			assert.Equal(t, tt.thresholds, thresholds)
+			// Check does not record usage
+			assert.Equal(t, tt.usage, guard.Usage())
+		})
+	}
+}
+
+func TestUploadGuard_Add(t *testing.T) {
+	var thresholds []float64
+	guard := spacego.NewUploadGuard("alice", 0, 100, true, func(e *spacego.QuotaEvent) {
+		thresholds = append(thresholds, e.Threshold)
+	})
+	testinggo.AssertNoError(t, guard.Add(80))
+	assert.Equal(t, uint64(80), guard.Usage())
+	assert.Equal(t, []float64{spacego.QUOTA_WARNING}, thresholds)
+	// Thresholds are only triggered once
+	testinggo.AssertNoError(t, guard.Add(1))
+	assert.Equal(t, []float64{spacego.QUOTA_WARNING}, thresholds)
+	testinggo.AssertNoError(t, guard.Add(19))
+	assert.Equal(t, uint64(100), guard.Usage())
+	assert.Equal(t, []float64{spacego.QUOTA_WARNING, spacego.QUOTA_CRITICAL}, thresholds)
+	// Refused usage is not recorded
+	assert.Equal(t, spacego.ErrQuotaExceeded{
+		Alias: "alice",
+		Usage: 101,
+		Limit: 100,
+	}, guard.Add(1))
+	assert.Equal(t, uint64(100), guard.Usage())
+}
+
+func TestUploadUsage(t *testing.T) {
+	delta := func(offset uint64, size int) uint64 {
+		return spacego.DeltaUsage(&spacego.Delta{Offset: offset, Insert: make([]byte, size)})
+	}
+	assert.Equal(t, uint64(2+10+spacego.RECORD_OVERHEAD), delta(0, 10))
+	assert.Zero(t, spacego.UploadUsage(0, 10))
+	assert.Equal(t, delta(0, 10), spacego.UploadUsage(10, 10))
+	assert.Equal(t, delta(0, 10)+delta(10, 10)+delta(20, 5), spacego.UploadUsage(25, 10))
+	assert.Equal(t, delta(0, 200)+delta(200, 100), spacego.UploadUsage(300, 200))
+	assert.Equal(t, delta(0, 1<<20)+delta(1<<20, 1<<20)+delta(2<<20, 1), spacego.UploadUsage(2<<20+1, 1<<20))
+}
+
+func TestUploadGuard_CreateDeltas(t *testing.T) {
+	full := spacego.UploadUsage(60, 10)
+	partial := spacego.UploadUsage(50, 10)
+	t.Run("Refused", func(t *testing.T) {
+		guard := spacego.NewUploadGuard("alice", 50, 50+full-1, true, nil)
+		var deltas int
+		err := guard.CreateDeltas(bytes.NewReader(make([]byte, 60)), 60, 10, func(*spacego.Delta) error {
+			deltas++
+			return nil
+		})
+		assert.Equal(t, spacego.ErrQuotaExceeded{
+			Alias: "alice",
+			Usage: 50 + full,
+			Limit: 50 + full - 1,
+		}, err)
+		assert.Equal(t, 0, deltas)
+	})
+	t.Run("UnknownSize", func(t *testing.T) {
+		guard := spacego.NewUploadGuard("alice", 50, 50+full-1, true, nil)
+		var deltas int
+		err := guard.CreateDeltas(bytes.NewReader(make([]byte, 60)), 0, 10, func(*spacego.Delta) error {
+			deltas++
+			return nil
+		})
+		assert.Equal(t, spacego.ErrQuotaExceeded{
+			Alias: "alice",
+			Usage: 50 + full,
+			Limit: 50 + full - 1,
+		}, err)
+		// The deltas written before the upload was refused are included in usage
+		assert.Equal(t, 5, deltas)
+		assert.Equal(t, 50+partial, guard.Usage())
+	})
+	t.Run("Allowed", func(t *testing.T) {
+		guard := spacego.NewUploadGuard("alice", 50, 50+partial, true, nil)
+		var deltas int
+		testinggo.AssertNoError(t, guard.CreateDeltas(bytes.NewReader(make([]byte, 50)), 50, 10, func(*spacego.Delta) error {
+			deltas++
+			return nil
+		}))
+		assert.Equal(t, 5, deltas)
+		assert.Equal(t, 50+partial, guard.Usage())
+	})
+}
+
+func TestUploadGuardForNode(t *testing.T) {
+	node := newFakeNode("alice", newFakeCache())
+	appendText(t, node, writeTextFile(t, node, "notes.txt"), 0, "Hello World")
+	usage, err := spacego.MeasureUsage(node, "alice", bcgo.Timestamp())
+	testinggo.AssertNoError(t, err)
+	spacego.SetQuota("alice", 1000000)
+	defer spacego.SetQuota("alice", 0)
+	guard, err := spacego.UploadGuardForNode(node, true, nil)
+	testinggo.AssertNoError(t, err)
+	assert.Equal(t, usage.Total(), guard.Usage())
+
+	// The estimate of a delta is at least the size of the block it is mined into
+	metaId := writeTextFile(t, node, "todo.txt")
+	delta := &spacego.Delta{Insert: []byte("Hello World")}
+	appendText(t, node, metaId, 0, string(delta.Insert))
+	assert.GreaterOrEqual(t, spacego.DeltaUsage(delta), blockSize(t, node, spacego.DeltaChannelName(metaId)))
+}
@@ -0,0 +1,385 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego
+
+import (
+	"aletheiaware.com/bcgo"
+	"bytes"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const REGISTRAR_LATENCY_TIMEOUT = 5 * time.Second
+
+type ErrInsufficientRegistrars struct {
+	Required  int
+	Available int
+}
+
+func (e ErrInsufficientRegistrars) Error() string {
+	return fmt.Sprintf("Insufficient registrars: %d required, %d available", e.Required, e.Available)
+}
+
+// RegistrarPolicy constrains which registrars may be selected, such as to meet an enterprise's compliance requirements.
+type RegistrarPolicy interface {
+	// Pin returns true if the given registrar must be selected.
+	Pin(*Registrar) bool
+	// Exclude returns true if the given registrar must not be selected.
+	Exclude(*Registrar) bool
+}
+
+// NewMerchantPolicy returns a RegistrarPolicy which pins and excludes registrars by merchant alias.
+// A merchant which is both pinned and excluded is excluded.
+func NewMerchantPolicy(pinned, excluded []string) RegistrarPolicy {
+	p := &merchantPolicy{
+		pinned:   make(map[string]bool),
+		excluded: make(map[string]bool),
+	}
+	for _, m := range pinned {
+		p.pinned[m] = true
+	}
+	for _, m := range excluded {
+		p.excluded[m] = true
+	}
+	return p
+}
+
+type merchantPolicy struct {
+	pinned, excluded map[string]bool
+}
+
+func (p *merchantPolicy) Pin(registrar *Registrar) bool {
+	return p.pinned[registrar.Merchant.Alias]
+}
+
+func (p *merchantPolicy) Exclude(registrar *Registrar) bool {
+	return p.excluded[registrar.Merchant.Alias]
+}
+
+// LatencyMeasurer returns the time taken for a registrar to respond.
+type LatencyMeasurer func(*Registrar) (time.Duration, error)
+
+// MeasureLatency returns the time taken for the website of the given registrar to respond to a HEAD request.
+func MeasureLatency(registrar *Registrar) (time.Duration, error) {
+	client := &http.Client{
+		Timeout: REGISTRAR_LATENCY_TIMEOUT,
+	}
+	start := time.Now()
+	response, err := client.Head("https://" + registrar.Merchant.Domain)
+	if err != nil {
+		return 0, err
+	}
+	response.Body.Close()
+	return time.Since(start), nil
+}
+
+// RegistrarCandidate is a registrar considered for selection, with its measured latency.
+// A negative latency means the registrar could not be measured.
+type RegistrarCandidate struct {
+	Registrar *Registrar
+	Latency   time.Duration
+}
+
+// RegistrarPrice returns the price of the given registrar's service per unit of its group size, such as per byte, or the maximum float if it has no service.
+// Prices are compared as given, so registrars are assumed to charge in the same currency.
+func RegistrarPrice(registrar *Registrar) float64 {
+	s := registrar.Service
+	if s == nil || s.GroupSize <= 0 {
+		return math.MaxFloat64
+	}
+	return float64(s.GroupPrice) / float64(s.GroupSize)
+}
+
+// RankRegistrars sorts the given candidates from most to least preferred.
+// Candidates in a region, the country of their service, earlier in the given regions are preferred, then those with a lower price, then those with a lower latency.
+func RankRegistrars(candidates []*RegistrarCandidate, regions []string) {
+	rank := func(c *RegistrarCandidate) int {
+		if c.Registrar.Service != nil {
+			for i, r := range regions {
+				if r == c.Registrar.Service.Country {
+					return i
+				}
+			}
+		}
+		return len(regions)
+	}
+	latency := func(c *RegistrarCandidate) time.Duration {
+		if c.Latency < 0 {
+			return math.MaxInt64
+		}
+		return c.Latency
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if ra, rb := rank(a), rank(b); ra != rb {
+			return ra < rb
+		}
+		if pa, pb := RegistrarPrice(a.Registrar), RegistrarPrice(b.Registrar); pa != pb {
+			return pa < pb
+		}
+		if la, lb := latency(a), latency(b); la != lb {
+			return la < lb
+		}
+		return a.Registrar.Merchant.Alias < b.Registrar.Merchant.Alias
+	})
+}
+
+// SelectRegistrars returns at least the given number of registrars from the given candidates, in order of preference.
+// Registrars excluded by the policy are never selected and those pinned by it are always selected.
+// Remaining places are filled by rank, as given by RankRegistrars, favouring registrars whose country and domain differ from those already selected so that data is spread across independent hosts.
+// A nil policy pins and excludes nothing.
+func SelectRegistrars(candidates []*RegistrarCandidate, policy RegistrarPolicy, regions []string, count int) ([]*Registrar, error) {
+	var remaining []*RegistrarCandidate
+	for _, c := range candidates {
+		if c.Registrar == nil || c.Registrar.Merchant == nil {
+			continue
+		}
+		if policy != nil && policy.Exclude(c.Registrar) {
+			continue
+		}
+		remaining = append(remaining, c)
+	}
+	RankRegistrars(remaining, regions)
+
+	var selected []*Registrar
+	countries := make(map[string]bool)
+	domains := make(map[string]bool)
+	take := func(i int) {
+		r := remaining[i].Registrar
+		selected = append(selected, r)
+		if r.Service != nil {
+			countries[r.Service.Country] = true
+		}
+		domains[r.Merchant.Domain] = true
+		remaining = append(remaining[:i], remaining[i+1:]...)
+	}
+
+	// Pinned registrars first
+	if policy != nil {
+		for i := 0; i < len(remaining); {
+			if policy.Pin(remaining[i].Registrar) {
+				take(i)
+			} else {
+				i++
+			}
+		}
+	}
+
+	for len(selected) < count && len(remaining) > 0 {
+		// Favour the best ranked registrar which adds diversity
+		next := 0
+		for i, c := range remaining {
+			country := ""
+			if c.Registrar.Service != nil {
+				country = c.Registrar.Service.Country
+			}
+			if !countries[country] && !domains[c.Registrar.Merchant.Domain] {
+				next = i
+				break
+			}
+		}
+		take(next)
+	}
+
+	if len(selected) < count {
+		return nil, ErrInsufficientRegistrars{Required: count, Available: len(selected)}
+	}
+	return selected, nil
+}
+
+// SelectRegistrarsForNode returns at least MinimumRegistrars() of the registrars in the Registrar channel, chosen by SelectRegistrars, for a new customer to register with.
+// Each registrar is measured with the given measurer, or MeasureLatency if nil, and registrars which cannot be measured are ranked behind those which can, all else being equal.
+func SelectRegistrarsForNode(node bcgo.Node, policy RegistrarPolicy, regions []string, measurer LatencyMeasurer) ([]*Registrar, error) {
+	if measurer == nil {
+		measurer = MeasureLatency
+	}
+	registrars, err := LatestRegistrars(node)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []*RegistrarCandidate
+	for _, registrar := range registrars {
+		latency, err := measurer(registrar)
+		if err != nil {
+			log.Println(err)
+			latency = -1
+		}
+		candidates = append(candidates, &RegistrarCandidate{
+			Registrar: registrar,
+			Latency:   latency,
+		})
+	}
+	return SelectRegistrars(candidates, policy, regions, MinimumRegistrars())
+}
+
+// RegistrarUpdate is a record of a registrar in the Registrar channel.
+type RegistrarUpdate struct {
+	Timestamp  uint64
+	BlockHash  []byte
+	RecordHash []byte
+	Registrar  *Registrar
+}
+
+// RegistrarDirectory maps merchant aliases to the records of their registrars, and is updated incrementally from the head of the Registrar channel.
+// A client which looks up registrars repeatedly keeps a RegistrarDirectory and updates it, rather than calling LatestRegistrars or RegistrarForAlias which read the whole channel each time.
+// RegistrarDirectory is safe for concurrent use.
+type RegistrarDirectory struct {
+	lock    sync.RWMutex
+	head    []byte
+	history map[string][]*RegistrarUpdate // Merchant alias to records, oldest first
+}
+
+func NewRegistrarDirectory() *RegistrarDirectory {
+	return &RegistrarDirectory{
+		history: make(map[string][]*RegistrarUpdate),
+	}
+}
+
+// Add records the registrar in the given entry as the latest update for its merchant.
+// Entries must be added in chronological order.
+func (d *RegistrarDirectory) Add(entry *bcgo.BlockEntry, registrar *Registrar) {
+	if registrar.Merchant == nil {
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.add(newRegistrarUpdate(entry, registrar))
+}
+
+// Update adds the records written to the given Registrar channel since the last update.
+// If the channel no longer contains the head of the last update, such as after a fork is resolved, the directory is rebuilt.
+func (d *RegistrarDirectory) Update(registrars bcgo.Channel, cache bcgo.Cache, network bcgo.Network) error {
+	head := registrars.Head()
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if head == nil || bytes.Equal(head, d.head) {
+		return nil
+	}
+	// Read newest first until the last head, grouping records by block
+	var blocks [][]*RegistrarUpdate
+	found := false
+	if err := bcgo.Read(registrars.Name(), head, nil, cache, network, nil, nil, func(entry *bcgo.BlockEntry, key, data []byte) error {
+		if d.head != nil && bytes.Equal(entry.BlockHash, d.head) {
+			found = true
+			return bcgo.ErrStopIteration{}
+		}
+		// Unmarshal as Registrar
+		r := &Registrar{}
+		if err := proto.Unmarshal(data, r); err != nil {
+			return err
+		}
+		if n := len(blocks); n == 0 || !bytes.Equal(blocks[n-1][0].BlockHash, entry.BlockHash) {
+			blocks = append(blocks, nil)
+		}
+		blocks[len(blocks)-1] = append(blocks[len(blocks)-1], newRegistrarUpdate(entry, r))
+		return nil
+	}); err != nil {
+		switch err.(type) {
+		case bcgo.ErrStopIteration:
+			// Do nothing
+			break
+		default:
+			return err
+		}
+	}
+	if !found {
+		d.history = make(map[string][]*RegistrarUpdate)
+	}
+	// Add blocks oldest first
+	for i := len(blocks) - 1; i >= 0; i-- {
+		for _, u := range blocks[i] {
+			if u.Registrar.Merchant != nil {
+				d.add(u)
+			}
+		}
+	}
+	d.head = head
+	return nil
+}
+
+// Registrar returns the latest registrar of the merchant with the given alias, or nil if there is none.
+func (d *RegistrarDirectory) Registrar(alias string) *Registrar {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	h := d.history[alias]
+	if len(h) == 0 {
+		return nil
+	}
+	return h[len(h)-1].Registrar
+}
+
+// Registrars returns the latest registrar of every merchant, sorted by alias.
+func (d *RegistrarDirectory) Registrars() []*Registrar {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	var aliases []string
+	for a := range d.history {
+		aliases = append(aliases, a)
+	}
+	sort.Strings(aliases)
+	var registrars []*Registrar
+	for _, a := range aliases {
+		h := d.history[a]
+		registrars = append(registrars, h[len(h)-1].Registrar)
+	}
+	return registrars
+}
+
+// History returns the updates of the registrar of the merchant with the given alias, oldest first.
+func (d *RegistrarDirectory) History(alias string) []*RegistrarUpdate {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return append([]*RegistrarUpdate{}, d.history[alias]...)
+}
+
+func (d *RegistrarDirectory) add(update *RegistrarUpdate) {
+	alias := update.Registrar.Merchant.Alias
+	d.history[alias] = append(d.history[alias], update)
+}
+
+func newRegistrarUpdate(entry *bcgo.BlockEntry, registrar *Registrar) *RegistrarUpdate {
+	u := &RegistrarUpdate{
+		BlockHash:  entry.BlockHash,
+		RecordHash: entry.RecordHash,
+		Registrar:  registrar,
+	}
+	if entry.Record != nil {
+		u.Timestamp = entry.Record.Timestamp
+	}
+	return u
+}
+
+// LatestRegistrars returns the latest registrar of every merchant in the Registrar channel, sorted by alias.
+func LatestRegistrars(node bcgo.Node) ([]*Registrar, error) {
+	registrars := node.OpenChannel(SPACE_REGISTRAR, func() bcgo.Channel {
+		return OpenRegistrarChannel()
+	})
+	if err := registrars.Refresh(node.Cache(), node.Network()); err != nil {
+		log.Println(err)
+	}
+	d := NewRegistrarDirectory()
+	if err := d.Update(registrars, node.Cache(), node.Network()); err != nil {
+		return nil, err
+	}
+	return d.Registrars(), nil
+}
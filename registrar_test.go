@@ -0,0 +1,216 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego_test
+
+import (
+	"aletheiaware.com/bcgo"
+	"aletheiaware.com/financego"
+	"aletheiaware.com/spacego"
+	"aletheiaware.com/testinggo"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func testRegistrar(alias, domain, country string, price int64) *spacego.Registrar {
+	return &spacego.Registrar{
+		Merchant: &financego.Merchant{
+			Alias:  alias,
+			Domain: domain,
+		},
+		Service: &financego.Service{
+			Country:    country,
+			GroupPrice: price,
+			GroupSize:  1000000,
+		},
+	}
+}
+
+func TestSelectRegistrars(t *testing.T) {
+	candidates := []*spacego.RegistrarCandidate{
+		{Registrar: testRegistrar("nyc", "space-nyc.example.com", "US", 20), Latency: 10 * time.Millisecond},
+		{Registrar: testRegistrar("sfo", "space-sfo.example.com", "US", 10), Latency: 80 * time.Millisecond},
+		{Registrar: testRegistrar("lon", "space-lon.example.com", "GB", 30), Latency: 50 * time.Millisecond},
+		{Registrar: testRegistrar("man", "space-man.example.com", "GB", 30), Latency: 20 * time.Millisecond},
+		{Registrar: testRegistrar("fra", "space-fra.example.com", "DE", 10), Latency: -1},
+	}
+	for name, tt := range map[string]struct {
+		policy   spacego.RegistrarPolicy
+		regions  []string
+		count    int
+		expected []string
+		err      error
+	}{
+		"cheapest": {
+			count:    1,
+			expected: []string{"sfo"},
+		},
+		"diverse": {
+			count:    3,
+			expected: []string{"sfo", "fra", "man"},
+		},
+		"region": {
+			regions:  []string{"GB"},
+			count:    2,
+			expected: []string{"man", "sfo"},
+		},
+		"all": {
+			count:    5,
+			expected: []string{"sfo", "fra", "man", "nyc", "lon"},
+		},
+		"pinned": {
+			policy:   spacego.NewMerchantPolicy([]string{"lon"}, nil),
+			count:    2,
+			expected: []string{"lon", "sfo"},
+		},
+		"pinned_beyond_count": {
+			policy:   spacego.NewMerchantPolicy([]string{"lon", "nyc"}, nil),
+			count:    1,
+			expected: []string{"nyc", "lon"},
+		},
+		"excluded": {
+			policy:   spacego.NewMerchantPolicy(nil, []string{"sfo", "fra"}),
+			count:    2,
+			expected: []string{"nyc", "man"},
+		},
+		"insufficient": {
+			policy: spacego.NewMerchantPolicy(nil, []string{"sfo", "fra", "nyc"}),
+			count:  3,
+			err:    spacego.ErrInsufficientRegistrars{Required: 3, Available: 2},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cs := make([]*spacego.RegistrarCandidate, len(candidates))
+			copy(cs, candidates)
+			selected, err := spacego.SelectRegistrars(cs, tt.policy, tt.regions, tt.count)
+			assert.Equal(t, tt.err, err)
+			var aliases []string
+			for _, r := range selected {
+				aliases = append(aliases, r.Merchant.Alias)
+			}
+			assert.Equal(t, tt.expected, aliases)
+		})
+	}
+}
+
+func TestRegistrarDirectory(t *testing.T) {
+	d := spacego.NewRegistrarDirectory()
+	assert.Nil(t, d.Registrar("nyc"))
+	nyc1 := testRegistrar("nyc", "space-nyc.example.com", "US", 20)
+	sfo := testRegistrar("sfo", "space-sfo.example.com", "US", 10)
+	nyc2 := testRegistrar("nyc", "space-nyc.example.com", "US", 15)
+	d.Add(&bcgo.BlockEntry{BlockHash: []byte("1"), Record: &bcgo.Record{Timestamp: 1}}, nyc1)
+	d.Add(&bcgo.BlockEntry{BlockHash: []byte("1"), Record: &bcgo.Record{Timestamp: 2}}, sfo)
+	d.Add(&bcgo.BlockEntry{BlockHash: []byte("2"), Record: &bcgo.Record{Timestamp: 3}}, nyc2)
+
+	assert.Equal(t, nyc2, d.Registrar("nyc"))
+	assert.Equal(t, sfo, d.Registrar("sfo"))
+	assert.Equal(t, []*spacego.Registrar{nyc2, sfo}, d.Registrars())
+
+	history := d.History("nyc")
+	assert.Equal(t, 2, len(history))
+	assert.Equal(t, uint64(1), history[0].Timestamp)
+	assert.Equal(t, nyc1, history[0].Registrar)
+	assert.Equal(t, uint64(3), history[1].Timestamp)
+	assert.Equal(t, []byte("2"), history[1].BlockHash)
+}
+
+// writeRegistrar writes and mines the given registrar to the public Registrar channel of the given node.
+func writeRegistrar(t *testing.T, node *fakeNode, registrar *spacego.Registrar) {
+	t.Helper()
+	data, err := proto.Marshal(registrar)
+	testinggo.AssertNoError(t, err)
+	channel := node.OpenChannel(spacego.SPACE_REGISTRAR, nil)
+	_, err = node.Write(bcgo.Timestamp(), channel, nil, nil, data)
+	testinggo.AssertNoError(t, err)
+	_, _, err = node.Mine(channel, 0, nil)
+	testinggo.AssertNoError(t, err)
+}
+
+func TestRegistrarDirectory_Update(t *testing.T) {
+	cache := newFakeCache()
+	node := newFakeNode("alice", cache)
+	channel := node.OpenChannel(spacego.SPACE_REGISTRAR, nil)
+	d := spacego.NewRegistrarDirectory()
+	// Empty channel
+	testinggo.AssertNoError(t, d.Update(channel, cache, nil))
+	assert.Empty(t, d.Registrars())
+
+	nyc1 := testRegistrar("nyc", "space-nyc.example.com", "US", 20)
+	writeRegistrar(t, node, nyc1)
+	testinggo.AssertNoError(t, d.Update(channel, cache, nil))
+	assert.True(t, proto.Equal(nyc1, d.Registrar("nyc")))
+
+	// Only records written since the last update are added
+	sfo := testRegistrar("sfo", "space-sfo.example.com", "US", 10)
+	nyc2 := testRegistrar("nyc", "space-nyc.example.com", "US", 15)
+	writeRegistrar(t, node, sfo)
+	writeRegistrar(t, node, nyc2)
+	testinggo.AssertNoError(t, d.Update(channel, cache, nil))
+	testinggo.AssertNoError(t, d.Update(channel, cache, nil))
+	history := d.History("nyc")
+	assert.Equal(t, 2, len(history))
+	assert.True(t, proto.Equal(nyc1, history[0].Registrar))
+	assert.True(t, proto.Equal(nyc2, history[1].Registrar))
+	assert.True(t, proto.Equal(sfo, d.Registrar("sfo")))
+
+	t.Run("MissingHead", func(t *testing.T) {
+		// A chain which does not contain the head of the last update, such as after a fork, replaces the directory
+		forkCache := newFakeCache()
+		fork := newFakeNode("alice", forkCache)
+		nyc3 := testRegistrar("nyc", "space-nyc.example.com", "US", 5)
+		writeRegistrar(t, fork, nyc3)
+		testinggo.AssertNoError(t, d.Update(fork.OpenChannel(spacego.SPACE_REGISTRAR, nil), forkCache, nil))
+		history := d.History("nyc")
+		assert.Equal(t, 1, len(history))
+		assert.True(t, proto.Equal(nyc3, history[0].Registrar))
+		assert.Nil(t, d.Registrar("sfo"))
+	})
+}
+
+func TestRegistrarForAlias(t *testing.T) {
+	// Nodes with different caches see their own Registrar channels
+	a := newFakeNode("alice", newFakeCache())
+	b := newFakeNode("bob", newFakeCache())
+	nyc := testRegistrar("nyc", "space-nyc.example.com", "US", 20)
+	sfo := testRegistrar("sfo", "space-sfo.example.com", "US", 10)
+	writeRegistrar(t, a, nyc)
+	writeRegistrar(t, b, sfo)
+	for _, tt := range []struct {
+		node     *fakeNode
+		alias    string
+		expected *spacego.Registrar
+	}{
+		{a, "nyc", nyc},
+		{b, "sfo", sfo},
+		{a, "sfo", nil},
+		{b, "nyc", nil},
+	} {
+		registrar, err := spacego.RegistrarForAlias(tt.node.OpenChannel(spacego.SPACE_REGISTRAR, nil), tt.node.Cache(), nil, tt.alias)
+		testinggo.AssertNoError(t, err)
+		if tt.expected == nil {
+			assert.Nil(t, registrar)
+		} else {
+			assert.True(t, proto.Equal(tt.expected, registrar))
+		}
+	}
+	registrars, err := spacego.LatestRegistrars(a)
+	testinggo.AssertNoError(t, err)
+	assert.Equal(t, 1, len(registrars))
+	assert.True(t, proto.Equal(nyc, registrars[0]))
+}
@@ -0,0 +1,380 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego
+
+import (
+	"aletheiaware.com/bcgo"
+	"bytes"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"log"
+	"path"
+	"sort"
+	"strings"
+)
+
+type ErrInvalidRule struct {
+	Name string
+}
+
+func (e ErrInvalidRule) Error() string {
+	return fmt.Sprintf("Invalid rule: %s", e.Name)
+}
+
+type ErrNoSuchRule struct {
+	Name string
+}
+
+func (e ErrNoSuchRule) Error() string {
+	return fmt.Sprintf("No such rule: %s", e.Name)
+}
+
+// ValidateRule returns an error if the given rule has no name or condition, its tag is not valid according to ValidateTag, or its file pattern is malformed.
+func ValidateRule(rule *Rule) error {
+	if rule.Name == "" || (rule.MimeType == "" && rule.FilePattern == "" && rule.MinSize == 0 && rule.Content == "") {
+		return ErrInvalidRule{Name: rule.Name}
+	}
+	if err := ValidateTag(rule.Tag); err != nil {
+		return err
+	}
+	if _, err := path.Match(rule.FilePattern, ""); err != nil {
+		return err
+	}
+	return nil
+}
+
+// MatchRule returns true if the file with the given Meta and content meets every condition of the given rule.
+// Content is matched exactly, including case.
+func MatchRule(rule *Rule, meta *Meta, content []byte) bool {
+	size, read := contentFuncs(content)
+	ok, _ := matchRule(rule, meta, size, read)
+	return ok
+}
+
+// matchRule returns true if the file with the given Meta meets every condition of the given rule.
+// Conditions on the name and type are checked first, so the size and content are only computed if the rule needs them and the file meets its other conditions.
+func matchRule(rule *Rule, meta *Meta, size func() (uint64, error), content func() ([]byte, error)) (bool, error) {
+	if rule.MimeType != "" && !MatchMimeType(rule.MimeType, meta.Type) {
+		return false, nil
+	}
+	if rule.FilePattern != "" {
+		if ok, _ := path.Match(rule.FilePattern, meta.Name); !ok {
+			return false, nil
+		}
+	}
+	if rule.MinSize != 0 {
+		s, err := size()
+		if err != nil {
+			return false, err
+		}
+		if s <= rule.MinSize {
+			return false, nil
+		}
+	}
+	if rule.Content != "" {
+		c, err := content()
+		if err != nil {
+			return false, err
+		}
+		if !bytes.Contains(c, []byte(rule.Content)) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// contentFuncs returns functions for matchRule which give the size of the given content and the content itself.
+func contentFuncs(content []byte) (func() (uint64, error), func() ([]byte, error)) {
+	size := func() (uint64, error) {
+		return uint64(len(content)), nil
+	}
+	read := func() ([]byte, error) {
+		return content, nil
+	}
+	return size, read
+}
+
+// RuleReason returns the reason given for tags applied by the given rule, such as "Rule invoices: name matches *.invoice.pdf".
+func RuleReason(rule *Rule) string {
+	var conditions []string
+	if rule.MimeType != "" {
+		conditions = append(conditions, fmt.Sprintf("type matches %s", rule.MimeType))
+	}
+	if rule.FilePattern != "" {
+		conditions = append(conditions, fmt.Sprintf("name matches %s", rule.FilePattern))
+	}
+	if rule.MinSize != 0 {
+		conditions = append(conditions, fmt.Sprintf("size exceeds %d bytes", rule.MinSize))
+	}
+	if rule.Content != "" {
+		conditions = append(conditions, fmt.Sprintf("content contains %q", rule.Content))
+	}
+	return fmt.Sprintf("Rule %s: %s", rule.Name, strings.Join(conditions, " and "))
+}
+
+// EvaluateRules returns the tags applied by the given rules to the file with the given Meta and content, in rule order.
+// Directories match no rules, and when several rules apply the same tag only the first is given.
+func EvaluateRules(rules []*Rule, meta *Meta, content []byte) []*Tag {
+	size, read := contentFuncs(content)
+	tags, _ := evaluateRules(rules, meta, size, read)
+	return tags
+}
+
+func evaluateRules(rules []*Rule, meta *Meta, size func() (uint64, error), content func() ([]byte, error)) ([]*Tag, error) {
+	if meta.Type == MIME_TYPE_DIRECTORY {
+		return nil, nil
+	}
+	var tags []*Tag
+	seen := make(map[string]bool)
+	for _, r := range rules {
+		if seen[r.Tag] {
+			continue
+		}
+		ok, err := matchRule(r, meta, size, content)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		seen[r.Tag] = true
+		tags = append(tags, &Tag{
+			Value:  r.Tag,
+			Reason: RuleReason(r),
+		})
+	}
+	return tags, nil
+}
+
+// RuleSet is the rules of an alias, folded from the records of its Rule channel in chronological order.
+// A record replaces any earlier rule with the same name, and a record with Removed set is a tombstone which removes it.
+type RuleSet struct {
+	rules map[string]*Rule
+}
+
+func NewRuleSet() *RuleSet {
+	return &RuleSet{
+		rules: make(map[string]*Rule),
+	}
+}
+
+// Apply adds, replaces or removes the given rule.
+func (s *RuleSet) Apply(rule *Rule) {
+	if rule.Removed {
+		delete(s.rules, rule.Name)
+	} else {
+		s.rules[rule.Name] = rule
+	}
+}
+
+// Rules returns the rules, sorted by name.
+func (s *RuleSet) Rules() []*Rule {
+	var rules []*Rule
+	for _, r := range s.rules {
+		rules = append(rules, r)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].Name < rules[j].Name
+	})
+	return rules
+}
+
+func openRuleChannel(node bcgo.Node) bcgo.Channel {
+	alias := node.Account().Alias()
+	rules := node.OpenChannel(RuleChannelName(alias), func() bcgo.Channel {
+		return OpenRuleChannel(alias)
+	})
+	if err := rules.Refresh(node.Cache(), node.Network()); err != nil {
+		log.Println(err)
+	}
+	return rules
+}
+
+// IterateRules triggers the given callback for each record in the given Rule channel, oldest first, including removals.
+func IterateRules(node bcgo.Node, rules bcgo.Channel, callback RuleCallback) error {
+	return iterateChronologically(node, rules, func(entry *bcgo.BlockEntry, data []byte) error {
+		// Unmarshal as Rule
+		r := &Rule{}
+		if err := proto.Unmarshal(data, r); err != nil {
+			return err
+		}
+		return callback(entry, r)
+	})
+}
+
+// ReadRules returns the current rules of the node's alias, sorted by name.
+func ReadRules(node bcgo.Node) ([]*Rule, error) {
+	set := NewRuleSet()
+	if err := IterateRules(node, openRuleChannel(node), func(entry *bcgo.BlockEntry, rule *Rule) error {
+		set.Apply(rule)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return set.Rules(), nil
+}
+
+// AddRule writes the given rule to the Rule channel of the node's alias, replacing any rule with the same name.
+func AddRule(node bcgo.Node, listener bcgo.MiningListener, rule *Rule) (*bcgo.Reference, error) {
+	if err := ValidateRule(rule); err != nil {
+		return nil, err
+	}
+	r := proto.Clone(rule).(*Rule)
+	r.Removed = false
+	return write(node, listener, openRuleChannel(node), nil, r)
+}
+
+// RemoveRule writes a tombstone to the Rule channel of the node's alias which removes the rule with the given name.
+func RemoveRule(node bcgo.Node, listener bcgo.MiningListener, name string) (*bcgo.Reference, error) {
+	rules, err := ReadRules(node)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rules {
+		if r.Name == name {
+			return write(node, listener, openRuleChannel(node), nil, &Rule{
+				Name:    name,
+				Removed: true,
+			})
+		}
+	}
+	return nil, ErrNoSuchRule{Name: name}
+}
+
+// ApplyRules evaluates the rules of the node's alias against the file with the given metaId, such as after it is created or updated, and writes the tags it does not already have.
+// The tags written are returned.
+func ApplyRules(node bcgo.Node, listener bcgo.MiningListener, metaId string) ([]*Tag, error) {
+	rules, err := ReadRules(node)
+	if err != nil {
+		return nil, err
+	}
+	_, h, err := openMetaHierarchy(node)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := newRuleTags(node, rules, metaId, h.Meta(metaId))
+	if err != nil || len(tags) == 0 {
+		return nil, err
+	}
+	access, err := h.Access(node.Account(), metaId)
+	if err != nil {
+		return nil, err
+	}
+	channel := openTagChannel(node, metaId)
+	for _, t := range tags {
+		data, err := proto.Marshal(t)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := node.Write(bcgo.Timestamp(), channel, access, nil, data); err != nil {
+			return nil, err
+		}
+	}
+	if _, _, err := node.Mine(channel, Threshold(channel.Name()), listener); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// DryRunRules evaluates the given rules, or those of the node's alias if nil, against every file not in the trash, without writing anything.
+// The given callback is triggered, in path order, for each file which would gain tags, with the tags it does not already have.
+func DryRunRules(node bcgo.Node, rules []*Rule, callback func(string, *Meta, []*Tag) error) error {
+	if rules == nil {
+		var err error
+		if rules, err = ReadRules(node); err != nil {
+			return err
+		}
+	}
+	_, h, err := openMetaHierarchy(node)
+	if err != nil {
+		return err
+	}
+	for _, id := range h.Files() {
+		meta := h.Meta(id)
+		tags, err := newRuleTags(node, rules, id, meta)
+		if err != nil {
+			return err
+		}
+		if len(tags) == 0 {
+			continue
+		}
+		if err := callback(id, meta, tags); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newRuleTags returns the tags applied by the given rules to the file with the given metaId which it does not already have.
+// A tag applied by a rule and since removed is not applied again, so the removal sticks until the tag is added by other means.
+func newRuleTags(node bcgo.Node, rules []*Rule, metaId string, meta *Meta) ([]*Tag, error) {
+	if meta == nil || meta.Purged {
+		return nil, ErrNoSuchMeta{MetaId: metaId}
+	}
+	if len(rules) == 0 || meta.Type == MIME_TYPE_DIRECTORY {
+		return nil, nil
+	}
+	// Only read content if a rule needs it, and only compute the size if the content has not been read
+	var (
+		size    *uint64
+		content []byte
+		read    bool
+	)
+	sizeFunc := func() (uint64, error) {
+		if read {
+			return uint64(len(content)), nil
+		}
+		if size == nil {
+			s, err := contentSize(node, metaId)
+			if err != nil {
+				return 0, err
+			}
+			size = &s
+		}
+		return *size, nil
+	}
+	contentFunc := func() ([]byte, error) {
+		if !read {
+			c, err := readContent(node, metaId)
+			if err != nil {
+				return nil, err
+			}
+			content, read = c, true
+		}
+		return content, nil
+	}
+	matches, err := evaluateRules(rules, meta, sizeFunc, contentFunc)
+	if err != nil {
+		return nil, err
+	}
+	set, err := ReadTagSet(node, metaId)
+	if err != nil {
+		return nil, err
+	}
+	var tags []*Tag
+	for _, t := range matches {
+		if set.Has(t.Value) {
+			continue
+		}
+		if r, ok := set.removed[t.Value]; ok && r.Reason == t.Reason {
+			// Removed after the rule applied it
+			continue
+		}
+		tags = append(tags, t)
+	}
+	return tags, nil
+}
@@ -0,0 +1,228 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego_test
+
+import (
+	"aletheiaware.com/spacego"
+	"aletheiaware.com/testinggo"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRuleSet(t *testing.T) {
+	for name, tt := range map[string]struct {
+		records  []*spacego.Rule
+		expected []string
+	}{
+		"empty": {},
+		"added": {
+			records: []*spacego.Rule{
+				{Name: "pdfs", Tag: "document"},
+				{Name: "images", Tag: "photo"},
+			},
+			expected: []string{"images", "pdfs"},
+		},
+		"replaced": {
+			records: []*spacego.Rule{
+				{Name: "pdfs", Tag: "document"},
+				{Name: "pdfs", Tag: "paper"},
+			},
+			expected: []string{"pdfs"},
+		},
+		"removed": {
+			records: []*spacego.Rule{
+				{Name: "pdfs", Tag: "document"},
+				{Name: "images", Tag: "photo"},
+				{Name: "pdfs", Removed: true},
+			},
+			expected: []string{"images"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			set := spacego.NewRuleSet()
+			for _, r := range tt.records {
+				set.Apply(r)
+			}
+			var names []string
+			for _, r := range set.Rules() {
+				names = append(names, r.Name)
+			}
+			assert.Equal(t, tt.expected, names)
+		})
+	}
+}
+
+func TestValidateRule(t *testing.T) {
+	for name, tt := range map[string]struct {
+		rule  *spacego.Rule
+		valid bool
+	}{
+		"valid": {
+			rule:  &spacego.Rule{Name: "pdfs", Tag: "document", MimeType: "application/pdf"},
+			valid: true,
+		},
+		"no_name": {
+			rule: &spacego.Rule{Tag: "document", MimeType: "application/pdf"},
+		},
+		"no_condition": {
+			rule: &spacego.Rule{Name: "pdfs", Tag: "document"},
+		},
+		"invalid_tag": {
+			rule: &spacego.Rule{Name: "pdfs", Tag: "document/", MimeType: "application/pdf"},
+		},
+		"invalid_pattern": {
+			rule: &spacego.Rule{Name: "pdfs", Tag: "document", FilePattern: "["},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := spacego.ValidateRule(tt.rule)
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestEvaluateRules(t *testing.T) {
+	rules := []*spacego.Rule{
+		{Name: "images", Tag: "photo", MimeType: "image/*"},
+		{Name: "invoices", Tag: "finance", FilePattern: "*.pdf", Content: "Invoice"},
+		{Name: "large", Tag: "large", MinSize: 8},
+		{Name: "receipts", Tag: "finance", Content: "Receipt"},
+	}
+	for name, tt := range map[string]struct {
+		meta     *spacego.Meta
+		content  string
+		expected []*spacego.Tag
+	}{
+		"none": {
+			meta:    &spacego.Meta{Name: "notes.txt", Type: "text/plain"},
+			content: "Hello",
+		},
+		"type": {
+			meta: &spacego.Meta{Name: "cat.png", Type: "image/png"},
+			expected: []*spacego.Tag{
+				{Value: "photo", Reason: "Rule images: type matches image/*"},
+			},
+		},
+		"all_conditions": {
+			meta:    &spacego.Meta{Name: "march.pdf", Type: "application/pdf"},
+			content: "Invoice",
+			expected: []*spacego.Tag{
+				{Value: "finance", Reason: "Rule invoices: name matches *.pdf and content contains \"Invoice\""},
+			},
+		},
+		"case_sensitive": {
+			meta:    &spacego.Meta{Name: "march.pdf", Type: "application/pdf"},
+			content: "invoice",
+		},
+		"size": {
+			meta:    &spacego.Meta{Name: "notes.txt", Type: "text/plain"},
+			content: "Hello World",
+			expected: []*spacego.Tag{
+				{Value: "large", Reason: "Rule large: size exceeds 8 bytes"},
+			},
+		},
+		"duplicate_tag": {
+			meta:    &spacego.Meta{Name: "march.pdf", Type: "application/pdf"},
+			content: "Invoice Receipt",
+			expected: []*spacego.Tag{
+				{Value: "finance", Reason: "Rule invoices: name matches *.pdf and content contains \"Invoice\""},
+				{Value: "large", Reason: "Rule large: size exceeds 8 bytes"},
+			},
+		},
+		"directory": {
+			meta: &spacego.Meta{Name: "photos", Type: spacego.MIME_TYPE_DIRECTORY},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, spacego.EvaluateRules(rules, tt.meta, []byte(tt.content)))
+		})
+	}
+}
+
+func TestDryRunRules(t *testing.T) {
+	cache := newFakeCache()
+	node := newFakeNode("alice", cache)
+	writeMeta := func(name, mime string) string {
+		data, err := proto.Marshal(&spacego.Meta{Name: name, Type: mime})
+		testinggo.AssertNoError(t, err)
+		return spacego.MetaId(writeRecord(t, node, spacego.MetaChannelName("alice"), nil, data).RecordHash)
+	}
+	notes := writeTextFile(t, node, "notes.txt")
+	appendText(t, node, notes, 0, "Hello World Wide")
+	// Delete " World", so the size is 10
+	data, err := proto.Marshal(&spacego.Delta{Offset: 5, Delete: 6})
+	testinggo.AssertNoError(t, err)
+	writeRecord(t, node, spacego.DeltaChannelName(notes), nil, data)
+	short := writeTextFile(t, node, "short.txt")
+	appendText(t, node, short, 0, "Hi")
+	invoice := writeMeta("march.pdf", spacego.MIME_TYPE_PDF)
+	appendText(t, node, invoice, 0, "Invoice")
+	// The content of the photo is missing, so reading it would fail
+	photo := writeMeta("cat.png", spacego.MIME_TYPE_IMAGE_PNG)
+	appendText(t, node, photo, 0, "Invoice")
+	testinggo.AssertNoError(t, cache.RemoveBlock(node.OpenChannel(spacego.DeltaChannelName(photo), nil).Head()))
+
+	rules := []*spacego.Rule{
+		{Name: "large", Tag: "large", MimeType: "text/*", MinSize: 8},
+		{Name: "invoices", Tag: "finance", FilePattern: "*.pdf", Content: "Invoice"},
+	}
+	results := make(map[string][]string)
+	testinggo.AssertNoError(t, spacego.DryRunRules(node, rules, func(id string, meta *spacego.Meta, tags []*spacego.Tag) error {
+		for _, tag := range tags {
+			results[id] = append(results[id], tag.Value)
+		}
+		return nil
+	}))
+	assert.Equal(t, map[string][]string{
+		notes:   {"large"},
+		invoice: {"finance"},
+	}, results)
+
+	// Rules which need the content of the photo fail
+	err = spacego.DryRunRules(node, []*spacego.Rule{
+		{Name: "large", Tag: "large", MinSize: 8},
+	}, func(string, *spacego.Meta, []*spacego.Tag) error {
+		return nil
+	})
+	assert.Error(t, err)
+}
+
+func TestApplyRules_Removed(t *testing.T) {
+	node := newFakeNode("alice", newFakeCache())
+	metaId := writeTextFile(t, node, "notes.txt")
+	appendText(t, node, metaId, 0, "Hello World")
+	_, err := spacego.AddRule(node, nil, &spacego.Rule{Name: "notes", Tag: "notes", FilePattern: "*.txt"})
+	testinggo.AssertNoError(t, err)
+
+	tags, err := spacego.ApplyRules(node, nil, metaId)
+	testinggo.AssertNoError(t, err)
+	assert.Equal(t, 1, len(tags))
+	assert.Equal(t, []string{"notes"}, tagValues(t, node, metaId))
+
+	// Removing the tag applied by the rule sticks
+	_, err = spacego.RemoveTag(node, nil, readHierarchy(t, node), metaId, "notes", "Not notes")
+	testinggo.AssertNoError(t, err)
+	tags, err = spacego.ApplyRules(node, nil, metaId)
+	testinggo.AssertNoError(t, err)
+	assert.Empty(t, tags)
+	assert.Empty(t, tagValues(t, node, metaId))
+}
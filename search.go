@@ -0,0 +1,401 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego
+
+import (
+	"aletheiaware.com/bcgo"
+	"aletheiaware.com/cryptogo"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"github.com/golang/protobuf/proto"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+var ErrNoSearchIndexAccess = errors.New("No access to search index")
+
+// SearchResult is a file matching a search, with a higher score for a better match.
+type SearchResult struct {
+	MetaId string
+	Score  float64
+}
+
+// SearchIndex is an inverted index over the content of text files, built from their deltas.
+// Deltas are counted per file so that reading a Delta channel again only applies deltas not already indexed.
+// SearchIndex is safe for concurrent use.
+type SearchIndex struct {
+	lock      sync.Mutex
+	documents map[string]*searchDocument
+	terms     map[string]map[string]bool // Term to metaIds containing it
+}
+
+type searchDocument struct {
+	Content   []byte
+	Deltas    uint64
+	dirty     bool
+	positions map[string][]int // Term to positions in content
+}
+
+func NewSearchIndex() *SearchIndex {
+	return &SearchIndex{
+		documents: make(map[string]*searchDocument),
+		terms:     make(map[string]map[string]bool),
+	}
+}
+
+// IsSearchable returns true if the content of the file with the given Meta can be indexed.
+func IsSearchable(meta *Meta) bool {
+	return MatchMimeType("text/*", meta.Type)
+}
+
+// DeltaCallback returns a DeltaCallback for IterateDeltas which indexes each delta of the file with the given metaId before triggering the given callback, if any.
+func (i *SearchIndex) DeltaCallback(metaId string, callback DeltaCallback) DeltaCallback {
+	var count uint64
+	return func(entry *bcgo.BlockEntry, delta *Delta) error {
+		i.lock.Lock()
+		d := i.document(metaId)
+		if count >= d.Deltas {
+			d.apply(delta)
+		}
+		count++
+		i.lock.Unlock()
+		if callback != nil {
+			return callback(entry, delta)
+		}
+		return nil
+	}
+}
+
+// CreateDeltasCallback returns a callback for CreateDeltas which triggers the given callback, if any, and then indexes each delta of the file with the given metaId.
+func (i *SearchIndex) CreateDeltasCallback(metaId string, callback func(*Delta) error) func(*Delta) error {
+	return func(delta *Delta) error {
+		if callback != nil {
+			if err := callback(delta); err != nil {
+				return err
+			}
+		}
+		i.lock.Lock()
+		i.document(metaId).apply(delta)
+		i.lock.Unlock()
+		return nil
+	}
+}
+
+// Update indexes any deltas in the Delta channel of the file with the given metaId which have not already been indexed.
+func (i *SearchIndex) Update(node bcgo.Node, metaId string) error {
+	deltas := node.OpenChannel(DeltaChannelName(metaId), func() bcgo.Channel {
+		return OpenDeltaChannel(metaId)
+	})
+	if err := deltas.Refresh(node.Cache(), node.Network()); err != nil {
+		log.Println(err)
+	}
+	return IterateDeltas(node, deltas, i.DeltaCallback(metaId, nil))
+}
+
+// Remove drops the file with the given metaId from the index.
+func (i *SearchIndex) Remove(metaId string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if d, ok := i.documents[metaId]; ok {
+		i.unindex(metaId, d)
+		delete(i.documents, metaId)
+	}
+}
+
+// Search returns the files matching every word in the given query, best match first.
+// Words in double quotes must appear together as a phrase, and words ending in '*' match any word with that prefix.
+func (i *SearchIndex) Search(query string) []*SearchResult {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.reindex()
+	clauses := parseSearchQuery(query)
+	if len(clauses) == 0 {
+		return nil
+	}
+	scores := make(map[string]float64)
+	for n, c := range clauses {
+		matches := i.match(c)
+		if n == 0 {
+			scores = matches
+			continue
+		}
+		for id, score := range scores {
+			if s, ok := matches[id]; ok {
+				scores[id] = score + s
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+	var results []*SearchResult
+	for id, score := range scores {
+		results = append(results, &SearchResult{
+			MetaId: id,
+			Score:  score,
+		})
+	}
+	sort.Slice(results, func(a, b int) bool {
+		if results[a].Score == results[b].Score {
+			return results[a].MetaId < results[b].MetaId
+		}
+		return results[a].Score > results[b].Score
+	})
+	return results
+}
+
+// Save writes the index to the given writer, encrypted for the given account.
+func (i *SearchIndex) Save(account bcgo.Account, writer io.Writer) error {
+	var buffer bytes.Buffer
+	i.lock.Lock()
+	err := gob.NewEncoder(&buffer).Encode(i.documents)
+	i.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeIndex(account, writer, buffer.Bytes())
+}
+
+// LoadSearchIndex reads an index written by Save from the given reader, decrypting it with the given account.
+func LoadSearchIndex(account bcgo.Account, reader io.Reader) (*SearchIndex, error) {
+	payload, err := readIndex(account, reader)
+	if err != nil {
+		return nil, err
+	}
+	if payload == nil {
+		return nil, ErrNoSearchIndexAccess
+	}
+	i := NewSearchIndex()
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&i.documents); err != nil {
+		return nil, err
+	}
+	for _, d := range i.documents {
+		d.dirty = true
+	}
+	return i, nil
+}
+
+// writeIndex writes the given data to the given writer as a record encrypted for the given account.
+func writeIndex(account bcgo.Account, writer io.Writer, data []byte) error {
+	key, err := cryptogo.GenerateRandomKey()
+	if err != nil {
+		return err
+	}
+	payload, err := cryptogo.EncryptAESGCM(key, data)
+	if err != nil {
+		return err
+	}
+	secret, algorithm, err := account.EncryptKey(key)
+	if err != nil {
+		return err
+	}
+	record, err := proto.Marshal(&bcgo.Record{
+		Timestamp: bcgo.Timestamp(),
+		Creator:   account.Alias(),
+		Access: []*bcgo.Record_Access{
+			&bcgo.Record_Access{
+				Alias:               account.Alias(),
+				SecretKey:           secret,
+				EncryptionAlgorithm: algorithm,
+			},
+		},
+		Payload:             payload,
+		EncryptionAlgorithm: cryptogo.EncryptionAlgorithm_AES_256_GCM_NOPADDING,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(record)
+	return err
+}
+
+// readIndex reads a record written by writeIndex from the given reader and returns its data decrypted with the given account, or nil if the account has no access.
+func readIndex(account bcgo.Account, reader io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	record := &bcgo.Record{}
+	if err := proto.Unmarshal(data, record); err != nil {
+		return nil, err
+	}
+	alias := account.Alias()
+	for _, access := range record.Access {
+		if alias != access.Alias {
+			continue
+		}
+		key, err := account.DecryptKey(access.EncryptionAlgorithm, access.SecretKey)
+		if err != nil {
+			return nil, err
+		}
+		return account.Decrypt(record.EncryptionAlgorithm, record.Payload, key)
+	}
+	return nil, nil
+}
+
+func (i *SearchIndex) document(metaId string) *searchDocument {
+	d, ok := i.documents[metaId]
+	if !ok {
+		d = &searchDocument{}
+		i.documents[metaId] = d
+	}
+	return d
+}
+
+func (d *searchDocument) apply(delta *Delta) {
+	d.Content = ApplyDelta(delta, d.Content)
+	d.Deltas++
+	d.dirty = true
+}
+
+// reindex updates the terms of every document changed since the last search.
+func (i *SearchIndex) reindex() {
+	for id, d := range i.documents {
+		if !d.dirty {
+			continue
+		}
+		i.unindex(id, d)
+		d.positions = make(map[string][]int)
+		for n, t := range tokenize(string(d.Content)) {
+			d.positions[t] = append(d.positions[t], n)
+		}
+		for t := range d.positions {
+			ids, ok := i.terms[t]
+			if !ok {
+				ids = make(map[string]bool)
+				i.terms[t] = ids
+			}
+			ids[id] = true
+		}
+		d.dirty = false
+	}
+}
+
+func (i *SearchIndex) unindex(metaId string, d *searchDocument) {
+	for t := range d.positions {
+		if ids, ok := i.terms[t]; ok {
+			delete(ids, metaId)
+			if len(ids) == 0 {
+				delete(i.terms, t)
+			}
+		}
+	}
+}
+
+// idf returns the inverse document frequency of the given term.
+func (i *SearchIndex) idf(term string) float64 {
+	return math.Log(1 + float64(len(i.documents))/float64(len(i.terms[term])))
+}
+
+// match returns the score of each document matching the given clause.
+func (i *SearchIndex) match(c *searchClause) map[string]float64 {
+	scores := make(map[string]float64)
+	switch {
+	case c.prefix:
+		for t, ids := range i.terms {
+			if !strings.HasPrefix(t, c.words[0]) {
+				continue
+			}
+			idf := i.idf(t)
+			for id := range ids {
+				scores[id] += float64(len(i.documents[id].positions[t])) * idf
+			}
+		}
+	default:
+		var idf float64
+		for _, w := range c.words {
+			if _, ok := i.terms[w]; !ok {
+				return scores
+			}
+			idf += i.idf(w)
+		}
+		for id := range i.terms[c.words[0]] {
+			if count := i.documents[id].phrases(c.words); count > 0 {
+				scores[id] = float64(count) * idf
+			}
+		}
+	}
+	return scores
+}
+
+// phrases returns the number of times the given words appear consecutively in the document.
+func (d *searchDocument) phrases(words []string) int {
+	count := 0
+	for _, start := range d.positions[words[0]] {
+		found := true
+		for n, w := range words[1:] {
+			if !containsPosition(d.positions[w], start+n+1) {
+				found = false
+				break
+			}
+		}
+		if found {
+			count++
+		}
+	}
+	return count
+}
+
+func containsPosition(positions []int, position int) bool {
+	n := sort.SearchInts(positions, position)
+	return n < len(positions) && positions[n] == position
+}
+
+type searchClause struct {
+	words  []string
+	prefix bool
+}
+
+func parseSearchQuery(query string) []*searchClause {
+	var clauses []*searchClause
+	for n, part := range strings.Split(query, "\"") {
+		if n%2 == 1 {
+			// Quoted phrase
+			if words := tokenize(part); len(words) > 0 {
+				clauses = append(clauses, &searchClause{
+					words: words,
+				})
+			}
+			continue
+		}
+		for _, field := range strings.Fields(part) {
+			words := tokenize(field)
+			for _, w := range words {
+				clauses = append(clauses, &searchClause{
+					words: []string{w},
+				})
+			}
+			if len(words) > 0 && strings.HasSuffix(field, "*") {
+				clauses[len(clauses)-1].prefix = true
+			}
+		}
+	}
+	return clauses
+}
+
+// tokenize splits the given text into lower case words of letters and digits.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
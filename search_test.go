@@ -0,0 +1,112 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego_test
+
+import (
+	"aletheiaware.com/spacego"
+	"aletheiaware.com/testinggo"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func testSearchIndex(t *testing.T, documents map[string]string) *spacego.SearchIndex {
+	t.Helper()
+	index := spacego.NewSearchIndex()
+	for id, content := range documents {
+		testinggo.AssertNoError(t, spacego.CreateDeltas(strings.NewReader(content), 8, index.CreateDeltasCallback(id, nil)))
+	}
+	return index
+}
+
+func resultIds(results []*spacego.SearchResult) []string {
+	var ids []string
+	for _, r := range results {
+		ids = append(ids, r.MetaId)
+	}
+	return ids
+}
+
+func TestSearchIndex(t *testing.T) {
+	index := testSearchIndex(t, map[string]string{
+		"a": "The quick brown fox jumps over the lazy dog.",
+		"b": "A quick brown fox; a quick, brown fox.",
+		"c": "Brown bread is not quick.",
+	})
+	for name, tt := range map[string]struct {
+		query    string
+		expected []string
+	}{
+		"empty": {},
+		"word": {
+			query:    "FOX",
+			expected: []string{"b", "a"},
+		},
+		"words": {
+			query:    "quick bread",
+			expected: []string{"c"},
+		},
+		"phrase": {
+			query:    `"brown fox"`,
+			expected: []string{"b", "a"},
+		},
+		"phrase_order": {
+			query: `"fox brown"`,
+		},
+		"prefix": {
+			query:    "bre*",
+			expected: []string{"c"},
+		},
+		"prefix_and_phrase": {
+			query:    `ju* "lazy dog"`,
+			expected: []string{"a"},
+		},
+		"missing": {
+			query: "cat",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, resultIds(index.Search(tt.query)))
+		})
+	}
+}
+
+func TestSearchIndexIncremental(t *testing.T) {
+	index := testSearchIndex(t, map[string]string{
+		"a": "hello world",
+	})
+	callback := index.DeltaCallback("a", nil)
+	// Deltas already indexed when written are skipped when read
+	testinggo.AssertNoError(t, callback(nil, &spacego.Delta{
+		Insert: []byte("hello wo"),
+	}))
+	testinggo.AssertNoError(t, callback(nil, &spacego.Delta{
+		Offset: 8,
+		Insert: []byte("rld"),
+	}))
+	// New deltas are applied
+	testinggo.AssertNoError(t, callback(nil, &spacego.Delta{
+		Offset: 6,
+		Delete: 5,
+		Insert: []byte("there"),
+	}))
+	assert.Empty(t, index.Search("world"))
+	assert.Equal(t, []string{"a"}, resultIds(index.Search(`"hello there"`)))
+
+	index.Remove("a")
+	assert.Empty(t, index.Search("hello"))
+}
@@ -21,6 +21,8 @@ import (
 	"aletheiaware.com/bcgo/channel"
 	"aletheiaware.com/bcgo/validation"
 	"aletheiaware.com/financego"
+	"bytes"
+	"encoding/base64"
 	"github.com/golang/protobuf/proto"
 	"io"
 	"log"
@@ -41,11 +43,12 @@ const (
 	SPACE_PREFIX_DELTA      = "Space-Delta-"
 	SPACE_PREFIX_META       = "Space-Meta-"
 	SPACE_PREFIX_PREVIEW    = "Space-Preview-"
+	SPACE_PREFIX_RULE       = "Space-Rule-"
 	SPACE_PREFIX_TAG        = "Space-Tag-"
 	SPACE_PREFIX_VALIDATION = "Space-Validation-"
 
 	THRESHOLD_ACCOUNTING = bcgo.THRESHOLD_G // Charge, Invoice, Registrar, Registration, Subscription, Usage Record Channels
-	THRESHOLD_CUSTOMER   = bcgo.THRESHOLD_Z // Delta, Meta, Preview, Tag Channels
+	THRESHOLD_CUSTOMER   = bcgo.THRESHOLD_Z // Delta, Meta, Preview, Rule, Tag Channels
 	THRESHOLD_VALIDATION = validation.THRESHOLD_PERIOD_DAY
 
 	PERIOD_VALIDATION = validation.PERIOD_DAILY
@@ -61,6 +64,7 @@ const (
 	MIME_TYPE_PROTOBUF   = "application/x-protobuf"
 	MIME_TYPE_VIDEO_MPEG = "video/mpeg"
 	MIME_TYPE_AUDIO_MPEG = "audio/mpeg"
+	MIME_TYPE_DIRECTORY  = "inode/directory"
 
 	MIME_TYPE_IMAGE_DEFAULT = "image/jpeg"
 	MIME_TYPE_VIDEO_DEFAULT = "video/mpeg"
@@ -80,20 +84,21 @@ type PreviewCallback func(*bcgo.BlockEntry, *Preview) error
 
 type RegistrarCallback func(*bcgo.BlockEntry, *Registrar) error
 
+type RuleCallback func(*bcgo.BlockEntry, *Rule) error
+
 type TagCallback func(*bcgo.BlockEntry, *Tag) error
 
+// SpaceHosts returns the default hosts of the live or test network, according to bcgo.IsLive().
+//
+// Deprecated: Use ProfileHosts, which also finds hosts configured by environment variable or config file, and reports misconfigured hosts as an error.
 func SpaceHosts() []string {
 	if bcgo.IsLive() {
-		return []string{
-			"space-nyc.aletheiaware.com",
-			"space-sfo.aletheiaware.com",
-		}
-	}
-	return []string{
-		"test-space.aletheiaware.com",
+		return DefaultHosts(SPACE_PROFILE_LIVE)
 	}
+	return DefaultHosts(SPACE_PROFILE_TEST)
 }
 
+// MimeTypes returns the sorted list of known MIME types, including any registered for detection.
 func MimeTypes() []string {
 	mimes := []string{
 		MIME_TYPE_IMAGE_JPEG,
@@ -107,10 +112,27 @@ func MimeTypes() []string {
 		MIME_TYPE_VIDEO_MPEG,
 		MIME_TYPE_AUDIO_MPEG,
 	}
+	for _, m := range registeredMimeTypes() {
+		found := false
+		for _, n := range mimes {
+			if m == n {
+				found = true
+				break
+			}
+		}
+		if !found {
+			mimes = append(mimes, m)
+		}
+	}
 	sort.Strings(mimes)
 	return mimes
 }
 
+// MetaId returns the identifier of the Meta held in the record with the given hash.
+func MetaId(recordHash []byte) string {
+	return base64.RawURLEncoding.EncodeToString(recordHash)
+}
+
 func Validator(node bcgo.Node, channel bcgo.Channel, listener bcgo.MiningListener) validation.Periodic {
 	return validation.NewDaily(node, channel, listener)
 }
@@ -122,6 +144,27 @@ func openChannel(name string, threshold uint64) bcgo.Channel {
 	return c
 }
 
+// write encrypts the given message for the node's account, writes it to the given channel with the given references, and mines the channel.
+func write(node bcgo.Node, listener bcgo.MiningListener, channel bcgo.Channel, references []*bcgo.Reference, message proto.Message) (*bcgo.Reference, error) {
+	return writeAccess(node, listener, channel, []bcgo.Identity{node.Account()}, references, message)
+}
+
+// writeAccess encrypts the given message for the given identities, writes it to the given channel with the given references, and mines the channel.
+func writeAccess(node bcgo.Node, listener bcgo.MiningListener, channel bcgo.Channel, access []bcgo.Identity, references []*bcgo.Reference, message proto.Message) (*bcgo.Reference, error) {
+	data, err := proto.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	reference, err := node.Write(bcgo.Timestamp(), channel, access, references, data)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := node.Mine(channel, Threshold(channel.Name()), listener); err != nil {
+		return nil, err
+	}
+	return reference, nil
+}
+
 /* TODO
 func openCustomerChannel(customer, name string, threshold uint64) bcgo.Channel {
 	c := openChannel(name, threshold)
@@ -168,6 +211,10 @@ func PreviewChannelName(metaId string) string {
 	return SPACE_PREFIX_PREVIEW + metaId
 }
 
+func RuleChannelName(alias string) string {
+	return SPACE_PREFIX_RULE + alias
+}
+
 func TagChannelName(metaId string) string {
 	return SPACE_PREFIX_TAG + metaId
 }
@@ -191,6 +238,11 @@ func OpenPreviewChannel(metaId string) bcgo.Channel {
 	return openChannel(PreviewChannelName(metaId), THRESHOLD_CUSTOMER)
 }
 
+func OpenRuleChannel(alias string) bcgo.Channel {
+	// TODO return openCustomerChannel(alias, RuleChannelName(alias), THRESHOLD_CUSTOMER)
+	return openChannel(RuleChannelName(alias), THRESHOLD_CUSTOMER)
+}
+
 func OpenTagChannel(metaId string) bcgo.Channel {
 	// TODO return openCustomerChannel(alias, TagChannelName(metaId), THRESHOLD_CUSTOMER)
 	return openChannel(TagChannelName(metaId), THRESHOLD_CUSTOMER)
@@ -258,6 +310,7 @@ func Threshold(channel string) uint64 {
 		case strings.HasPrefix(channel, SPACE_PREFIX_DELTA),
 			strings.HasPrefix(channel, SPACE_PREFIX_META),
 			strings.HasPrefix(channel, SPACE_PREFIX_PREVIEW),
+			strings.HasPrefix(channel, SPACE_PREFIX_RULE),
 			strings.HasPrefix(channel, SPACE_PREFIX_TAG):
 			return THRESHOLD_CUSTOMER
 		default:
@@ -266,30 +319,13 @@ func Threshold(channel string) uint64 {
 	}
 }
 
+// RegistrarForAlias returns the latest registrar of the merchant with the given alias, or nil if there is none.
 func RegistrarForAlias(registrars bcgo.Channel, cache bcgo.Cache, network bcgo.Network, alias string) (*Registrar, error) {
-	var registrar *Registrar
-	if err := bcgo.Read(registrars.Name(), registrars.Head(), nil, cache, network, nil, nil, func(entry *bcgo.BlockEntry, key, data []byte) error {
-		// Unmarshal as Registrar
-		r := &Registrar{}
-		err := proto.Unmarshal(data, r)
-		if err != nil {
-			return err
-		}
-		if r.Merchant.Alias == alias {
-			registrar = r
-			return bcgo.ErrStopIteration{}
-		}
-		return nil
-	}); err != nil {
-		switch err.(type) {
-		case bcgo.ErrStopIteration:
-			// Do nothing
-			break
-		default:
-			return nil, err
-		}
+	d := NewRegistrarDirectory()
+	if err := d.Update(registrars, cache, network); err != nil {
+		return nil, err
 	}
-	return registrar, nil
+	return d.Registrar(alias), nil
 }
 
 // AllRegistrars triggers the given callback for each registrar.
@@ -315,12 +351,13 @@ func AllRegistrars(node bcgo.Node, callback RegistrarCallback) error {
 func AllRegistrarsForNode(node bcgo.Node, callback func(*Registrar, *financego.Registration, *financego.Subscription) error) error {
 	// Get registrars
 	as := make(map[string]*Registrar)
-	if err := AllRegistrars(node, func(e *bcgo.BlockEntry, r *Registrar) error {
-		as[r.Merchant.Alias] = r
-		return nil
-	}); err != nil {
+	latest, err := LatestRegistrars(node)
+	if err != nil {
 		return err
 	}
+	for _, r := range latest {
+		as[r.Merchant.Alias] = r
+	}
 	// Get registrations
 	rs := make(map[string]*financego.Registration)
 	if err := AllRegistrationsForNode(node, func(e *bcgo.BlockEntry, r *financego.Registration) error {
@@ -376,6 +413,24 @@ func AllRegistrationsForNode(node bcgo.Node, callback financego.RegistrationCall
 	})
 }
 
+// AllSubscriptions triggers the given callback for each subscription the node can read, such as those of a registrar's customers.
+func AllSubscriptions(node bcgo.Node, callback financego.SubscriptionCallback) error {
+	subscriptions := node.OpenChannel(SPACE_SUBSCRIPTION, func() bcgo.Channel {
+		return OpenSubscriptionChannel()
+	})
+	if err := subscriptions.Refresh(node.Cache(), node.Network()); err != nil {
+		log.Println(err)
+	}
+	return bcgo.Read(subscriptions.Name(), subscriptions.Head(), nil, node.Cache(), node.Network(), node.Account(), nil, func(entry *bcgo.BlockEntry, key, data []byte) error {
+		// Unmarshal as Subscription
+		s := &financego.Subscription{}
+		if err := proto.Unmarshal(data, s); err != nil {
+			return err
+		}
+		return callback(entry, s)
+	})
+}
+
 // AllSubscriptionsForNode triggers the given callback for each subscription.
 func AllSubscriptionsForNode(node bcgo.Node, callback financego.SubscriptionCallback) error {
 	subscriptions := node.OpenChannel(SPACE_SUBSCRIPTION, func() bcgo.Channel {
@@ -411,7 +466,40 @@ func ReadDelta(deltas bcgo.Channel, cache bcgo.Cache, network bcgo.Network, acco
 	})
 }
 
+// ReadMeta triggers the given callback for the latest Meta record of each file in the given channel, or only the record with the given hash.
+// Renaming or moving a file adds a new record for the same file, use MetaOrigin to find the file a record belongs to, and ReadMetaHistory to read earlier records.
+// Records of files in the trash, or in a directory in the trash, are skipped unless the record hash is given.
+// Unless the record hash is given the whole channel is read before the callback is first triggered, so returning ErrStopIteration from the callback stops the callbacks but not the read.
 func ReadMeta(metas bcgo.Channel, cache bcgo.Cache, network bcgo.Network, account bcgo.Account, recordHash []byte, callback MetaCallback) error {
+	if recordHash != nil {
+		return readMeta(metas, cache, network, account, recordHash, callback)
+	}
+	type record struct {
+		entry *bcgo.BlockEntry
+		meta  *Meta
+	}
+	var records []*record
+	h := NewHierarchy(metas.Name())
+	if err := readMeta(metas, cache, network, account, nil, func(entry *bcgo.BlockEntry, meta *Meta) error {
+		h.Add(entry, meta)
+		records = append(records, &record{entry, meta})
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, r := range records {
+		metaId := MetaId(MetaOrigin(metas.Name(), r.entry).RecordHash)
+		if !bytes.Equal(h.files[metaId].latest().RecordHash, r.entry.RecordHash) || h.IsDeleted(metaId) {
+			continue
+		}
+		if err := callback(r.entry, r.meta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readMeta(metas bcgo.Channel, cache bcgo.Cache, network bcgo.Network, account bcgo.Account, recordHash []byte, callback MetaCallback) error {
 	return bcgo.Read(metas.Name(), metas.Head(), nil, cache, network, account, recordHash, func(entry *bcgo.BlockEntry, key, data []byte) error {
 		// Unmarshal as Meta
 		meta := &Meta{}
@@ -481,3 +569,97 @@ func IterateDeltas(node bcgo.Node, deltas bcgo.Channel, callback DeltaCallback)
 		return nil
 	})
 }
+
+// iterateChronologically triggers the given callback with the decrypted payload of each record in the given channel which the node's account can access, oldest first.
+func iterateChronologically(node bcgo.Node, channel bcgo.Channel, callback func(*bcgo.BlockEntry, []byte) error) error {
+	account := node.Account()
+	return bcgo.IterateChronologically(channel.Name(), channel.Head(), nil, node.Cache(), node.Network(), func(hash []byte, block *bcgo.Block) error {
+		return readEntries(account, block, callback)
+	})
+}
+
+// iterateSince triggers the given callback with the decrypted payload of each record in the given channel which is accessible to the node's account and was mined after the block with the given hash, oldest first.
+func iterateSince(node bcgo.Node, channel bcgo.Channel, since []byte, callback func(*bcgo.BlockEntry, []byte) error) error {
+	var blocks []*bcgo.Block
+	if err := bcgo.Iterate(channel.Name(), channel.Head(), nil, node.Cache(), node.Network(), func(hash []byte, block *bcgo.Block) error {
+		if bytes.Equal(hash, since) {
+			return bcgo.ErrStopIteration{}
+		}
+		blocks = append(blocks, block)
+		return nil
+	}); err != nil {
+		switch err.(type) {
+		case bcgo.ErrStopIteration:
+			// Do nothing
+			break
+		default:
+			return err
+		}
+	}
+	account := node.Account()
+	for i := len(blocks) - 1; i >= 0; i-- {
+		if err := readEntries(account, blocks[i], callback); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readEntries triggers the given callback with the decrypted payload of each record in the given block which is accessible to the given account.
+func readEntries(account bcgo.Account, block *bcgo.Block, callback func(*bcgo.BlockEntry, []byte) error) error {
+	alias := account.Alias()
+	for _, entry := range block.Entry {
+		for _, access := range entry.Record.Access {
+			if alias == access.Alias {
+				decryptedKey, err := account.DecryptKey(access.EncryptionAlgorithm, access.SecretKey)
+				if err != nil {
+					return err
+				}
+				decryptedPayload, err := account.Decrypt(entry.Record.EncryptionAlgorithm, entry.Record.Payload, decryptedKey)
+				if err != nil {
+					return err
+				}
+				if err := callback(entry, decryptedPayload); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// contentSize returns the size of the file with the given metaId from the deltas in its Delta channel, without applying them.
+func contentSize(node bcgo.Node, metaId string) (uint64, error) {
+	deltas := node.OpenChannel(DeltaChannelName(metaId), func() bcgo.Channel {
+		return OpenDeltaChannel(metaId)
+	})
+	if err := deltas.Refresh(node.Cache(), node.Network()); err != nil {
+		log.Println(err)
+	}
+	var size uint64
+	if err := IterateDeltas(node, deltas, func(entry *bcgo.BlockEntry, delta *Delta) error {
+		size = size - delta.Delete + uint64(len(delta.Insert))
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// readContent returns the content of the file with the given metaId by applying every delta in its Delta channel.
+func readContent(node bcgo.Node, metaId string) ([]byte, error) {
+	deltas := node.OpenChannel(DeltaChannelName(metaId), func() bcgo.Channel {
+		return OpenDeltaChannel(metaId)
+	})
+	if err := deltas.Refresh(node.Cache(), node.Network()); err != nil {
+		log.Println(err)
+	}
+	var content []byte
+	if err := IterateDeltas(node, deltas, func(entry *bcgo.BlockEntry, delta *Delta) error {
+		content = ApplyDelta(delta, content)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
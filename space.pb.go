@@ -83,7 +83,13 @@ type Meta struct {
 	// Name of file.
 	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
 	// MIME type of file.
-	Type                 string   `protobuf:"bytes,3,opt,name=type,proto3" json:"type,omitempty"`
+	Type string `protobuf:"bytes,3,opt,name=type,proto3" json:"type,omitempty"`
+	// MetaId of parent directory, empty if in root.
+	Parent string `protobuf:"bytes,4,opt,name=parent,proto3" json:"parent,omitempty"`
+	// Timestamp file was moved to trash, zero if not in trash.
+	Trashed uint64 `protobuf:"varint,5,opt,name=trashed,proto3" json:"trashed,omitempty"`
+	// Whether file was permanently deleted.
+	Purged               bool     `protobuf:"varint,6,opt,name=purged,proto3" json:"purged,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -128,6 +134,27 @@ func (m *Meta) GetType() string {
 	return ""
 }
 
+func (m *Meta) GetParent() string {
+	if m != nil {
+		return m.Parent
+	}
+	return ""
+}
+
+func (m *Meta) GetTrashed() uint64 {
+	if m != nil {
+		return m.Trashed
+	}
+	return 0
+}
+
+func (m *Meta) GetPurged() bool {
+	if m != nil {
+		return m.Purged
+	}
+	return false
+}
+
 type Preview struct {
 	// MIME type of preview
 	Type string `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
@@ -199,7 +226,9 @@ type Tag struct {
 	// The value of tag applied to meta
 	Value string `protobuf:"bytes,1,opt,name=value,proto3" json:"value,omitempty"`
 	// The reason for tagging
-	Reason               string   `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+	Reason string `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+	// True if this record removes the tag with the same value
+	Removed              bool     `protobuf:"varint,3,opt,name=removed,proto3" json:"removed,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -244,12 +273,23 @@ func (m *Tag) GetReason() string {
 	return ""
 }
 
+func (m *Tag) GetRemoved() bool {
+	if m != nil {
+		return m.Removed
+	}
+	return false
+}
+
 type Registrar struct {
-	Merchant             *financego.Merchant `protobuf:"bytes,1,opt,name=merchant,proto3" json:"merchant,omitempty"`
-	Service              *financego.Service  `protobuf:"bytes,2,opt,name=service,proto3" json:"service,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}            `json:"-"`
-	XXX_unrecognized     []byte              `json:"-"`
-	XXX_sizecache        int32               `json:"-"`
+	// Merchant operating the registrar.
+	Merchant *financego.Merchant `protobuf:"bytes,1,opt,name=merchant,proto3" json:"merchant,omitempty"`
+	// Service of the registrar, whose group size is a number of bytes; the
+	// storage quota of a fixed amount service, or the unit in which metered
+	// usage is priced.
+	Service              *financego.Service `protobuf:"bytes,2,opt,name=service,proto3" json:"service,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}           `json:"-"`
+	XXX_unrecognized     []byte             `json:"-"`
+	XXX_sizecache        int32              `json:"-"`
 }
 
 func (m *Registrar) Reset()         { *m = Registrar{} }
@@ -291,36 +331,221 @@ func (m *Registrar) GetService() *financego.Service {
 	return nil
 }
 
+type Waveform struct {
+	// Duration in milliseconds
+	Duration uint64 `protobuf:"varint,1,opt,name=duration,proto3" json:"duration,omitempty"`
+	// Average bitrate in bits per second
+	Bitrate uint32 `protobuf:"varint,2,opt,name=bitrate,proto3" json:"bitrate,omitempty"`
+	// Number of audio channels
+	Channels uint32 `protobuf:"varint,3,opt,name=channels,proto3" json:"channels,omitempty"`
+	// Samples per second
+	SampleRate uint32 `protobuf:"varint,4,opt,name=sample_rate,json=sampleRate,proto3" json:"sample_rate,omitempty"`
+	// Level of each equal length interval, from 0 (silent) to 255 (loudest), estimated from the gain of the encoded audio rather than decoded samples
+	Peaks                []byte   `protobuf:"bytes,5,opt,name=peaks,proto3" json:"peaks,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Waveform) Reset()         { *m = Waveform{} }
+func (m *Waveform) String() string { return proto.CompactTextString(m) }
+func (*Waveform) ProtoMessage()    {}
+func (*Waveform) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b8a3f24abfdc04ca, []int{5}
+}
+
+func (m *Waveform) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Waveform.Unmarshal(m, b)
+}
+func (m *Waveform) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Waveform.Marshal(b, m, deterministic)
+}
+func (m *Waveform) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Waveform.Merge(m, src)
+}
+func (m *Waveform) XXX_Size() int {
+	return xxx_messageInfo_Waveform.Size(m)
+}
+func (m *Waveform) XXX_DiscardUnknown() {
+	xxx_messageInfo_Waveform.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Waveform proto.InternalMessageInfo
+
+func (m *Waveform) GetDuration() uint64 {
+	if m != nil {
+		return m.Duration
+	}
+	return 0
+}
+
+func (m *Waveform) GetBitrate() uint32 {
+	if m != nil {
+		return m.Bitrate
+	}
+	return 0
+}
+
+func (m *Waveform) GetChannels() uint32 {
+	if m != nil {
+		return m.Channels
+	}
+	return 0
+}
+
+func (m *Waveform) GetSampleRate() uint32 {
+	if m != nil {
+		return m.SampleRate
+	}
+	return 0
+}
+
+func (m *Waveform) GetPeaks() []byte {
+	if m != nil {
+		return m.Peaks
+	}
+	return nil
+}
+
+type Rule struct {
+	// Unique name of rule for its alias
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// Tag value applied to matching files
+	Tag string `protobuf:"bytes,2,opt,name=tag,proto3" json:"tag,omitempty"`
+	// MIME type pattern files must match, such as image/*, empty for any
+	MimeType string `protobuf:"bytes,3,opt,name=mime_type,json=mimeType,proto3" json:"mime_type,omitempty"`
+	// Name glob files must match, such as *.pdf, empty for any
+	FilePattern string `protobuf:"bytes,4,opt,name=file_pattern,json=filePattern,proto3" json:"file_pattern,omitempty"`
+	// Size in bytes files must exceed, zero for any
+	MinSize uint64 `protobuf:"varint,5,opt,name=min_size,json=minSize,proto3" json:"min_size,omitempty"`
+	// Text content of files must contain, empty for any
+	Content string `protobuf:"bytes,6,opt,name=content,proto3" json:"content,omitempty"`
+	// True if this record removes the rule with the same name
+	Removed              bool     `protobuf:"varint,7,opt,name=removed,proto3" json:"removed,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Rule) Reset()         { *m = Rule{} }
+func (m *Rule) String() string { return proto.CompactTextString(m) }
+func (*Rule) ProtoMessage()    {}
+func (*Rule) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b8a3f24abfdc04ca, []int{6}
+}
+
+func (m *Rule) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Rule.Unmarshal(m, b)
+}
+func (m *Rule) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Rule.Marshal(b, m, deterministic)
+}
+func (m *Rule) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Rule.Merge(m, src)
+}
+func (m *Rule) XXX_Size() int {
+	return xxx_messageInfo_Rule.Size(m)
+}
+func (m *Rule) XXX_DiscardUnknown() {
+	xxx_messageInfo_Rule.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Rule proto.InternalMessageInfo
+
+func (m *Rule) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *Rule) GetTag() string {
+	if m != nil {
+		return m.Tag
+	}
+	return ""
+}
+
+func (m *Rule) GetMimeType() string {
+	if m != nil {
+		return m.MimeType
+	}
+	return ""
+}
+
+func (m *Rule) GetFilePattern() string {
+	if m != nil {
+		return m.FilePattern
+	}
+	return ""
+}
+
+func (m *Rule) GetMinSize() uint64 {
+	if m != nil {
+		return m.MinSize
+	}
+	return 0
+}
+
+func (m *Rule) GetContent() string {
+	if m != nil {
+		return m.Content
+	}
+	return ""
+}
+
+func (m *Rule) GetRemoved() bool {
+	if m != nil {
+		return m.Removed
+	}
+	return false
+}
+
 func init() {
 	proto.RegisterType((*Delta)(nil), "space.Delta")
 	proto.RegisterType((*Meta)(nil), "space.Meta")
 	proto.RegisterType((*Preview)(nil), "space.Preview")
 	proto.RegisterType((*Tag)(nil), "space.Tag")
 	proto.RegisterType((*Registrar)(nil), "space.Registrar")
+	proto.RegisterType((*Waveform)(nil), "space.Waveform")
+	proto.RegisterType((*Rule)(nil), "space.Rule")
 }
 
 func init() { proto.RegisterFile("space.proto", fileDescriptor_b8a3f24abfdc04ca) }
 
 var fileDescriptor_b8a3f24abfdc04ca = []byte{
-	// 309 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x54, 0x91, 0xcf, 0x6b, 0xea, 0x40,
-	0x10, 0x80, 0xc9, 0x33, 0xea, 0xcb, 0xa8, 0xd0, 0x2e, 0x45, 0x42, 0x4f, 0x92, 0x93, 0x14, 0x9a,
-	0x82, 0xde, 0x7b, 0x90, 0x5e, 0xa5, 0xb2, 0xf6, 0xd4, 0x4b, 0x99, 0xc6, 0x49, 0xb2, 0x90, 0x64,
-	0x65, 0xb3, 0x55, 0xfa, 0xdf, 0x97, 0x9d, 0x4d, 0x52, 0x7a, 0xca, 0x7c, 0xdf, 0xfc, 0xd8, 0x30,
-	0x03, 0xb3, 0xf6, 0x8c, 0x19, 0xa5, 0x67, 0xa3, 0xad, 0x16, 0x63, 0x86, 0xfb, 0x45, 0xae, 0x1a,
-	0x6c, 0x7a, 0x9b, 0xbc, 0xc2, 0xf8, 0x85, 0x2a, 0x8b, 0x62, 0x09, 0x13, 0x9d, 0xe7, 0x2d, 0xd9,
-	0x38, 0x58, 0x05, 0xeb, 0x50, 0x76, 0xe4, 0xfc, 0x89, 0x2a, 0xb2, 0x14, 0xff, 0xf3, 0xde, 0x93,
-	0xf3, 0xaa, 0x69, 0xc9, 0xd8, 0x78, 0xb4, 0x0a, 0xd6, 0x73, 0xd9, 0x51, 0x92, 0x42, 0xb8, 0x27,
-	0x8b, 0x42, 0x40, 0xd8, 0x60, 0x4d, 0x3c, 0x2d, 0x92, 0x1c, 0x3b, 0x67, 0xbf, 0xcf, 0xc4, 0x1d,
-	0x91, 0xe4, 0x38, 0xf9, 0x80, 0xe9, 0xc1, 0xd0, 0x45, 0xd1, 0x75, 0x48, 0x07, 0xbf, 0x69, 0xe7,
-	0x4e, 0x68, 0x91, 0x1f, 0x9f, 0x4b, 0x8e, 0xc5, 0x1d, 0x8c, 0xaf, 0xea, 0x64, 0x4b, 0x9e, 0xb3,
-	0x90, 0x1e, 0xdc, 0x0f, 0x95, 0xa4, 0x8a, 0xd2, 0xc6, 0x21, 0xeb, 0x8e, 0x92, 0x2d, 0x8c, 0xde,
-	0xb0, 0x70, 0x4d, 0x17, 0xac, 0xbe, 0xfa, 0xe9, 0x1e, 0x5c, 0x93, 0x21, 0x6c, 0x75, 0xc3, 0x0f,
-	0x44, 0xb2, 0xa3, 0x24, 0x87, 0x48, 0x52, 0xa1, 0x5a, 0x6b, 0xd0, 0x88, 0x47, 0xf8, 0x5f, 0x93,
-	0xc9, 0x4a, 0x6c, 0xfc, 0x72, 0x66, 0x9b, 0xdb, 0xb4, 0xdf, 0xe2, 0xbe, 0x4b, 0xc8, 0xa1, 0x44,
-	0x3c, 0xc0, 0xb4, 0x25, 0x73, 0x51, 0x99, 0x5f, 0xd9, 0x6c, 0x73, 0x33, 0x54, 0x1f, 0xbd, 0x97,
-	0x7d, 0xc1, 0xee, 0x19, 0x96, 0x99, 0xae, 0x53, 0xac, 0xc8, 0x96, 0xa4, 0xf0, 0x8a, 0x86, 0x52,
-	0xbe, 0xd3, 0x0e, 0x8e, 0xee, 0x73, 0x70, 0x47, 0x7a, 0x8f, 0xff, 0xe4, 0x33, 0x5d, 0x3f, 0x71,
-	0x4d, 0xa1, 0x3f, 0x27, 0x7c, 0xc5, 0xed, 0x4f, 0x00, 0x00, 0x00, 0xff, 0xff, 0x63, 0x11, 0x36,
-	0x3a, 0xea, 0x01, 0x00, 0x00,
+	// 513 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x93, 0xcf, 0x8a, 0xdb, 0x30,
+	0x10, 0xc6, 0x71, 0xd7, 0x49, 0x9c, 0x49, 0x02, 0x5b, 0xb1, 0x2c, 0xee, 0xf6, 0xd0, 0xd4, 0xa7,
+	0x50, 0x68, 0x0a, 0xdb, 0x7b, 0x0f, 0x4b, 0xaf, 0xa1, 0x41, 0x59, 0x28, 0xf4, 0x12, 0x66, 0xed,
+	0xb1, 0x2d, 0x6a, 0xcb, 0x46, 0x52, 0x92, 0x76, 0x5f, 0xa3, 0x8f, 0xd3, 0x97, 0x2b, 0xfa, 0xe3,
+	0x90, 0x85, 0x9e, 0xac, 0xdf, 0x67, 0x7d, 0x1a, 0x49, 0xdf, 0x08, 0x66, 0xba, 0xc7, 0x9c, 0xd6,
+	0xbd, 0xea, 0x4c, 0xc7, 0x46, 0x0e, 0xee, 0x16, 0xa5, 0x90, 0x28, 0x07, 0x35, 0xfb, 0x06, 0xa3,
+	0xaf, 0xd4, 0x18, 0x64, 0xb7, 0x30, 0xee, 0xca, 0x52, 0x93, 0x49, 0xa3, 0x65, 0xb4, 0x8a, 0x79,
+	0x20, 0xab, 0x17, 0xd4, 0x90, 0xa1, 0xf4, 0x95, 0xd7, 0x3d, 0x59, 0x5d, 0x48, 0x4d, 0xca, 0xa4,
+	0x57, 0xcb, 0x68, 0x35, 0xe7, 0x81, 0xb2, 0x5f, 0x10, 0x6f, 0xc8, 0x20, 0x63, 0x10, 0x4b, 0x6c,
+	0xc9, 0xad, 0x36, 0xe5, 0x6e, 0x6c, 0x35, 0xf3, 0xbb, 0x27, 0xe7, 0x98, 0x72, 0x37, 0xb6, 0xeb,
+	0xf4, 0xa8, 0x48, 0x9a, 0x34, 0x76, 0x6a, 0x20, 0x96, 0xc2, 0xc4, 0x28, 0xd4, 0x35, 0x15, 0xe9,
+	0xc8, 0x15, 0x1e, 0xd0, 0x39, 0x0e, 0xaa, 0xa2, 0x22, 0x1d, 0x2f, 0xa3, 0x55, 0xc2, 0x03, 0x65,
+	0x7b, 0x98, 0x6c, 0x15, 0x1d, 0x05, 0x9d, 0xce, 0x85, 0xa2, 0x8b, 0x42, 0x0c, 0xe2, 0x02, 0x0d,
+	0xba, 0x63, 0xcc, 0xb9, 0x1b, 0xb3, 0x1b, 0x18, 0x9d, 0x44, 0x61, 0x6a, 0xb7, 0xa3, 0x05, 0xf7,
+	0x60, 0x0b, 0xd4, 0x24, 0xaa, 0xda, 0x6f, 0x69, 0xc1, 0x03, 0x65, 0x1b, 0xb8, 0x7a, 0xc4, 0xca,
+	0x9a, 0x8e, 0xd8, 0x1c, 0x86, 0xd5, 0x3d, 0x58, 0x93, 0x22, 0xd4, 0x9d, 0x74, 0x05, 0xa6, 0x3c,
+	0x90, 0x3d, 0x87, 0xa2, 0xb6, 0x3b, 0x52, 0xe1, 0x8a, 0x24, 0x7c, 0xc0, 0xac, 0x84, 0x29, 0xa7,
+	0x4a, 0x68, 0xa3, 0x50, 0xb1, 0x8f, 0x90, 0xb4, 0xa4, 0xf2, 0x1a, 0xa5, 0x0f, 0x60, 0x76, 0xff,
+	0x7a, 0x3d, 0x24, 0xb5, 0x09, 0x3f, 0xf8, 0x79, 0x0a, 0xfb, 0x00, 0x13, 0x4d, 0xea, 0x28, 0x72,
+	0x1f, 0xcb, 0xec, 0xfe, 0xfa, 0x3c, 0x7b, 0xe7, 0x75, 0x3e, 0x4c, 0xc8, 0xfe, 0x44, 0x90, 0x7c,
+	0xc7, 0x23, 0x95, 0x9d, 0x6a, 0xd9, 0x1d, 0x24, 0xc5, 0x41, 0xa1, 0x11, 0x9d, 0x0c, 0x41, 0x9f,
+	0xd9, 0x6e, 0xf5, 0x49, 0x18, 0x85, 0x21, 0xeb, 0x05, 0x1f, 0xd0, 0xba, 0x6c, 0x5d, 0x49, 0x8d,
+	0x0e, 0x57, 0x75, 0x66, 0xf6, 0x0e, 0x66, 0x1a, 0xdb, 0xbe, 0xa1, 0xbd, 0x73, 0xfa, 0x2b, 0x03,
+	0x2f, 0x71, 0x6b, 0xbe, 0x81, 0x51, 0x4f, 0xf8, 0x53, 0xbb, 0x1c, 0xe7, 0xdc, 0x43, 0xf6, 0x37,
+	0x82, 0x98, 0x1f, 0x1a, 0xfa, 0x6f, 0xa3, 0x5c, 0xc3, 0x95, 0xc1, 0x2a, 0xdc, 0xa4, 0x1d, 0xb2,
+	0xb7, 0x30, 0x6d, 0x45, 0x4b, 0xfb, 0x8b, 0xfe, 0x49, 0xac, 0xf0, 0x68, 0xa3, 0x7d, 0x0f, 0xf3,
+	0x52, 0x34, 0xb4, 0xef, 0xd1, 0x18, 0x52, 0x32, 0x74, 0xd2, 0xcc, 0x6a, 0x5b, 0x2f, 0xb1, 0x37,
+	0x90, 0xb4, 0x42, 0xee, 0xb5, 0x78, 0xa6, 0xa1, 0x9f, 0x5a, 0x21, 0x77, 0xe2, 0x99, 0xec, 0xb1,
+	0xf3, 0x4e, 0x1a, 0xdb, 0x82, 0x63, 0x67, 0x1c, 0xf0, 0x32, 0xbb, 0xc9, 0x8b, 0xec, 0x1e, 0xbe,
+	0xc0, 0x6d, 0xde, 0xb5, 0x6b, 0x6c, 0xc8, 0xd4, 0x24, 0xf0, 0x84, 0x8a, 0xd6, 0xee, 0x7d, 0x3d,
+	0xc0, 0xce, 0x7e, 0xb6, 0xf6, 0x71, 0xfd, 0x48, 0x5f, 0xfc, 0xcf, 0xbb, 0xf6, 0x93, 0x9b, 0x53,
+	0x75, 0x4f, 0x63, 0xf7, 0xfa, 0x3e, 0xff, 0x1b, 0x00, 0xcd, 0xbe, 0x14, 0x23, 0xa2, 0x03, 0x00,
+	0x00,
 }
@@ -0,0 +1,261 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego
+
+import (
+	"aletheiaware.com/bcgo"
+	"aletheiaware.com/financego"
+	"fmt"
+	"math"
+	"sort"
+)
+
+const FORECAST_PERIODS = 3 // Number of periods whose usage is used to project the next
+
+// Kinds of Discrepancy between a registrar's records and the customer's own figures.
+const (
+	DISCREPANCY_MISSING_USAGE = "missing-usage" // No usage was reported for the period
+	DISCREPANCY_USAGE         = "usage"         // Reported usage differs from measured usage
+	DISCREPANCY_INVOICE       = "invoice"       // Invoiced amount differs from the price of measured usage
+	DISCREPANCY_CHARGE        = "charge"        // Charged amount differs from invoiced amount
+)
+
+// Discrepancy is a difference between a registrar's records and the customer's own figures.
+type Discrepancy struct {
+	Kind     string
+	Expected int64
+	Actual   int64
+}
+
+func (d *Discrepancy) String() string {
+	return fmt.Sprintf("%s: expected %d, actual %d", d.Kind, d.Expected, d.Actual)
+}
+
+// RegistrarStatement is the billing of a customer by one registrar for a period.
+type RegistrarStatement struct {
+	Registrar     *Registrar
+	Registration  *financego.Registration
+	Subscription  *financego.Subscription
+	UsageRecords  []*financego.UsageRecord // Usage reported for the period
+	Invoices      []*financego.Invoice     // Invoices billing the period
+	Charges       []*financego.Charge      // Charges billing the period
+	ReportedUsage int64                    // Quantity of usage reported
+	ExpectedCost  int64                    // Price of the customer's measured usage
+	Invoiced      int64
+	Charged       int64
+	Forecast      int64 // Projected cost of the next period
+	Discrepancies []*Discrepancy
+}
+
+// Statement is the billing of a customer by every registrar for a period.
+type Statement struct {
+	Period        Period
+	Usage         *Usage // Measured at the end of the period
+	ForecastUsage uint64 // Bytes projected at the end of the next period
+	Registrars    []*RegistrarStatement
+}
+
+// Charged returns the total charged by all registrars.
+func (s *Statement) Charged() int64 {
+	var total int64
+	for _, r := range s.Registrars {
+		total += r.Charged
+	}
+	return total
+}
+
+// Forecast returns the total projected cost of the next period.
+func (s *Statement) Forecast() int64 {
+	var total int64
+	for _, r := range s.Registrars {
+		total += r.Forecast
+	}
+	return total
+}
+
+// Discrepancies returns the number of discrepancies flagged for all registrars.
+func (s *Statement) Discrepancies() int {
+	var count int
+	for _, r := range s.Registrars {
+		count += len(r.Discrepancies)
+	}
+	return count
+}
+
+// Reconcile totals the records of the given statement for the given period, prices the given measured usage and projected usage with the registrar's service, and flags any discrepancies.
+func (r *RegistrarStatement) Reconcile(usage *Usage, projected uint64, period Period) {
+	r.Invoiced = 0
+	for _, i := range r.Invoices {
+		r.Invoiced += i.Amount
+	}
+	r.Charged = 0
+	for _, c := range r.Charges {
+		r.Charged += c.Amount
+	}
+	r.ReportedUsage = 0
+	r.Discrepancies = nil
+	service := r.Registrar.Service
+	if service == nil || r.Subscription == nil {
+		r.ExpectedCost = 0
+		r.Forecast = 0
+	} else {
+		measured := int64(usage.Total())
+		if service.Mode == financego.Mode_FIXED_AMOUNT {
+			measured = 1
+		} else {
+			r.ReportedUsage = BillingQuantity(service, r.UsageRecords, period)
+			if len(r.UsageRecords) == 0 {
+				r.Discrepancies = append(r.Discrepancies, &Discrepancy{
+					Kind:     DISCREPANCY_MISSING_USAGE,
+					Expected: measured,
+				})
+			} else if r.ReportedUsage != measured {
+				r.Discrepancies = append(r.Discrepancies, &Discrepancy{
+					Kind:     DISCREPANCY_USAGE,
+					Expected: measured,
+					Actual:   r.ReportedUsage,
+				})
+			}
+		}
+		r.ExpectedCost = BillingAmount(service, measured)
+		if service.Mode == financego.Mode_FIXED_AMOUNT {
+			r.Forecast = BillingAmount(service, 1)
+		} else {
+			r.Forecast = BillingAmount(service, int64(projected))
+		}
+		// Nothing is invoiced until the period has been billed
+		if len(r.Invoices) > 0 && r.Invoiced != r.ExpectedCost {
+			r.Discrepancies = append(r.Discrepancies, &Discrepancy{
+				Kind:     DISCREPANCY_INVOICE,
+				Expected: r.ExpectedCost,
+				Actual:   r.Invoiced,
+			})
+		}
+	}
+	if r.Charged != r.Invoiced {
+		r.Discrepancies = append(r.Discrepancies, &Discrepancy{
+			Kind:     DISCREPANCY_CHARGE,
+			Expected: r.Invoiced,
+			Actual:   r.Charged,
+		})
+	}
+}
+
+// ProjectUsage returns the usage projected one period after the last of the given usages of consecutive periods, oldest first, by fitting a linear trend.
+// A shrinking trend is not projected below zero.
+func ProjectUsage(usages []uint64) uint64 {
+	n := len(usages)
+	switch n {
+	case 0:
+		return 0
+	case 1:
+		return usages[0]
+	}
+	// Least squares fit of usage against period index
+	var sx, sy, sxx, sxy float64
+	for i, u := range usages {
+		x, y := float64(i), float64(u)
+		sx += x
+		sy += y
+		sxx += x * x
+		sxy += x * y
+	}
+	fn := float64(n)
+	slope := (fn*sxy - sx*sy) / (fn*sxx - sx*sx)
+	intercept := (sy - slope*sx) / fn
+	projected := intercept + slope*fn
+	if projected < 0 {
+		return 0
+	}
+	return uint64(math.Round(projected))
+}
+
+// BillingStatement collects the usage records, invoices, and charges of the node's alias for the given period from every registrar it is registered with, reconciles them against its own usage measured by MeasureUsage, and projects the cost of the next period from the trend of the last FORECAST_PERIODS periods.
+// Charges are matched to the period they bill by their description, see BillsPeriod, and invoices through the charges which reference them, see ChargedInvoice, regardless of when they were written.
+// An invoice is not collected until it has been charged.
+func BillingStatement(node bcgo.Node, period Period) (*Statement, error) {
+	alias := node.Account().Alias()
+	statement := &Statement{
+		Period: period,
+	}
+	// Measure usage over recent periods, oldest first
+	var usages []uint64
+	p := period
+	for i := 0; i < FORECAST_PERIODS-1; i++ {
+		p = p.Previous()
+	}
+	for i := 0; i < FORECAST_PERIODS; i++ {
+		u, err := MeasureUsage(node, alias, p.End)
+		if err != nil {
+			return nil, err
+		}
+		usages = append(usages, u.Total())
+		statement.Usage = u
+		p = p.Next()
+	}
+	statement.ForecastUsage = ProjectUsage(usages)
+
+	merchants := make(map[string]*RegistrarStatement)
+	if err := AllRegistrarsForNode(node, func(registrar *Registrar, registration *financego.Registration, subscription *financego.Subscription) error {
+		r := &RegistrarStatement{
+			Registrar:    registrar,
+			Registration: registration,
+			Subscription: subscription,
+		}
+		merchants[registrar.Merchant.Alias] = r
+		statement.Registrars = append(statement.Registrars, r)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(statement.Registrars, func(i, j int) bool {
+		return statement.Registrars[i].Registrar.Merchant.Alias < statement.Registrars[j].Registrar.Merchant.Alias
+	})
+	if err := AllUsageRecords(node, func(entry *bcgo.BlockEntry, u *financego.UsageRecord) error {
+		if r, ok := merchants[u.MerchantAlias]; ok && u.CustomerAlias == alias && UsageInPeriod(u, period) {
+			r.UsageRecords = append(r.UsageRecords, u)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	// Hashes of the Invoice records charged for the period
+	billed := make(map[string]bool)
+	if err := AllCharges(node, func(entry *bcgo.BlockEntry, c *financego.Charge) error {
+		if r, ok := merchants[c.MerchantAlias]; ok && c.CustomerAlias == alias && BillsPeriod(c, period) {
+			r.Charges = append(r.Charges, c)
+			if hash := ChargedInvoice(entry); hash != nil {
+				billed[string(hash)] = true
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if err := AllInvoices(node, func(entry *bcgo.BlockEntry, i *financego.Invoice) error {
+		if r, ok := merchants[i.MerchantAlias]; ok && i.CustomerAlias == alias && billed[string(entry.RecordHash)] {
+			r.Invoices = append(r.Invoices, i)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	for _, r := range statement.Registrars {
+		r.Reconcile(statement.Usage, statement.ForecastUsage, period)
+	}
+	return statement, nil
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego_test
+
+import (
+	"aletheiaware.com/financego"
+	"aletheiaware.com/spacego"
+	"aletheiaware.com/testinggo"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestProjectUsage(t *testing.T) {
+	for name, tt := range map[string]struct {
+		usages   []uint64
+		expected uint64
+	}{
+		"empty":     {},
+		"single":    {usages: []uint64{100}, expected: 100},
+		"flat":      {usages: []uint64{100, 100, 100}, expected: 100},
+		"growing":   {usages: []uint64{100, 200, 300}, expected: 400},
+		"irregular": {usages: []uint64{100, 300, 200}, expected: 300},
+		"shrinking": {usages: []uint64{300, 100, 0}, expected: 0},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, spacego.ProjectUsage(tt.usages))
+		})
+	}
+}
+
+func TestRegistrarStatementReconcile(t *testing.T) {
+	period := spacego.MonthlyPeriod(time.Date(2021, time.March, 2, 0, 0, 0, 0, time.UTC))
+	end := time.Date(2021, time.April, 1, 0, 0, 0, 0, time.UTC).Unix()
+	usage := &spacego.Usage{Delta: 1500000}
+	for name, tt := range map[string]struct {
+		records       []*financego.UsageRecord
+		invoices      []*financego.Invoice
+		charges       []*financego.Charge
+		discrepancies []*spacego.Discrepancy
+	}{
+		"unbilled": {
+			records: []*financego.UsageRecord{{Timestamp: end, Quantity: 1500000}},
+		},
+		"reconciled": {
+			records:  []*financego.UsageRecord{{Timestamp: end, Quantity: 1500000}},
+			invoices: []*financego.Invoice{{Amount: 50}},
+			charges:  []*financego.Charge{{Amount: 50}},
+		},
+		"missing_usage": {
+			discrepancies: []*spacego.Discrepancy{
+				{Kind: spacego.DISCREPANCY_MISSING_USAGE, Expected: 1500000},
+			},
+		},
+		"overreported": {
+			records:  []*financego.UsageRecord{{Timestamp: end, Quantity: 2500000}},
+			invoices: []*financego.Invoice{{Amount: 75}},
+			charges:  []*financego.Charge{{Amount: 75}},
+			discrepancies: []*spacego.Discrepancy{
+				{Kind: spacego.DISCREPANCY_USAGE, Expected: 1500000, Actual: 2500000},
+				{Kind: spacego.DISCREPANCY_INVOICE, Expected: 50, Actual: 75},
+			},
+		},
+		"uncharged": {
+			records:  []*financego.UsageRecord{{Timestamp: end, Quantity: 1500000}},
+			invoices: []*financego.Invoice{{Amount: 50}},
+			discrepancies: []*spacego.Discrepancy{
+				{Kind: spacego.DISCREPANCY_CHARGE, Expected: 50},
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			registrar := testRegistrar("nyc", "space-nyc.example.com", "US", 25)
+			registrar.Service.Mode = financego.Mode_METERED_LAST_USAGE
+			r := &spacego.RegistrarStatement{
+				Registrar:    registrar,
+				Subscription: &financego.Subscription{MerchantAlias: "nyc"},
+				UsageRecords: tt.records,
+				Invoices:     tt.invoices,
+				Charges:      tt.charges,
+			}
+			r.Reconcile(usage, 3200000, period)
+			assert.Equal(t, int64(50), r.ExpectedCost)
+			assert.Equal(t, int64(100), r.Forecast)
+			assert.Equal(t, tt.discrepancies, r.Discrepancies)
+		})
+	}
+}
+
+func TestBillingStatement(t *testing.T) {
+	customer, merchant, period := testSubscriber(t, newFakeCache())
+	_, _, err := spacego.Bill(merchant, nil, newFakePaymentProcessor(), period, customerIdentities(customer))
+	testinggo.AssertNoError(t, err)
+
+	statement, err := spacego.BillingStatement(customer, period)
+	testinggo.AssertNoError(t, err)
+	assert.Equal(t, 1, len(statement.Registrars))
+	r := statement.Registrars[0]
+	assert.Equal(t, "nyc", r.Registrar.Merchant.Alias)
+	assert.Equal(t, 1, len(r.UsageRecords))
+	assert.Equal(t, 1, len(r.Invoices))
+	assert.Equal(t, 1, len(r.Charges))
+	assert.Equal(t, int64(statement.Usage.Total()), r.ReportedUsage)
+	assert.Equal(t, int64(20), r.ExpectedCost)
+	assert.Equal(t, int64(20), r.Invoiced)
+	assert.Equal(t, int64(20), statement.Charged())
+	assert.Empty(t, r.Discrepancies)
+
+	// Bills for a period are not collected for the previous period
+	statement, err = spacego.BillingStatement(customer, period.Previous())
+	testinggo.AssertNoError(t, err)
+	assert.Equal(t, 1, len(statement.Registrars))
+	r = statement.Registrars[0]
+	assert.Empty(t, r.UsageRecords)
+	assert.Empty(t, r.Invoices)
+	assert.Empty(t, r.Charges)
+}
+
+func TestBillingStatement_Uncharged(t *testing.T) {
+	customer, merchant, period := testSubscriber(t, newFakeCache())
+	processor := newFakePaymentProcessor()
+	processor.ChargeErr = errors.New("Card declined")
+	_, _, err := spacego.Bill(merchant, nil, processor, period, customerIdentities(customer))
+	assert.Equal(t, processor.ChargeErr, err)
+
+	// The invoice is not collected until it has been charged
+	statement, err := spacego.BillingStatement(customer, period)
+	testinggo.AssertNoError(t, err)
+	r := statement.Registrars[0]
+	assert.Empty(t, r.Invoices)
+	assert.Empty(t, r.Charges)
+
+	processor.ChargeErr = nil
+	_, _, err = spacego.Bill(merchant, nil, processor, period, customerIdentities(customer))
+	testinggo.AssertNoError(t, err)
+	statement, err = spacego.BillingStatement(customer, period)
+	testinggo.AssertNoError(t, err)
+	r = statement.Registrars[0]
+	assert.Equal(t, 1, len(r.Invoices))
+	assert.Equal(t, "in_1", r.Invoices[0].InvoiceId)
+	assert.Equal(t, 1, len(r.Charges))
+	assert.Empty(t, r.Discrepancies)
+}
@@ -0,0 +1,329 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego
+
+import (
+	"aletheiaware.com/bcgo"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Tag values may be hierarchical, such as "project/alpha/design", and namespaced, such as "status:approved".
+const (
+	TAG_PATH_SEPARATOR      = "/"
+	TAG_NAMESPACE_SEPARATOR = ":"
+	TAG_WILDCARD            = "*"
+)
+
+type ErrInvalidTag struct {
+	Value string
+}
+
+func (e ErrInvalidTag) Error() string {
+	return fmt.Sprintf("Invalid tag: %s", e.Value)
+}
+
+type ErrTagNotAllowed struct {
+	Namespace string
+	Value     string
+}
+
+func (e ErrTagNotAllowed) Error() string {
+	return fmt.Sprintf("Tag not allowed in namespace %s: %s", e.Namespace, e.Value)
+}
+
+type ErrNoSuchTag struct {
+	MetaId string
+	Value  string
+}
+
+func (e ErrNoSuchTag) Error() string {
+	return fmt.Sprintf("No such tag: %s on %s", e.Value, e.MetaId)
+}
+
+var tagNamespaces = struct {
+	sync.RWMutex
+	values map[string][]string
+}{
+	values: make(map[string][]string),
+}
+
+// RegisterTagNamespace constrains the values of tags in the given namespace to those matching the given patterns, as used by MatchTag.
+// Registering a namespace again replaces the previous patterns, and namespaces which are not registered allow any value.
+func RegisterTagNamespace(namespace string, patterns ...string) {
+	tagNamespaces.Lock()
+	defer tagNamespaces.Unlock()
+	tagNamespaces.values[namespace] = patterns
+}
+
+// ParseTag splits the given tag value into its namespace, if any, and the segments of its path.
+func ParseTag(value string) (string, []string) {
+	namespace := ""
+	if i := strings.Index(value, TAG_NAMESPACE_SEPARATOR); i >= 0 && !strings.Contains(value[:i], TAG_PATH_SEPARATOR) {
+		namespace = value[:i]
+		value = value[i+1:]
+	}
+	return namespace, strings.Split(value, TAG_PATH_SEPARATOR)
+}
+
+// TagAncestors returns the values of the levels above the given tag value, outermost first.
+// For example the ancestors of "project/alpha/design" are "project" and "project/alpha".
+func TagAncestors(value string) []string {
+	namespace, path := ParseTag(value)
+	prefix := ""
+	if namespace != "" {
+		prefix = namespace + TAG_NAMESPACE_SEPARATOR
+	}
+	var ancestors []string
+	for i := 1; i < len(path); i++ {
+		ancestors = append(ancestors, prefix+strings.Join(path[:i], TAG_PATH_SEPARATOR))
+	}
+	return ancestors
+}
+
+// MatchTag returns true if the given tag value matches the given pattern.
+// A pattern ending in "/*" matches the tag before it and every tag below it, a pattern ending in ":*" matches every tag in the namespace, and "*" matches every tag.
+// Any other pattern must equal the value.
+func MatchTag(pattern, value string) bool {
+	switch {
+	case pattern == TAG_WILDCARD:
+		return true
+	case strings.HasSuffix(pattern, TAG_PATH_SEPARATOR+TAG_WILDCARD):
+		parent := strings.TrimSuffix(pattern, TAG_PATH_SEPARATOR+TAG_WILDCARD)
+		return value == parent || strings.HasPrefix(value, parent+TAG_PATH_SEPARATOR)
+	case strings.HasSuffix(pattern, TAG_NAMESPACE_SEPARATOR+TAG_WILDCARD):
+		return strings.HasPrefix(value, strings.TrimSuffix(pattern, TAG_WILDCARD))
+	default:
+		return pattern == value
+	}
+}
+
+// ValidateTag returns an error if the given tag value is empty, has an empty namespace or path segment, contains a wildcard, or is not allowed by its namespace.
+func ValidateTag(value string) error {
+	if strings.Contains(value, TAG_WILDCARD) {
+		return ErrInvalidTag{Value: value}
+	}
+	namespace, path := ParseTag(value)
+	if namespace == "" && strings.HasPrefix(value, TAG_NAMESPACE_SEPARATOR) {
+		return ErrInvalidTag{Value: value}
+	}
+	for _, p := range path {
+		if p == "" {
+			return ErrInvalidTag{Value: value}
+		}
+	}
+	if namespace == "" {
+		return nil
+	}
+	tagNamespaces.RLock()
+	patterns, ok := tagNamespaces.values[namespace]
+	tagNamespaces.RUnlock()
+	if !ok {
+		return nil
+	}
+	local := strings.Join(path, TAG_PATH_SEPARATOR)
+	for _, p := range patterns {
+		if MatchTag(p, local) {
+			return nil
+		}
+	}
+	return ErrTagNotAllowed{Namespace: namespace, Value: local}
+}
+
+// TagSet is the tags currently applied to a file, folded from the records of its Tag channel in chronological order.
+// A record with Removed set is a tombstone which removes the tag with the same value, which may later be added again.
+type TagSet struct {
+	tags    map[string]*Tag
+	removed map[string]*Tag // Value to the tag most recently removed, until it is applied again
+}
+
+func NewTagSet() *TagSet {
+	return &TagSet{
+		tags:    make(map[string]*Tag),
+		removed: make(map[string]*Tag),
+	}
+}
+
+// Apply adds or removes the given tag.
+func (s *TagSet) Apply(tag *Tag) {
+	if tag.Removed {
+		if t, ok := s.tags[tag.Value]; ok {
+			s.removed[tag.Value] = t
+		}
+		delete(s.tags, tag.Value)
+	} else {
+		delete(s.removed, tag.Value)
+		s.tags[tag.Value] = tag
+	}
+}
+
+// Has returns true if a tag with the given value is applied.
+func (s *TagSet) Has(value string) bool {
+	_, ok := s.tags[value]
+	return ok
+}
+
+// Tags returns the applied tags, sorted by value.
+func (s *TagSet) Tags() []*Tag {
+	var tags []*Tag
+	for _, t := range s.tags {
+		tags = append(tags, t)
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].Value < tags[j].Value
+	})
+	return tags
+}
+
+func openTagChannel(node bcgo.Node, metaId string) bcgo.Channel {
+	tags := node.OpenChannel(TagChannelName(metaId), func() bcgo.Channel {
+		return OpenTagChannel(metaId)
+	})
+	if err := tags.Refresh(node.Cache(), node.Network()); err != nil {
+		log.Println(err)
+	}
+	return tags
+}
+
+// IterateTags triggers the given callback for each record in the given Tag channel, oldest first, including removals.
+func IterateTags(node bcgo.Node, tags bcgo.Channel, callback TagCallback) error {
+	return iterateChronologically(node, tags, func(entry *bcgo.BlockEntry, data []byte) error {
+		// Unmarshal as Tag
+		t := &Tag{}
+		if err := proto.Unmarshal(data, t); err != nil {
+			return err
+		}
+		return callback(entry, t)
+	})
+}
+
+// ReadTagSet folds the records of the Tag channel of the file with the given metaId into a TagSet.
+func ReadTagSet(node bcgo.Node, metaId string) (*TagSet, error) {
+	set := NewTagSet()
+	if err := IterateTags(node, openTagChannel(node, metaId), func(entry *bcgo.BlockEntry, tag *Tag) error {
+		set.Apply(tag)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// CurrentTags returns the tags currently applied to the file with the given metaId, sorted by value.
+func CurrentTags(node bcgo.Node, metaId string) ([]*Tag, error) {
+	set, err := ReadTagSet(node, metaId)
+	if err != nil {
+		return nil, err
+	}
+	return set.Tags(), nil
+}
+
+// AddTag writes a record to the Tag channel of the file with the given metaId which applies a tag with the given value and reason.
+// The value must be valid according to ValidateTag, and the file must not be purged in the given Hierarchy.
+func AddTag(node bcgo.Node, listener bcgo.MiningListener, h *Hierarchy, metaId, value, reason string) (*bcgo.Reference, error) {
+	if err := ValidateTag(value); err != nil {
+		return nil, err
+	}
+	access, err := h.Access(node.Account(), metaId)
+	if err != nil {
+		return nil, err
+	}
+	return writeAccess(node, listener, openTagChannel(node, metaId), access, nil, &Tag{
+		Value:  value,
+		Reason: reason,
+	})
+}
+
+// RemoveTag writes a tombstone to the Tag channel of the file with the given metaId which removes the tag with the given value.
+// The file must not be purged in the given Hierarchy.
+func RemoveTag(node bcgo.Node, listener bcgo.MiningListener, h *Hierarchy, metaId, value, reason string) (*bcgo.Reference, error) {
+	set, err := ReadTagSet(node, metaId)
+	if err != nil {
+		return nil, err
+	}
+	if !set.Has(value) {
+		return nil, ErrNoSuchTag{MetaId: metaId, Value: value}
+	}
+	access, err := h.Access(node.Account(), metaId)
+	if err != nil {
+		return nil, err
+	}
+	return writeAccess(node, listener, openTagChannel(node, metaId), access, nil, &Tag{
+		Value:   value,
+		Reason:  reason,
+		Removed: true,
+	})
+}
+
+// RenameTag replaces the tag with the given value by one with the new value, keeping its reason, on every file in the node's Meta channel, including those in the trash.
+// The metaIds of the files which were changed are returned.
+func RenameTag(node bcgo.Node, listener bcgo.MiningListener, from, to string) ([]string, error) {
+	if from == to {
+		return nil, nil
+	}
+	if err := ValidateTag(to); err != nil {
+		return nil, err
+	}
+	_, h, err := openMetaHierarchy(node)
+	if err != nil {
+		return nil, err
+	}
+	var renamed []string
+	for _, id := range h.all() {
+		set, err := ReadTagSet(node, id)
+		if err != nil {
+			return renamed, err
+		}
+		tag, ok := set.tags[from]
+		if !ok {
+			continue
+		}
+		access, err := h.Access(node.Account(), id)
+		if err != nil {
+			return renamed, err
+		}
+		tags := openTagChannel(node, id)
+		for _, t := range []*Tag{
+			&Tag{
+				Value:   from,
+				Reason:  fmt.Sprintf("Renamed to %s", to),
+				Removed: true,
+			},
+			&Tag{
+				Value:  to,
+				Reason: tag.Reason,
+			},
+		} {
+			data, err := proto.Marshal(t)
+			if err != nil {
+				return renamed, err
+			}
+			if _, err := node.Write(bcgo.Timestamp(), tags, access, nil, data); err != nil {
+				return renamed, err
+			}
+		}
+		if _, _, err := node.Mine(tags, Threshold(tags.Name()), listener); err != nil {
+			return renamed, err
+		}
+		renamed = append(renamed, id)
+	}
+	return renamed, nil
+}
@@ -0,0 +1,261 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego_test
+
+import (
+	"aletheiaware.com/spacego"
+	"aletheiaware.com/testinggo"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTagSet(t *testing.T) {
+	for name, tt := range map[string]struct {
+		records  []*spacego.Tag
+		expected []string
+	}{
+		"empty": {},
+		"added": {
+			records: []*spacego.Tag{
+				{Value: "work"},
+				{Value: "draft"},
+			},
+			expected: []string{"draft", "work"},
+		},
+		"removed": {
+			records: []*spacego.Tag{
+				{Value: "work"},
+				{Value: "draft"},
+				{Value: "draft", Removed: true},
+			},
+			expected: []string{"work"},
+		},
+		"readded": {
+			records: []*spacego.Tag{
+				{Value: "draft"},
+				{Value: "draft", Removed: true},
+				{Value: "draft", Reason: "Still editing"},
+			},
+			expected: []string{"draft"},
+		},
+		"remove_missing": {
+			records: []*spacego.Tag{
+				{Value: "draft", Removed: true},
+				{Value: "work"},
+			},
+			expected: []string{"work"},
+		},
+		"renamed": {
+			records: []*spacego.Tag{
+				{Value: "wrok", Reason: "Typo"},
+				{Value: "wrok", Removed: true},
+				{Value: "work", Reason: "Typo"},
+			},
+			expected: []string{"work"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			set := spacego.NewTagSet()
+			for _, r := range tt.records {
+				set.Apply(r)
+			}
+			var got []string
+			for _, tag := range set.Tags() {
+				got = append(got, tag.Value)
+				assert.True(t, set.Has(tag.Value))
+			}
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestTagSet_LatestReason(t *testing.T) {
+	set := spacego.NewTagSet()
+	set.Apply(&spacego.Tag{Value: "draft", Reason: "First"})
+	set.Apply(&spacego.Tag{Value: "draft", Reason: "Second"})
+	assert.Equal(t, "Second", set.Tags()[0].Reason)
+}
+
+func TestParseTag(t *testing.T) {
+	for name, tt := range map[string]struct {
+		value     string
+		namespace string
+		path      []string
+	}{
+		"flat": {
+			value: "work",
+			path:  []string{"work"},
+		},
+		"hierarchical": {
+			value: "project/alpha/design",
+			path:  []string{"project", "alpha", "design"},
+		},
+		"namespaced": {
+			value:     "status:approved",
+			namespace: "status",
+			path:      []string{"approved"},
+		},
+		"namespaced_hierarchical": {
+			value:     "dept:eng/backend",
+			namespace: "dept",
+			path:      []string{"eng", "backend"},
+		},
+		"colon_in_path": {
+			value: "time/12:30",
+			path:  []string{"time", "12:30"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			namespace, path := spacego.ParseTag(tt.value)
+			assert.Equal(t, tt.namespace, namespace)
+			assert.Equal(t, tt.path, path)
+		})
+	}
+}
+
+func TestTagAncestors(t *testing.T) {
+	assert.Nil(t, spacego.TagAncestors("work"))
+	assert.Equal(t, []string{"project", "project/alpha"}, spacego.TagAncestors("project/alpha/design"))
+	assert.Equal(t, []string{"dept:eng"}, spacego.TagAncestors("dept:eng/backend"))
+}
+
+func TestMatchTag(t *testing.T) {
+	for name, tt := range map[string]struct {
+		pattern  string
+		value    string
+		expected bool
+	}{
+		"exact":            {"work", "work", true},
+		"exact_mismatch":   {"work", "workshop", false},
+		"exact_child":      {"project", "project/alpha", false},
+		"subtree_root":     {"project/alpha/*", "project/alpha", true},
+		"subtree_child":    {"project/alpha/*", "project/alpha/design", true},
+		"subtree_sibling":  {"project/alpha/*", "project/alphabet", false},
+		"subtree_parent":   {"project/alpha/*", "project", false},
+		"namespace":        {"status:*", "status:approved", true},
+		"namespace_other":  {"status:*", "state:approved", false},
+		"namespaced_value": {"status:approved", "status:approved", true},
+		"everything":       {"*", "anything/at/all", true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, spacego.MatchTag(tt.pattern, tt.value))
+		})
+	}
+}
+
+func TestValidateTag(t *testing.T) {
+	spacego.RegisterTagNamespace("test-status", "approved", "rejected", "review/*")
+	for name, tt := range map[string]struct {
+		value string
+		err   error
+	}{
+		"flat":                {value: "work"},
+		"hierarchical":        {value: "project/alpha"},
+		"unregistered":        {value: "colour:teal"},
+		"allowed":             {value: "test-status:approved"},
+		"allowed_subtree":     {value: "test-status:review/legal"},
+		"empty":               {value: "", err: spacego.ErrInvalidTag{Value: ""}},
+		"empty_segment":       {value: "project//alpha", err: spacego.ErrInvalidTag{Value: "project//alpha"}},
+		"trailing_separator":  {value: "project/", err: spacego.ErrInvalidTag{Value: "project/"}},
+		"empty_namespace":     {value: ":approved", err: spacego.ErrInvalidTag{Value: ":approved"}},
+		"wildcard":            {value: "project/*", err: spacego.ErrInvalidTag{Value: "project/*"}},
+		"not_allowed":         {value: "test-status:pending", err: spacego.ErrTagNotAllowed{Namespace: "test-status", Value: "pending"}},
+		"not_allowed_subtree": {value: "test-status:approved/later", err: spacego.ErrTagNotAllowed{Namespace: "test-status", Value: "approved/later"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tt.err, spacego.ValidateTag(tt.value))
+		})
+	}
+}
+
+// tagValues returns the values of the tags currently applied to the file with the given metaId.
+func tagValues(t *testing.T, node *fakeNode, metaId string) []string {
+	t.Helper()
+	tags, err := spacego.CurrentTags(node, metaId)
+	testinggo.AssertNoError(t, err)
+	var values []string
+	for _, tag := range tags {
+		values = append(values, tag.Value)
+	}
+	return values
+}
+
+func TestAddTag(t *testing.T) {
+	node := newFakeNode("alice", newFakeCache())
+	metaId := writeTextFile(t, node, "notes.txt")
+	_, err := spacego.AddTag(node, nil, readHierarchy(t, node), metaId, "work", "")
+	testinggo.AssertNoError(t, err)
+	_, err = spacego.AddTag(node, nil, readHierarchy(t, node), metaId, "status:draft", "")
+	testinggo.AssertNoError(t, err)
+	assert.Equal(t, []string{"status:draft", "work"}, tagValues(t, node, metaId))
+
+	_, err = spacego.AddTag(node, nil, readHierarchy(t, node), metaId, "project/*", "")
+	assert.Equal(t, spacego.ErrInvalidTag{Value: "project/*"}, err)
+	assert.Equal(t, []string{"status:draft", "work"}, tagValues(t, node, metaId))
+}
+
+func TestRemoveTag(t *testing.T) {
+	node := newFakeNode("alice", newFakeCache())
+	metaId := writeTextFile(t, node, "notes.txt")
+	_, err := spacego.AddTag(node, nil, readHierarchy(t, node), metaId, "work", "")
+	testinggo.AssertNoError(t, err)
+	_, err = spacego.AddTag(node, nil, readHierarchy(t, node), metaId, "draft", "")
+	testinggo.AssertNoError(t, err)
+
+	_, err = spacego.RemoveTag(node, nil, readHierarchy(t, node), metaId, "draft", "Finished")
+	testinggo.AssertNoError(t, err)
+	assert.Equal(t, []string{"work"}, tagValues(t, node, metaId))
+
+	_, err = spacego.RemoveTag(node, nil, readHierarchy(t, node), metaId, "draft", "")
+	assert.Equal(t, spacego.ErrNoSuchTag{MetaId: metaId, Value: "draft"}, err)
+
+	// A removed tag can be added again
+	_, err = spacego.AddTag(node, nil, readHierarchy(t, node), metaId, "draft", "")
+	testinggo.AssertNoError(t, err)
+	assert.Equal(t, []string{"draft", "work"}, tagValues(t, node, metaId))
+}
+
+func TestRenameTag(t *testing.T) {
+	node := newFakeNode("alice", newFakeCache())
+	notes := writeTextFile(t, node, "notes.txt")
+	todo := writeTextFile(t, node, "todo.txt")
+	other := writeTextFile(t, node, "other.txt")
+	for _, id := range []string{notes, todo} {
+		_, err := spacego.AddTag(node, nil, readHierarchy(t, node), id, "wrok", "Typed")
+		testinggo.AssertNoError(t, err)
+	}
+	_, err := spacego.AddTag(node, nil, readHierarchy(t, node), todo, "urgent", "")
+	testinggo.AssertNoError(t, err)
+	_, err = spacego.AddTag(node, nil, readHierarchy(t, node), other, "personal", "")
+	testinggo.AssertNoError(t, err)
+
+	renamed, err := spacego.RenameTag(node, nil, "wrok", "work")
+	testinggo.AssertNoError(t, err)
+	assert.ElementsMatch(t, []string{notes, todo}, renamed)
+	assert.Equal(t, []string{"work"}, tagValues(t, node, notes))
+	assert.Equal(t, []string{"urgent", "work"}, tagValues(t, node, todo))
+	assert.Equal(t, []string{"personal"}, tagValues(t, node, other))
+	// The reason is kept
+	set, err := spacego.ReadTagSet(node, notes)
+	testinggo.AssertNoError(t, err)
+	assert.Equal(t, "Typed", set.Tags()[0].Reason)
+
+	// Nothing left to rename
+	renamed, err = spacego.RenameTag(node, nil, "wrok", "work")
+	testinggo.AssertNoError(t, err)
+	assert.Empty(t, renamed)
+}
@@ -0,0 +1,369 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego
+
+import (
+	"aletheiaware.com/bcgo"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"github.com/golang/protobuf/proto"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var ErrNoTagIndexAccess = errors.New("No access to tag index")
+
+// TagCount is the number of files with a tag value.
+type TagCount struct {
+	Value string
+	Count int
+}
+
+// TagIndex maps the current tag values of files to their metaIds.
+// The head of the Tag channel of each file is kept so that updating only reads blocks mined since, and records are counted per file so that reading a Tag channel again with TagCallback only applies records not already indexed.
+// TagIndex is safe for concurrent use.
+type TagIndex struct {
+	lock   sync.Mutex
+	files  map[string]*tagIndexFile
+	values map[string]map[string]bool // Tag value to metaIds
+}
+
+type tagIndexFile struct {
+	head    []byte
+	records uint64
+	tags    *TagSet
+}
+
+// savedTagIndexFile is the form of a tagIndexFile written by Save.
+type savedTagIndexFile struct {
+	Head    []byte
+	Records uint64
+	Tags    [][]byte // Marshalled Tags
+}
+
+func NewTagIndex() *TagIndex {
+	return &TagIndex{
+		files:  make(map[string]*tagIndexFile),
+		values: make(map[string]map[string]bool),
+	}
+}
+
+// TagCallback returns a TagCallback for IterateTags which indexes each record of the file with the given metaId before triggering the given callback, if any.
+func (i *TagIndex) TagCallback(metaId string, callback TagCallback) TagCallback {
+	var count uint64
+	return func(entry *bcgo.BlockEntry, tag *Tag) error {
+		i.lock.Lock()
+		f := i.file(metaId)
+		if count >= f.records {
+			i.apply(metaId, f, tag)
+		}
+		count++
+		i.lock.Unlock()
+		if callback != nil {
+			return callback(entry, tag)
+		}
+		return nil
+	}
+}
+
+// Update indexes the Tag channel of every file in the node's Meta channel which is not in the trash, and drops any other files from the index.
+func (i *TagIndex) Update(node bcgo.Node) error {
+	_, h, err := openMetaHierarchy(node)
+	if err != nil {
+		return err
+	}
+	files := make(map[string]bool)
+	for _, id := range h.Files() {
+		files[id] = true
+		if err := i.UpdateFile(node, id); err != nil {
+			return err
+		}
+	}
+	i.lock.Lock()
+	var removed []string
+	for id := range i.files {
+		if !files[id] {
+			removed = append(removed, id)
+		}
+	}
+	i.lock.Unlock()
+	for _, id := range removed {
+		i.Remove(id)
+	}
+	return nil
+}
+
+// UpdateFile indexes any records in the Tag channel of the file with the given metaId which have not already been indexed.
+// Only blocks mined since the head last indexed are read.
+func (i *TagIndex) UpdateFile(node bcgo.Node, metaId string) error {
+	tags := openTagChannel(node, metaId)
+	head := tags.Head()
+	i.lock.Lock()
+	last := i.file(metaId).head
+	i.lock.Unlock()
+	if bytes.Equal(head, last) {
+		// Up to date
+		return nil
+	}
+	if last == nil {
+		if err := IterateTags(node, tags, i.TagCallback(metaId, nil)); err != nil {
+			return err
+		}
+	} else if err := iterateSince(node, tags, last, func(entry *bcgo.BlockEntry, data []byte) error {
+		// Unmarshal as Tag
+		t := &Tag{}
+		if err := proto.Unmarshal(data, t); err != nil {
+			return err
+		}
+		i.lock.Lock()
+		i.apply(metaId, i.file(metaId), t)
+		i.lock.Unlock()
+		return nil
+	}); err != nil {
+		return err
+	}
+	i.lock.Lock()
+	i.file(metaId).head = head
+	i.lock.Unlock()
+	return nil
+}
+
+// Remove drops the file with the given metaId from the index.
+func (i *TagIndex) Remove(metaId string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	f, ok := i.files[metaId]
+	if !ok {
+		return
+	}
+	for value := range f.tags.tags {
+		i.unindex(metaId, value)
+	}
+	delete(i.files, metaId)
+}
+
+// Save writes the index to the given writer, encrypted for the given account.
+func (i *TagIndex) Save(account bcgo.Account, writer io.Writer) error {
+	i.lock.Lock()
+	files := make(map[string]*savedTagIndexFile, len(i.files))
+	for id, f := range i.files {
+		saved := &savedTagIndexFile{
+			Head:    f.head,
+			Records: f.records,
+		}
+		for _, t := range f.tags.Tags() {
+			data, err := proto.Marshal(t)
+			if err != nil {
+				i.lock.Unlock()
+				return err
+			}
+			saved.Tags = append(saved.Tags, data)
+		}
+		files[id] = saved
+	}
+	i.lock.Unlock()
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(files); err != nil {
+		return err
+	}
+	return writeIndex(account, writer, buffer.Bytes())
+}
+
+// LoadTagIndex reads an index written by Save from the given reader, decrypting it with the given account.
+func LoadTagIndex(account bcgo.Account, reader io.Reader) (*TagIndex, error) {
+	payload, err := readIndex(account, reader)
+	if err != nil {
+		return nil, err
+	}
+	if payload == nil {
+		return nil, ErrNoTagIndexAccess
+	}
+	var files map[string]*savedTagIndexFile
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&files); err != nil {
+		return nil, err
+	}
+	i := NewTagIndex()
+	for id, saved := range files {
+		f := i.file(id)
+		for _, data := range saved.Tags {
+			t := &Tag{}
+			if err := proto.Unmarshal(data, t); err != nil {
+				return nil, err
+			}
+			i.apply(id, f, t)
+		}
+		f.head = saved.Head
+		f.records = saved.Records
+	}
+	return i, nil
+}
+
+// Files returns the metaIds of the files with the given tag value, sorted.
+func (i *TagIndex) Files(value string) []string {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	var files []string
+	for id := range i.values[value] {
+		files = append(files, id)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// Match returns the metaIds of the files with a tag matching the given pattern, as used by MatchTag, sorted.
+func (i *TagIndex) Match(pattern string) []string {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	matches := make(map[string]bool)
+	for value, ids := range i.values {
+		if MatchTag(pattern, value) {
+			for id := range ids {
+				matches[id] = true
+			}
+		}
+	}
+	var files []string
+	for id := range matches {
+		files = append(files, id)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// Tags returns the current tags of the file with the given metaId, sorted by value.
+func (i *TagIndex) Tags(metaId string) []*Tag {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	f, ok := i.files[metaId]
+	if !ok {
+		return nil
+	}
+	return f.tags.Tags()
+}
+
+// Counts returns the number of files with each tag value, most used first, for a tag cloud.
+func (i *TagIndex) Counts() []*TagCount {
+	return i.Complete("", 0)
+}
+
+// Complete returns the tag values starting with the given prefix, ignoring case, most used first.
+// A limit greater than zero bounds the number of values returned.
+func (i *TagIndex) Complete(prefix string, limit int) []*TagCount {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	prefix = strings.ToLower(prefix)
+	var counts []*TagCount
+	for value, ids := range i.values {
+		if strings.HasPrefix(strings.ToLower(value), prefix) {
+			counts = append(counts, &TagCount{
+				Value: value,
+				Count: len(ids),
+			})
+		}
+	}
+	sortTagCounts(counts)
+	if limit > 0 && len(counts) > limit {
+		counts = counts[:limit]
+	}
+	return counts
+}
+
+// RollupCounts returns the number of files with each tag value, and with any tag in each level of the hierarchy, sorted by value.
+// Levels are given as patterns for NewTagFilter, so "project/*" counts the files tagged "project" or any tag below it, and "status:*" counts the files with any tag in the namespace.
+func (i *TagIndex) RollupCounts() []*TagCount {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	levels := make(map[string]map[string]bool)
+	for value := range i.values {
+		levels[value] = nil
+		if namespace, _ := ParseTag(value); namespace != "" {
+			levels[namespace+TAG_NAMESPACE_SEPARATOR+TAG_WILDCARD] = nil
+		}
+		for _, a := range TagAncestors(value) {
+			levels[a+TAG_PATH_SEPARATOR+TAG_WILDCARD] = nil
+		}
+	}
+	for level := range levels {
+		matches := make(map[string]bool)
+		for value, ids := range i.values {
+			if MatchTag(level, value) {
+				for id := range ids {
+					matches[id] = true
+				}
+			}
+		}
+		levels[level] = matches
+	}
+	var counts []*TagCount
+	for level, ids := range levels {
+		counts = append(counts, &TagCount{
+			Value: level,
+			Count: len(ids),
+		})
+	}
+	sort.Slice(counts, func(a, b int) bool {
+		return counts[a].Value < counts[b].Value
+	})
+	return counts
+}
+
+func sortTagCounts(counts []*TagCount) {
+	sort.Slice(counts, func(a, b int) bool {
+		if counts[a].Count == counts[b].Count {
+			return counts[a].Value < counts[b].Value
+		}
+		return counts[a].Count > counts[b].Count
+	})
+}
+
+func (i *TagIndex) file(metaId string) *tagIndexFile {
+	f, ok := i.files[metaId]
+	if !ok {
+		f = &tagIndexFile{
+			tags: NewTagSet(),
+		}
+		i.files[metaId] = f
+	}
+	return f
+}
+
+func (i *TagIndex) apply(metaId string, f *tagIndexFile, tag *Tag) {
+	f.tags.Apply(tag)
+	f.records++
+	if tag.Removed {
+		i.unindex(metaId, tag.Value)
+		return
+	}
+	ids, ok := i.values[tag.Value]
+	if !ok {
+		ids = make(map[string]bool)
+		i.values[tag.Value] = ids
+	}
+	ids[metaId] = true
+}
+
+func (i *TagIndex) unindex(metaId, value string) {
+	if ids, ok := i.values[value]; ok {
+		delete(ids, metaId)
+		if len(ids) == 0 {
+			delete(i.values, value)
+		}
+	}
+}
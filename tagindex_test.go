@@ -0,0 +1,219 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego_test
+
+import (
+	"aletheiaware.com/bcgo"
+	"aletheiaware.com/spacego"
+	"aletheiaware.com/testinggo"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func testTagIndex(t *testing.T) *spacego.TagIndex {
+	t.Helper()
+	i := spacego.NewTagIndex()
+	for id, tags := range map[string][]*spacego.Tag{
+		"a": {
+			{Value: "work"},
+			{Value: "draft"},
+		},
+		"b": {
+			{Value: "work"},
+			{Value: "Photo"},
+		},
+		"c": {
+			{Value: "work"},
+			{Value: "draft"},
+			{Value: "draft", Removed: true},
+			{Value: "personal"},
+		},
+	} {
+		callback := i.TagCallback(id, nil)
+		for _, tag := range tags {
+			testinggo.AssertNoError(t, callback(nil, tag))
+		}
+	}
+	return i
+}
+
+func TestTagIndex_Files(t *testing.T) {
+	i := testTagIndex(t)
+	assert.Equal(t, []string{"a", "b", "c"}, i.Files("work"))
+	assert.Equal(t, []string{"a"}, i.Files("draft"))
+	assert.Nil(t, i.Files("missing"))
+}
+
+func TestTagIndex_Counts(t *testing.T) {
+	i := testTagIndex(t)
+	assert.Equal(t, []*spacego.TagCount{
+		{Value: "work", Count: 3},
+		{Value: "Photo", Count: 1},
+		{Value: "draft", Count: 1},
+		{Value: "personal", Count: 1},
+	}, i.Counts())
+}
+
+func TestTagIndex_Complete(t *testing.T) {
+	i := testTagIndex(t)
+	assert.Equal(t, []*spacego.TagCount{
+		{Value: "Photo", Count: 1},
+		{Value: "personal", Count: 1},
+	}, i.Complete("p", 0))
+	assert.Equal(t, []*spacego.TagCount{
+		{Value: "Photo", Count: 1},
+	}, i.Complete("PH", 1))
+}
+
+func TestTagIndex_Incremental(t *testing.T) {
+	i := testTagIndex(t)
+	// Reading the Tag channel of a again only applies the new record
+	callback := i.TagCallback("a", nil)
+	for _, tag := range []*spacego.Tag{
+		{Value: "work"},
+		{Value: "draft"},
+		{Value: "work", Removed: true},
+	} {
+		testinggo.AssertNoError(t, callback(nil, tag))
+	}
+	assert.Equal(t, []string{"b", "c"}, i.Files("work"))
+	assert.Equal(t, []string{"a"}, i.Files("draft"))
+}
+
+func TestTagIndex_Remove(t *testing.T) {
+	i := testTagIndex(t)
+	i.Remove("a")
+	assert.Equal(t, []string{"b", "c"}, i.Files("work"))
+	assert.Nil(t, i.Files("draft"))
+	assert.Nil(t, i.Tags("a"))
+}
+
+func testHierarchicalTagIndex(t *testing.T) *spacego.TagIndex {
+	t.Helper()
+	i := spacego.NewTagIndex()
+	for id, values := range map[string][]string{
+		"a": {"project/alpha/design", "status:approved"},
+		"b": {"project/alpha/build", "project/beta"},
+		"c": {"project", "status:draft"},
+	} {
+		callback := i.TagCallback(id, nil)
+		for _, v := range values {
+			testinggo.AssertNoError(t, callback(nil, &spacego.Tag{Value: v}))
+		}
+	}
+	return i
+}
+
+func TestTagIndex_Match(t *testing.T) {
+	i := testHierarchicalTagIndex(t)
+	assert.Equal(t, []string{"a", "b"}, i.Match("project/alpha/*"))
+	assert.Equal(t, []string{"a", "b", "c"}, i.Match("project/*"))
+	assert.Equal(t, []string{"a", "c"}, i.Match("status:*"))
+}
+
+func TestTagIndex_RollupCounts(t *testing.T) {
+	i := testHierarchicalTagIndex(t)
+	assert.Equal(t, []*spacego.TagCount{
+		{Value: "project", Count: 1},
+		{Value: "project/*", Count: 3},
+		{Value: "project/alpha/*", Count: 2},
+		{Value: "project/alpha/build", Count: 1},
+		{Value: "project/alpha/design", Count: 1},
+		{Value: "project/beta", Count: 1},
+		{Value: "status:*", Count: 2},
+		{Value: "status:approved", Count: 1},
+		{Value: "status:draft", Count: 1},
+	}, i.RollupCounts())
+}
+
+func TestTagIndex_Update(t *testing.T) {
+	cache := newFakeCache()
+	node := newFakeNode("alice", cache)
+	notes := writeTextFile(t, node, "notes.txt")
+	todo := writeTextFile(t, node, "todo.txt")
+	first, err := spacego.AddTag(node, nil, readHierarchy(t, node), notes, "work", "")
+	testinggo.AssertNoError(t, err)
+	_, err = spacego.AddTag(node, nil, readHierarchy(t, node), notes, "urgent", "")
+	testinggo.AssertNoError(t, err)
+	_, err = spacego.AddTag(node, nil, readHierarchy(t, node), todo, "work", "")
+	testinggo.AssertNoError(t, err)
+
+	i := spacego.NewTagIndex()
+	testinggo.AssertNoError(t, i.Update(node))
+	assert.ElementsMatch(t, []string{notes, todo}, i.Files("work"))
+
+	// Only blocks mined since the last update are read, so the first block is no longer needed
+	block := blockHash(t, node, spacego.TagChannelName(notes), first)
+	testinggo.AssertNoError(t, cache.RemoveBlock(block))
+	_, err = spacego.AddTag(node, nil, readHierarchy(t, node), notes, "draft", "")
+	testinggo.AssertNoError(t, err)
+	_, err = spacego.RemoveTag(node, nil, readHierarchy(t, node), todo, "work", "")
+	testinggo.AssertNoError(t, err)
+	testinggo.AssertNoError(t, i.Update(node))
+	assert.Equal(t, []string{notes}, i.Files("work"))
+	assert.Equal(t, []string{notes}, i.Files("draft"))
+	assert.Equal(t, []string{notes}, i.Files("urgent"))
+}
+
+func TestTagIndex_SaveLoad(t *testing.T) {
+	cache := newFakeCache()
+	node := newFakeNode("alice", cache)
+	notes := writeTextFile(t, node, "notes.txt")
+	first, err := spacego.AddTag(node, nil, readHierarchy(t, node), notes, "work", "Important")
+	testinggo.AssertNoError(t, err)
+	_, err = spacego.AddTag(node, nil, readHierarchy(t, node), notes, "draft", "")
+	testinggo.AssertNoError(t, err)
+	i := spacego.NewTagIndex()
+	testinggo.AssertNoError(t, i.Update(node))
+
+	var buffer bytes.Buffer
+	testinggo.AssertNoError(t, i.Save(node.Account(), &buffer))
+
+	_, err = spacego.LoadTagIndex(&fakeAccount{alias: "bob"}, bytes.NewReader(buffer.Bytes()))
+	assert.Equal(t, spacego.ErrNoTagIndexAccess, err)
+
+	loaded, err := spacego.LoadTagIndex(node.Account(), bytes.NewReader(buffer.Bytes()))
+	testinggo.AssertNoError(t, err)
+	assert.Equal(t, i.Counts(), loaded.Counts())
+	tags := loaded.Tags(notes)
+	assert.Equal(t, 2, len(tags))
+	assert.Equal(t, "Important", tags[1].Reason)
+
+	// The loaded index continues from the saved head
+	testinggo.AssertNoError(t, cache.RemoveBlock(blockHash(t, node, spacego.TagChannelName(notes), first)))
+	_, err = spacego.AddTag(node, nil, readHierarchy(t, node), notes, "urgent", "")
+	testinggo.AssertNoError(t, err)
+	testinggo.AssertNoError(t, loaded.Update(node))
+	assert.Equal(t, []string{notes}, loaded.Files("urgent"))
+	assert.Equal(t, []string{notes}, loaded.Files("work"))
+}
+
+// blockHash returns the hash of the block in the channel with the given name which holds the given record.
+func blockHash(t *testing.T, node *fakeNode, name string, reference *bcgo.Reference) []byte {
+	t.Helper()
+	var hash []byte
+	testinggo.AssertNoError(t, bcgo.Iterate(name, node.OpenChannel(name, nil).Head(), nil, node.Cache(), nil, func(h []byte, block *bcgo.Block) error {
+		for _, e := range block.Entry {
+			if bytes.Equal(e.RecordHash, reference.RecordHash) {
+				hash = h
+			}
+		}
+		return nil
+	}))
+	return hash
+}
@@ -0,0 +1,224 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego
+
+import (
+	"aletheiaware.com/bcgo"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"sort"
+	"time"
+)
+
+const TRASH_RETENTION_PERIOD = 30 * 24 * time.Hour
+
+type ErrNotInTrash struct {
+	MetaId string
+}
+
+func (e ErrNotInTrash) Error() string {
+	return fmt.Sprintf("Not in trash: %s", e.MetaId)
+}
+
+type ErrRetentionExpired struct {
+	MetaId string
+}
+
+func (e ErrRetentionExpired) Error() string {
+	return fmt.Sprintf("Retention period expired: %s", e.MetaId)
+}
+
+type ErrPurged struct {
+	MetaId string
+}
+
+func (e ErrPurged) Error() string {
+	return fmt.Sprintf("Purged: %s", e.MetaId)
+}
+
+// BlockRemover removes the blocks and heads stored by a cache, such as a cache of the local file system.
+type BlockRemover interface {
+	RemoveBlock([]byte) error
+	RemoveHead(string) error
+}
+
+// Trash returns the metaIds of the files in the trash, most recently trashed first.
+func (h *Hierarchy) Trash() []string {
+	var trash []string
+	for id, f := range h.files {
+		if m := f.latest().Meta; m.Trashed != 0 && !m.Purged {
+			trash = append(trash, id)
+		}
+	}
+	sort.Slice(trash, func(i, j int) bool {
+		a := h.files[trash[i]].latest().Meta.Trashed
+		b := h.files[trash[j]].latest().Meta.Trashed
+		if a == b {
+			return trash[i] < trash[j]
+		}
+		return a > b
+	})
+	return trash
+}
+
+// IsExpired returns true if the given Meta has been in the trash longer than the retention period at the given time.
+func IsExpired(meta *Meta, now uint64) bool {
+	return meta.Trashed != 0 && now > meta.Trashed && time.Duration(now-meta.Trashed) > TRASH_RETENTION_PERIOD
+}
+
+// Delete writes a tombstone version of the Meta with the given metaId which moves the file to the trash.
+func Delete(node bcgo.Node, listener bcgo.MiningListener, metaId string) (*bcgo.Reference, error) {
+	return updateMeta(node, listener, metaId, func(h *Hierarchy, meta *Meta) error {
+		meta.Trashed = bcgo.Timestamp()
+		return nil
+	})
+}
+
+// Restore writes a new version of the Meta with the given metaId which takes the file out of the trash.
+func Restore(node bcgo.Node, listener bcgo.MiningListener, metaId string) (*bcgo.Reference, error) {
+	return updateMeta(node, listener, metaId, func(h *Hierarchy, meta *Meta) error {
+		if meta.Trashed == 0 {
+			return ErrNotInTrash{MetaId: metaId}
+		}
+		if IsExpired(meta, bcgo.Timestamp()) {
+			return ErrRetentionExpired{MetaId: metaId}
+		}
+		meta.Trashed = 0
+		return nil
+	})
+}
+
+// ListTrash triggers the given callback for the latest version of each file in the trash, most recently trashed first.
+func ListTrash(node bcgo.Node, callback func(string, *Meta) error) error {
+	_, h, err := openMetaHierarchy(node)
+	if err != nil {
+		return err
+	}
+	for _, id := range h.Trash() {
+		if err := callback(id, h.Meta(id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Purge permanently deletes the file with the given metaId, which must be in the trash, and every file within it if it is a directory.
+// A final version of each is written to mark it as purged, so no further versions can be written and it no longer appears in the trash.
+// Purging does not revoke access to records already written, which remain encrypted for the node's account on the network as the keys already given cannot be withdrawn.
+// It only refuses new writes; Access returns ErrPurged for the files, so no new records, and no keys to them, are written to their Delta, Preview and Tag channels.
+// If a BlockRemover is given, the blocks of those channels are also removed from it, such as the node's cache.
+func Purge(node bcgo.Node, listener bcgo.MiningListener, remover BlockRemover, metaId string) (*bcgo.Reference, error) {
+	metas, h, err := openMetaHierarchy(node)
+	if err != nil {
+		return nil, err
+	}
+	latest := h.Meta(metaId)
+	if latest == nil || latest.Purged {
+		return nil, ErrNoSuchMeta{MetaId: metaId}
+	}
+	if latest.Trashed == 0 {
+		return nil, ErrNotInTrash{MetaId: metaId}
+	}
+	ids := []string{metaId}
+	for _, id := range h.all() {
+		if id != metaId && h.IsAncestor(metaId, id) {
+			ids = append(ids, id)
+		}
+	}
+	var reference *bcgo.Reference
+	for _, id := range ids {
+		meta := proto.Clone(h.Meta(id)).(*Meta)
+		meta.Purged = true
+		data, err := proto.Marshal(meta)
+		if err != nil {
+			return nil, err
+		}
+		r, err := node.Write(bcgo.Timestamp(), metas, []bcgo.Identity{node.Account()}, []*bcgo.Reference{h.Reference(id)}, data)
+		if err != nil {
+			return nil, err
+		}
+		if id == metaId {
+			reference = r
+		}
+	}
+	if _, _, err := node.Mine(metas, Threshold(metas.Name()), listener); err != nil {
+		return nil, err
+	}
+	if remover == nil {
+		return reference, nil
+	}
+	cache := node.Cache()
+	for _, id := range ids {
+		for _, name := range []string{
+			DeltaChannelName(id),
+			PreviewChannelName(id),
+			TagChannelName(id),
+		} {
+			if err := purgeChannel(cache, remover, name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return reference, nil
+}
+
+// EmptyTrash purges every file which has been in the trash longer than the retention period, removing their blocks from the given BlockRemover, if any.
+func EmptyTrash(node bcgo.Node, listener bcgo.MiningListener, remover BlockRemover) error {
+	_, h, err := openMetaHierarchy(node)
+	if err != nil {
+		return err
+	}
+	now := bcgo.Timestamp()
+	for _, id := range h.Trash() {
+		if !IsExpired(h.Meta(id), now) {
+			continue
+		}
+		if _, err := Purge(node, listener, remover, id); err != nil {
+			switch err.(type) {
+			case ErrNoSuchMeta:
+				// Already purged with its directory
+				break
+			default:
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Access returns the identities to give access to new records in the Delta, Preview and Tag channels of the file with the given metaId, or ErrPurged if the file has been purged.
+// Access refuses new writes to a purged file but cannot revoke access to the records already written.
+func (h *Hierarchy) Access(account bcgo.Account, metaId string) ([]bcgo.Identity, error) {
+	if meta := h.Meta(metaId); meta != nil && meta.Purged {
+		return nil, ErrPurged{MetaId: metaId}
+	}
+	return []bcgo.Identity{account}, nil
+}
+
+func purgeChannel(cache bcgo.Cache, remover BlockRemover, channel string) error {
+	head, err := cache.Head(channel)
+	if err != nil || head == nil {
+		// Nothing cached
+		return nil
+	}
+	if err := bcgo.Iterate(channel, head.BlockHash, nil, cache, nil, func(hash []byte, block *bcgo.Block) error {
+		return remover.RemoveBlock(hash)
+	}); err != nil {
+		return err
+	}
+	return remover.RemoveHead(channel)
+}
@@ -0,0 +1,165 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego_test
+
+import (
+	"aletheiaware.com/bcgo"
+	"aletheiaware.com/spacego"
+	"aletheiaware.com/testinggo"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestHierarchyTrash(t *testing.T) {
+	h := testHierarchy()
+	notes := spacego.MetaId([]byte("notes"))
+	report := spacego.MetaId([]byte("report"))
+	h.Add(testEntry("trash1", 5, testReference("notes")), &spacego.Meta{
+		Name:    "notes.txt",
+		Type:    spacego.MIME_TYPE_TEXT_PLAIN,
+		Trashed: 5,
+	})
+	h.Add(testEntry("trash2", 6, testReference("report")), &spacego.Meta{
+		Name:    "report.pdf",
+		Type:    spacego.MIME_TYPE_PDF,
+		Parent:  spacego.MetaId([]byte("2026")),
+		Trashed: 6,
+	})
+	assert.True(t, h.IsDeleted(notes))
+	assert.Equal(t, []string{report, notes}, h.Trash())
+	assert.Equal(t, []string{spacego.MetaId([]byte("docs"))}, h.Children(""))
+	_, err := h.Resolve("/docs/2026/report.pdf")
+	assert.Equal(t, spacego.ErrNoSuchPath{Path: "/docs/2026/report.pdf"}, err)
+
+	// Restore
+	h.Add(testEntry("restore", 7, testReference("report")), &spacego.Meta{
+		Name:   "report.pdf",
+		Type:   spacego.MIME_TYPE_PDF,
+		Parent: spacego.MetaId([]byte("2026")),
+	})
+	id, err := h.Resolve("/docs/2026/report.pdf")
+	assert.Nil(t, err)
+	assert.Equal(t, report, id)
+
+	// Purge
+	h.Add(testEntry("purge", 8, testReference("notes")), &spacego.Meta{
+		Name:    "notes.txt",
+		Type:    spacego.MIME_TYPE_TEXT_PLAIN,
+		Trashed: 5,
+		Purged:  true,
+	})
+	assert.True(t, h.IsDeleted(notes))
+	assert.Empty(t, h.Trash())
+}
+
+func TestHierarchyTrash_Directory(t *testing.T) {
+	h := testHierarchy()
+	docs := spacego.MetaId([]byte("docs"))
+	h.Add(testEntry("trash", 5, testReference("docs")), &spacego.Meta{
+		Name:    "docs",
+		Type:    spacego.MIME_TYPE_DIRECTORY,
+		Trashed: 5,
+	})
+	assert.True(t, h.IsDeleted(docs))
+	assert.True(t, h.IsDeleted(spacego.MetaId([]byte("2026"))))
+	assert.True(t, h.IsDeleted(spacego.MetaId([]byte("report"))))
+	assert.False(t, h.IsDeleted(spacego.MetaId([]byte("notes"))))
+	assert.Equal(t, []string{docs}, h.Trash())
+}
+
+func TestPurge(t *testing.T) {
+	cache := newFakeCache()
+	node := newFakeNode("alice", cache)
+	docs, err := spacego.CreateDirectory(node, nil, "", "docs")
+	testinggo.AssertNoError(t, err)
+	docsId := spacego.MetaId(docs.RecordHash)
+	data, err := proto.Marshal(&spacego.Meta{
+		Name:   "report.txt",
+		Type:   spacego.MIME_TYPE_TEXT_PLAIN,
+		Parent: docsId,
+	})
+	testinggo.AssertNoError(t, err)
+	reportId := spacego.MetaId(writeRecord(t, node, spacego.MetaChannelName("alice"), nil, data).RecordHash)
+	data, err = proto.Marshal(&spacego.Delta{
+		Insert: []byte("foobar"),
+	})
+	testinggo.AssertNoError(t, err)
+	writeRecord(t, node, spacego.DeltaChannelName(reportId), nil, data)
+	_, err = spacego.AddTag(node, nil, readHierarchy(t, node), reportId, "invoice", "")
+	testinggo.AssertNoError(t, err)
+
+	metas := func() []string {
+		var names []string
+		testinggo.AssertNoError(t, spacego.ReadMeta(node.OpenChannel(spacego.MetaChannelName("alice"), nil), cache, nil, node.Account(), nil, func(entry *bcgo.BlockEntry, meta *spacego.Meta) error {
+			names = append(names, meta.Name)
+			return nil
+		}))
+		return names
+	}
+	assert.ElementsMatch(t, []string{"docs", "report.txt"}, metas())
+
+	_, err = spacego.Purge(node, nil, cache, docsId)
+	assert.Equal(t, spacego.ErrNotInTrash{MetaId: docsId}, err)
+
+	// Trashing a directory hides the files within it
+	_, err = spacego.Delete(node, nil, docsId)
+	testinggo.AssertNoError(t, err)
+	assert.Empty(t, metas())
+
+	_, err = spacego.Purge(node, nil, cache, docsId)
+	testinggo.AssertNoError(t, err)
+	testinggo.AssertNoError(t, spacego.ListTrash(node, func(id string, meta *spacego.Meta) error {
+		t.Errorf("Unexpected file in trash: %s", meta.Name)
+		return nil
+	}))
+	// Blocks of files within the directory are removed
+	_, err = cache.Head(spacego.DeltaChannelName(reportId))
+	assert.Error(t, err)
+	_, err = cache.Head(spacego.TagChannelName(reportId))
+	assert.Error(t, err)
+	// New writes to files within the directory are refused
+	h := readHierarchy(t, node)
+	_, err = spacego.AddTag(node, nil, h, reportId, "archived", "")
+	assert.Equal(t, spacego.ErrPurged{MetaId: reportId}, err)
+	_, err = h.Access(node.Account(), reportId)
+	assert.Equal(t, spacego.ErrPurged{MetaId: reportId}, err)
+	_, err = spacego.Restore(node, nil, docsId)
+	assert.Equal(t, spacego.ErrNoSuchMeta{MetaId: docsId}, err)
+}
+
+func TestIsExpired(t *testing.T) {
+	trashed := uint64(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano())
+	meta := &spacego.Meta{
+		Trashed: trashed,
+	}
+	assert.False(t, spacego.IsExpired(meta, trashed))
+	assert.False(t, spacego.IsExpired(meta, trashed+uint64(spacego.TRASH_RETENTION_PERIOD)))
+	assert.True(t, spacego.IsExpired(meta, trashed+uint64(spacego.TRASH_RETENTION_PERIOD)+1))
+	assert.False(t, spacego.IsExpired(&spacego.Meta{}, trashed))
+}
+
+// readHierarchy reads the node's Meta channel into a Hierarchy.
+func readHierarchy(t *testing.T, node *fakeNode) *spacego.Hierarchy {
+	t.Helper()
+	h, err := spacego.ReadHierarchy(node.OpenChannel(spacego.MetaChannelName(node.Account().Alias()), nil), node.Cache(), nil, node.Account())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego
+
+import (
+	"aletheiaware.com/bcgo"
+	"github.com/golang/protobuf/proto"
+)
+
+// MetaVersion is one version of a file's Meta, as written to the Meta channel.
+type MetaVersion struct {
+	Timestamp  uint64
+	RecordHash []byte
+	Meta       *Meta
+}
+
+// MetaOrigin returns a reference to the first version of the file whose Meta is held in the given entry of the given Meta channel.
+// A Meta record that references another record in the same channel is a new version of that file, otherwise it is the first version.
+// The metaId of a file, and so the names of its Delta, Preview, and Tag channels, always come from its first version.
+func MetaOrigin(metas string, entry *bcgo.BlockEntry) *bcgo.Reference {
+	for _, r := range entry.Record.Reference {
+		if r.ChannelName == metas {
+			return r
+		}
+	}
+	return &bcgo.Reference{
+		Timestamp:   entry.Record.Timestamp,
+		ChannelName: metas,
+		BlockHash:   entry.BlockHash,
+		RecordHash:  entry.RecordHash,
+	}
+}
+
+// ReadMetaHistory triggers the given callback for each version of the Meta with the given metaId, oldest first.
+func ReadMetaHistory(metas bcgo.Channel, cache bcgo.Cache, network bcgo.Network, account bcgo.Account, metaId string, callback func(*MetaVersion) error) error {
+	h, err := ReadHierarchy(metas, cache, network, account)
+	if err != nil {
+		return err
+	}
+	versions := h.History(metaId)
+	if len(versions) == 0 {
+		return ErrNoSuchMeta{MetaId: metaId}
+	}
+	for _, v := range versions {
+		if err := callback(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadLatestMeta returns the latest version of the Meta with the given metaId.
+func ReadLatestMeta(metas bcgo.Channel, cache bcgo.Cache, network bcgo.Network, account bcgo.Account, metaId string) (*Meta, error) {
+	h, err := ReadHierarchy(metas, cache, network, account)
+	if err != nil {
+		return nil, err
+	}
+	meta := h.Meta(metaId)
+	if meta == nil {
+		return nil, ErrNoSuchMeta{MetaId: metaId}
+	}
+	return meta, nil
+}
+
+// Rename writes a new version of the Meta with the given metaId with the given name.
+func Rename(node bcgo.Node, listener bcgo.MiningListener, metaId, name string) (*bcgo.Reference, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+	return updateMeta(node, listener, metaId, func(h *Hierarchy, meta *Meta) error {
+		if err := h.checkName(metaId, meta.Parent, name); err != nil {
+			return err
+		}
+		meta.Name = name
+		return nil
+	})
+}
+
+// updateMeta writes a new version of the Meta with the given metaId after applying the given change to a copy of the latest version.
+// The new version references the first, so the file keeps its metaId and its Delta, Preview and Tag channels.
+func updateMeta(node bcgo.Node, listener bcgo.MiningListener, metaId string, change func(*Hierarchy, *Meta) error) (*bcgo.Reference, error) {
+	metas, h, err := openMetaHierarchy(node)
+	if err != nil {
+		return nil, err
+	}
+	latest := h.Meta(metaId)
+	if latest == nil || latest.Purged {
+		return nil, ErrNoSuchMeta{MetaId: metaId}
+	}
+	meta := proto.Clone(latest).(*Meta)
+	if err := change(h, meta); err != nil {
+		return nil, err
+	}
+	return write(node, listener, metas, []*bcgo.Reference{h.Reference(metaId)}, meta)
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego_test
+
+import (
+	"aletheiaware.com/bcgo"
+	"aletheiaware.com/spacego"
+	"aletheiaware.com/testinggo"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMetaOrigin(t *testing.T) {
+	t.Run("First", func(t *testing.T) {
+		entry := testEntry("foo", 1)
+		entry.BlockHash = []byte("block")
+		assert.Equal(t, &bcgo.Reference{
+			Timestamp:   1,
+			ChannelName: testMetaChannel,
+			BlockHash:   []byte("block"),
+			RecordHash:  []byte("foo"),
+		}, spacego.MetaOrigin(testMetaChannel, entry))
+	})
+	t.Run("Version", func(t *testing.T) {
+		entry := testEntry("bar", 2, &bcgo.Reference{
+			ChannelName: "Space-Delta-foo",
+			RecordHash:  []byte("delta"),
+		}, testReference("foo"))
+		assert.Equal(t, testReference("foo"), spacego.MetaOrigin(testMetaChannel, entry))
+	})
+}
+
+func TestHierarchyHistory(t *testing.T) {
+	h := spacego.NewHierarchy(testMetaChannel)
+	// Read newest first, as from the channel head
+	h.Add(testEntry("rename2", 3, testReference("foo")), &spacego.Meta{
+		Name: "baz.txt",
+	})
+	h.Add(testEntry("rename1", 2, testReference("foo")), &spacego.Meta{
+		Name: "bar.txt",
+	})
+	h.Add(testEntry("foo", 1), &spacego.Meta{
+		Name: "foo.txt",
+	})
+	// Duplicates are ignored
+	h.Add(testEntry("rename1", 2, testReference("foo")), &spacego.Meta{
+		Name: "bar.txt",
+	})
+	metaId := spacego.MetaId([]byte("foo"))
+	assert.Equal(t, "baz.txt", h.Meta(metaId).Name)
+	var names []string
+	var timestamps []uint64
+	for _, v := range h.History(metaId) {
+		names = append(names, v.Meta.Name)
+		timestamps = append(timestamps, v.Timestamp)
+	}
+	assert.Equal(t, []string{"foo.txt", "bar.txt", "baz.txt"}, names)
+	assert.Equal(t, []uint64{1, 2, 3}, timestamps)
+	assert.Nil(t, h.History(spacego.MetaId([]byte("bar"))))
+}
+
+func TestRename_NameExists(t *testing.T) {
+	node := newFakeNode("alice", newFakeCache())
+	_, err := spacego.CreateDirectory(node, nil, "", "docs")
+	testinggo.AssertNoError(t, err)
+	notes, err := spacego.CreateDirectory(node, nil, "", "notes")
+	testinggo.AssertNoError(t, err)
+	notesId := spacego.MetaId(notes.RecordHash)
+	_, err = spacego.Rename(node, nil, notesId, "docs")
+	assert.Equal(t, spacego.ErrNameExists{Name: "docs"}, err)
+	// Renaming a file to its own name is allowed
+	_, err = spacego.Rename(node, nil, notesId, "notes")
+	testinggo.AssertNoError(t, err)
+}
+
+func TestReadMeta_Latest(t *testing.T) {
+	cache := newFakeCache()
+	node := newFakeNode("alice", cache)
+	_, err := spacego.CreateDirectory(node, nil, "", "docs")
+	testinggo.AssertNoError(t, err)
+	notes, err := spacego.CreateDirectory(node, nil, "", "notes")
+	testinggo.AssertNoError(t, err)
+	_, err = spacego.Rename(node, nil, spacego.MetaId(notes.RecordHash), "todo")
+	testinggo.AssertNoError(t, err)
+	var names []string
+	testinggo.AssertNoError(t, spacego.ReadMeta(node.OpenChannel(spacego.MetaChannelName("alice"), nil), cache, nil, node.Account(), nil, func(entry *bcgo.BlockEntry, meta *spacego.Meta) error {
+		names = append(names, meta.Name)
+		return nil
+	}))
+	assert.ElementsMatch(t, []string{"docs", "todo"}, names)
+}
@@ -0,0 +1,219 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego
+
+import (
+	"aletheiaware.com/bcgo"
+	"bytes"
+	"log"
+	"sync"
+	"time"
+)
+
+const PREVIEW_REGENERATION_DELAY = 5 * time.Second
+
+// PreviewVersion returns the reference to the head of the Delta channel from which the latest preview of the file with the given metaId was generated, or nil if there is none.
+func PreviewVersion(node bcgo.Node, metaId string) (*bcgo.Reference, error) {
+	previews := node.OpenChannel(PreviewChannelName(metaId), func() bcgo.Channel {
+		return OpenPreviewChannel(metaId)
+	})
+	if err := previews.Refresh(node.Cache(), node.Network()); err != nil {
+		log.Println(err)
+	}
+	var version *bcgo.Reference
+	if err := ReadPreview(previews, node.Cache(), node.Network(), node.Account(), nil, func(entry *bcgo.BlockEntry, preview *Preview) error {
+		if version = previewVersion(entry, metaId); version != nil {
+			return bcgo.ErrStopIteration{}
+		}
+		return nil
+	}); err != nil {
+		switch err.(type) {
+		case bcgo.ErrStopIteration:
+			// Do nothing
+			break
+		default:
+			return nil, err
+		}
+	}
+	return version, nil
+}
+
+// previewVersion returns the reference to the Delta channel of the file with the given metaId in the given preview entry, or nil if there is none.
+func previewVersion(entry *bcgo.BlockEntry, metaId string) *bcgo.Reference {
+	deltas := DeltaChannelName(metaId)
+	for _, r := range entry.Record.Reference {
+		if r.ChannelName == deltas {
+			return r
+		}
+	}
+	return nil
+}
+
+// RegeneratePreviews generates the previews of the file with the given metaId from its current content and writes them to its Preview channel.
+// Each preview references the head of the Delta channel it was generated from, and nothing is written if the latest previews were generated from the current head.
+func RegeneratePreviews(node bcgo.Node, listener bcgo.MiningListener, metaId string) ([]*bcgo.Reference, error) {
+	_, h, err := openMetaHierarchy(node)
+	if err != nil {
+		return nil, err
+	}
+	meta := h.Meta(metaId)
+	if meta == nil {
+		return nil, ErrNoSuchMeta{MetaId: metaId}
+	}
+	access, err := h.Access(node.Account(), metaId)
+	if err != nil {
+		return nil, err
+	}
+	deltas := node.OpenChannel(DeltaChannelName(metaId), func() bcgo.Channel {
+		return OpenDeltaChannel(metaId)
+	})
+	if err := deltas.Refresh(node.Cache(), node.Network()); err != nil {
+		log.Println(err)
+	}
+	head := deltas.Head()
+	if head == nil {
+		// No content
+		return nil, nil
+	}
+	version, err := PreviewVersion(node, metaId)
+	if err != nil {
+		return nil, err
+	}
+	if version != nil && bytes.Equal(version.BlockHash, head) {
+		// Up to date
+		return nil, nil
+	}
+	content, err := readContent(node, metaId)
+	if err != nil {
+		return nil, err
+	}
+	// Reference the head the content was read from, which may be newer than that checked above
+	reference := &bcgo.Reference{
+		Timestamp:   deltas.Timestamp(),
+		ChannelName: deltas.Name(),
+		BlockHash:   deltas.Head(),
+	}
+	previews, err := GeneratePreviews(meta, bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	return writePreviews(node, listener, metaId, access, []*bcgo.Reference{reference}, previews)
+}
+
+// PreviewWatcher regenerates the previews of watched files when the heads of their Delta channels change.
+// A burst of changes to a file is debounced so its previews are regenerated once the file has not changed for the delay.
+type PreviewWatcher struct {
+	node     bcgo.Node
+	listener bcgo.MiningListener
+	delay    time.Duration
+	lock     sync.Mutex
+	watching map[string]bool
+	timers   map[string]*previewTimer
+	counter  uint64
+	stopped  bool
+	// Regenerations are serialized as they write and mine with the same node
+	regenerating sync.Mutex
+}
+
+func NewPreviewWatcher(node bcgo.Node, listener bcgo.MiningListener, delay time.Duration) *PreviewWatcher {
+	return &PreviewWatcher{
+		node:     node,
+		listener: listener,
+		delay:    delay,
+		watching: make(map[string]bool),
+		timers:   make(map[string]*previewTimer),
+	}
+}
+
+// previewTimer is a scheduled regeneration, identified by a generation so a timer which fired while being superseded does nothing.
+type previewTimer struct {
+	timer      *time.Timer
+	generation uint64
+}
+
+// Watch adds a trigger to the Delta channel of the file with the given metaId which schedules its previews to be regenerated.
+func (w *PreviewWatcher) Watch(metaId string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.stopped || w.watching[metaId] {
+		return
+	}
+	w.watching[metaId] = true
+	w.node.OpenChannel(DeltaChannelName(metaId), func() bcgo.Channel {
+		return OpenDeltaChannel(metaId)
+	}).AddTrigger(func() {
+		w.Schedule(metaId)
+	})
+}
+
+// Schedule regenerates the previews of the file with the given metaId after the delay, postponing any regeneration already scheduled.
+func (w *PreviewWatcher) Schedule(metaId string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.stopped {
+		return
+	}
+	if t, ok := w.timers[metaId]; ok {
+		t.timer.Stop()
+		delete(w.timers, metaId)
+	}
+	w.counter++
+	generation := w.counter
+	w.timers[metaId] = &previewTimer{
+		timer: time.AfterFunc(w.delay, func() {
+			w.regenerate(metaId, generation)
+		}),
+		generation: generation,
+	}
+}
+
+// Stop cancels all scheduled regenerations and ignores any further changes.
+func (w *PreviewWatcher) Stop() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.stopped = true
+	for id, t := range w.timers {
+		t.timer.Stop()
+		delete(w.timers, id)
+	}
+}
+
+func (w *PreviewWatcher) regenerate(metaId string, generation uint64) {
+	w.lock.Lock()
+	t, ok := w.timers[metaId]
+	if w.stopped || !ok || t.generation != generation {
+		// Stopped, or superseded by a later schedule
+		w.lock.Unlock()
+		return
+	}
+	delete(w.timers, metaId)
+	w.lock.Unlock()
+	w.regenerating.Lock()
+	defer w.regenerating.Unlock()
+	if _, err := RegeneratePreviews(w.node, w.listener, metaId); err != nil {
+		switch err.(type) {
+		case ErrNoPreviewGenerator:
+			// File type has no previews
+			break
+		case ErrPurged:
+			// File was purged while scheduled
+			break
+		default:
+			log.Println(err)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+/*
+ * Copyright 2021 Aletheia Ware LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spacego_test
+
+import (
+	"aletheiaware.com/bcgo"
+	"aletheiaware.com/spacego"
+	"aletheiaware.com/testinggo"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+const testDelay = 20 * time.Millisecond
+
+// writeTextFile writes the meta of a plain text file with the given name and returns its metaId.
+func writeTextFile(t *testing.T, node *fakeNode, name string) string {
+	t.Helper()
+	data, err := proto.Marshal(&spacego.Meta{
+		Name: name,
+		Type: spacego.MIME_TYPE_TEXT_PLAIN,
+	})
+	testinggo.AssertNoError(t, err)
+	return spacego.MetaId(writeRecord(t, node, spacego.MetaChannelName(node.Account().Alias()), nil, data).RecordHash)
+}
+
+// appendText writes a delta appending the given text to the file with the given metaId, which has the given length.
+func appendText(t *testing.T, node *fakeNode, metaId string, offset uint64, text string) {
+	t.Helper()
+	data, err := proto.Marshal(&spacego.Delta{
+		Offset: offset,
+		Insert: []byte(text),
+	})
+	testinggo.AssertNoError(t, err)
+	writeRecord(t, node, spacego.DeltaChannelName(metaId), nil, data)
+}
+
+// previewBlocks returns the number of blocks in the Preview channel of the file with the given metaId.
+func previewBlocks(t *testing.T, node *fakeNode, metaId string) int {
+	t.Helper()
+	var count int
+	testinggo.AssertNoError(t, bcgo.Iterate(spacego.PreviewChannelName(metaId), node.OpenChannel(spacego.PreviewChannelName(metaId), nil).Head(), nil, node.Cache(), nil, func([]byte, *bcgo.Block) error {
+		count++
+		return nil
+	}))
+	return count
+}
+
+func TestRegeneratePreviews(t *testing.T) {
+	node := newFakeNode("alice", newFakeCache())
+	metaId := writeTextFile(t, node, "notes.txt")
+
+	// No content
+	references, err := spacego.RegeneratePreviews(node, nil, metaId)
+	testinggo.AssertNoError(t, err)
+	assert.Empty(t, references)
+
+	appendText(t, node, metaId, 0, "Hello")
+	references, err = spacego.RegeneratePreviews(node, nil, metaId)
+	testinggo.AssertNoError(t, err)
+	assert.Equal(t, 1, len(references))
+	version, err := spacego.PreviewVersion(node, metaId)
+	testinggo.AssertNoError(t, err)
+	assert.Equal(t, node.OpenChannel(spacego.DeltaChannelName(metaId), nil).Head(), version.BlockHash)
+
+	// Up to date
+	references, err = spacego.RegeneratePreviews(node, nil, metaId)
+	testinggo.AssertNoError(t, err)
+	assert.Empty(t, references)
+	assert.Equal(t, 1, previewBlocks(t, node, metaId))
+
+	appendText(t, node, metaId, 5, " World")
+	references, err = spacego.RegeneratePreviews(node, nil, metaId)
+	testinggo.AssertNoError(t, err)
+	assert.Equal(t, 1, len(references))
+	assert.Equal(t, 2, previewBlocks(t, node, metaId))
+	preview, err := spacego.BestPreview(node, metaId, 0, 0)
+	testinggo.AssertNoError(t, err)
+	assert.Equal(t, "Hello World", string(preview.Data))
+
+	_, err = spacego.RegeneratePreviews(node, nil, "missing")
+	assert.Equal(t, spacego.ErrNoSuchMeta{MetaId: "missing"}, err)
+}
+
+func TestPreviewWatcher(t *testing.T) {
+	t.Run("Debounce", func(t *testing.T) {
+		node := newFakeNode("alice", newFakeCache())
+		metaId := writeTextFile(t, node, "notes.txt")
+		watcher := spacego.NewPreviewWatcher(node, nil, testDelay)
+		defer watcher.Stop()
+		watcher.Watch(metaId)
+		// Watching again does not add another trigger
+		watcher.Watch(metaId)
+
+		var length uint64
+		for _, s := range []string{"a", "b", "c"} {
+			appendText(t, node, metaId, length, s)
+			length += uint64(len(s))
+		}
+		deadline := time.Now().Add(time.Second)
+		for previewBlocks(t, node, metaId) == 0 && time.Now().Before(deadline) {
+			time.Sleep(testDelay)
+		}
+		// Give any further regenerations time to happen
+		time.Sleep(3 * testDelay)
+		assert.Equal(t, 1, previewBlocks(t, node, metaId))
+		preview, err := spacego.BestPreview(node, metaId, 0, 0)
+		testinggo.AssertNoError(t, err)
+		assert.Equal(t, "abc", string(preview.Data))
+	})
+	t.Run("Stop", func(t *testing.T) {
+		node := newFakeNode("alice", newFakeCache())
+		metaId := writeTextFile(t, node, "notes.txt")
+		appendText(t, node, metaId, 0, "abc")
+		watcher := spacego.NewPreviewWatcher(node, nil, testDelay)
+		watcher.Schedule(metaId)
+		watcher.Stop()
+		// Changes after stopping are ignored
+		watcher.Watch(metaId)
+		watcher.Schedule(metaId)
+		appendText(t, node, metaId, 3, "def")
+		time.Sleep(3 * testDelay)
+		assert.Equal(t, 0, previewBlocks(t, node, metaId))
+	})
+}